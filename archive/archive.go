@@ -0,0 +1,299 @@
+// Package archive reads and writes gelatin export archives.
+//
+// An archive holds the users of a server (including their policy and configuration) and,
+// for each user, the library items they have interacted with along with their activity.
+// Items carry their provider IDs, so that they can be matched against another server's
+// library when the archive is imported.
+//
+// Archives come in two formats, chosen by file extension:
+//
+// - .json: a single JSON document (see Archive)
+// - .jsonl or .jsonl.gz: one JSON record per line, optionally gzipped, for large libraries
+//
+// Passwords are never exported.
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	gelatin "github.com/aksiksi/gelatin/lib"
+)
+
+// Version is the current archive format version. Archives written by a newer version of
+// gelatin cannot be read.
+const Version = 1
+
+// Header describes the server an archive was exported from
+type Header struct {
+	Version   int
+	CreatedAt time.Time
+
+	// Type of the server (emby or jellyfin), if known
+	ServerType string `json:",omitempty"`
+	Server     gelatin.GelatinSystemInfo
+
+	// Top-level library folders, used to translate folder IDs in user policies
+	MediaFolders []gelatin.GelatinLibraryItem
+}
+
+// User is a single exported user
+type User struct {
+	gelatin.GelatinUser
+
+	// Items the user has interacted with, along with the series and seasons that contain them
+	Items []gelatin.GelatinLibraryItem `json:",omitempty"`
+}
+
+// Archive is the content of an export archive
+type Archive struct {
+	Header
+	Users []User
+}
+
+// record is a single line of a JSONL archive. Exactly one field is set.
+//
+// The header comes first, and each user comes before their items.
+type record struct {
+	Header *Header              `json:",omitempty"`
+	User   *gelatin.GelatinUser `json:",omitempty"`
+
+	// Item, along with the ID of the user whose activity it holds
+	Item   *gelatin.GelatinLibraryItem `json:",omitempty"`
+	UserId string                      `json:",omitempty"`
+}
+
+type format int
+
+const (
+	formatJSON format = iota
+	formatJSONL
+)
+
+// formatOf returns the archive format for a path, and whether it is gzipped
+func formatOf(path string) (format, bool, error) {
+	gzipped := strings.HasSuffix(path, ".gz")
+	name := strings.TrimSuffix(path, ".gz")
+
+	switch {
+	case strings.HasSuffix(name, ".jsonl"):
+		return formatJSONL, gzipped, nil
+	case strings.HasSuffix(name, ".json"):
+		return formatJSON, gzipped, nil
+	default:
+		return 0, false, fmt.Errorf("unknown archive format for %s: expected .json, .jsonl or .jsonl.gz", path)
+	}
+}
+
+// Writer writes an archive.
+//
+// The header must be written first. Each user must be written before their items.
+type Writer struct {
+	file *os.File
+	gz   *gzip.Writer
+	buf  *bufio.Writer
+	enc  *json.Encoder
+
+	// JSON archives are written as a single document, so they are built in memory
+	archive *Archive
+}
+
+// Create creates a new archive at path, in the format given by its extension.
+//
+// The file must not already exist.
+func Create(path string) (*Writer, error) {
+	format, gzipped, err := formatOf(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{file: file}
+
+	var out io.Writer = file
+	if gzipped {
+		w.gz = gzip.NewWriter(file)
+		out = w.gz
+	}
+	w.buf = bufio.NewWriter(out)
+	w.enc = json.NewEncoder(w.buf)
+
+	if format == formatJSON {
+		w.archive = &Archive{}
+	}
+
+	return w, nil
+}
+
+func (w *Writer) WriteHeader(header *Header) error {
+	if w.archive != nil {
+		w.archive.Header = *header
+		return nil
+	}
+
+	return w.enc.Encode(&record{Header: header})
+}
+
+func (w *Writer) WriteUser(user *gelatin.GelatinUser) error {
+	if w.archive != nil {
+		w.archive.Users = append(w.archive.Users, User{GelatinUser: *user})
+		return nil
+	}
+
+	return w.enc.Encode(&record{User: user})
+}
+
+// WriteItem writes an item holding the activity of the given user
+func (w *Writer) WriteItem(userId string, item *gelatin.GelatinLibraryItem) error {
+	if w.archive != nil {
+		users := w.archive.Users
+		if len(users) == 0 || users[len(users)-1].Id != userId {
+			return fmt.Errorf("item %q written before its user %q", item.Id, userId)
+		}
+
+		user := &users[len(users)-1]
+		user.Items = append(user.Items, *item)
+		return nil
+	}
+
+	return w.enc.Encode(&record{Item: item, UserId: userId})
+}
+
+// Close flushes the archive to disk and closes it
+func (w *Writer) Close() error {
+	err := w.flush()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func (w *Writer) flush() error {
+	if w.archive != nil {
+		w.enc.SetIndent("", "  ")
+		if err := w.enc.Encode(w.archive); err != nil {
+			return err
+		}
+	}
+
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+
+	if w.gz != nil {
+		if err := w.gz.Close(); err != nil {
+			return err
+		}
+	}
+
+	return w.file.Sync()
+}
+
+// Read reads the whole archive at path, in the format given by its extension
+func Read(path string) (*Archive, error) {
+	format, gzipped, err := formatOf(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if gzipped {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive %s: %w", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var archive *Archive
+	if format == formatJSON {
+		archive, err = readJSON(r)
+	} else {
+		archive, err = readJSONL(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive %s: %w", path, err)
+	}
+
+	return archive, nil
+}
+
+func readJSON(r io.Reader) (*Archive, error) {
+	archive := &Archive{}
+	if err := json.NewDecoder(r).Decode(archive); err != nil {
+		return nil, err
+	}
+
+	if err := checkVersion(&archive.Header); err != nil {
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+func readJSONL(r io.Reader) (*Archive, error) {
+	var archive *Archive
+
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var rec record
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("record %d: %w", line, err)
+		}
+
+		switch {
+		case rec.Header != nil:
+			if archive != nil {
+				return nil, fmt.Errorf("record %d: duplicate header", line)
+			}
+			if err := checkVersion(rec.Header); err != nil {
+				return nil, err
+			}
+			archive = &Archive{Header: *rec.Header}
+		case archive == nil:
+			return nil, fmt.Errorf("record %d: missing header", line)
+		case rec.User != nil:
+			archive.Users = append(archive.Users, User{GelatinUser: *rec.User})
+		case rec.Item != nil:
+			users := archive.Users
+			if len(users) == 0 || users[len(users)-1].Id != rec.UserId {
+				return nil, fmt.Errorf("record %d: item for unknown user %q", line, rec.UserId)
+			}
+			user := &users[len(users)-1]
+			user.Items = append(user.Items, *rec.Item)
+		}
+	}
+
+	if archive == nil {
+		return nil, fmt.Errorf("missing header")
+	}
+
+	return archive, nil
+}
+
+func checkVersion(header *Header) error {
+	if header.Version < 1 || header.Version > Version {
+		return fmt.Errorf("unsupported archive version %d (supported: 1-%d)", header.Version, Version)
+	}
+	return nil
+}
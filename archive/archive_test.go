@@ -0,0 +1,151 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aksiksi/gelatin/jellyfin"
+	gelatin "github.com/aksiksi/gelatin/lib"
+	"github.com/google/go-cmp/cmp"
+)
+
+var (
+	testUsers = []gelatin.GelatinUser{
+		{Name: "alice", Id: "alice-id"},
+		{Name: "bob", Id: "bob-id", Policy: gelatin.GelatinUserPolicy{EnabledFolders: []string{"movies"}}},
+	}
+
+	testFolders = []gelatin.GelatinLibraryItem{
+		{Name: "Movies", Id: "movies", IsFolder: true},
+		{Name: "Shows", Id: "shows", IsFolder: true},
+	}
+
+	played = &gelatin.GelatinLibraryItemUserActivity{Played: true, PlayCount: 1}
+	unseen = &gelatin.GelatinLibraryItemUserActivity{}
+
+	// Items by user ID
+	testItems = map[string][]gelatin.GelatinLibraryItem{
+		"alice-id": {
+			{Name: "Heat", Id: "heat", Type: "Movie", ImdbId: "tt0113277", UserData: played},
+			{Name: "Ronin", Id: "ronin", Type: "Movie", ImdbId: "tt0122690", UserData: unseen},
+			{Name: "The Wire", Id: "wire", Type: "Series", TvdbId: "79126", UserData: unseen},
+			{Name: "Season 1", Id: "wire-1", Type: "Season", ParentId: "wire", SeriesId: "wire", IndexNumber: 1, ChildCount: 13, UserData: unseen},
+			{Name: "The Target", Id: "wire-1-1", Type: "Episode", ParentId: "wire-1", SeriesId: "wire", SeasonId: "wire-1", IndexNumber: 1, ParentIndexNumber: 1, UserData: played},
+			{Name: "Lost", Id: "lost", Type: "Series", TvdbId: "73739", UserData: unseen},
+			{Name: "Season 1", Id: "lost-1", Type: "Season", ParentId: "lost", SeriesId: "lost", IndexNumber: 1, UserData: unseen},
+		},
+		"bob-id": {
+			{Name: "Heat", Id: "heat", Type: "Movie", ImdbId: "tt0113277", UserData: unseen},
+		},
+	}
+)
+
+// newTestServer returns a mock Jellyfin server holding the test users and items
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		var v interface{}
+
+		switch path := strings.ToLower(req.URL.Path); path {
+		case "/system/info":
+			v = &gelatin.GelatinSystemInfo{Id: "server-id", ServerName: "test", Version: "10.8.0"}
+		case "/library/mediafolders":
+			v = &jellyfin.JellyfinLibraryItemResponse{Items: testFolders, TotalRecordCount: int32(len(testFolders))}
+		case "/users":
+			v = testUsers
+		case "/items":
+			if fields := req.URL.Query().Get("fields"); !strings.Contains(fields, "ChildCount") {
+				t.Errorf("expected the episode count of seasons to be requested, got fields: %q", fields)
+			}
+			items := testItems[req.URL.Query().Get("userId")]
+			v = &jellyfin.JellyfinLibraryItemResponse{Items: items, TotalRecordCount: int32(len(items))}
+		default:
+			t.Errorf("unexpected request: %s", req.URL)
+			http.NotFound(resp, req)
+			return
+		}
+
+		json.NewEncoder(resp).Encode(v)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestExport(t *testing.T) {
+	srv := newTestServer(t)
+	svc := jellyfin.NewJellyfinApiClient(srv.URL, jellyfin.NewApiKey("test123"))
+
+	for _, name := range []string{"backup.json", "backup.jsonl", "backup.jsonl.gz"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+
+			w, err := Create(path)
+			if err != nil {
+				t.Fatalf("failed to create archive: %s", err)
+			}
+
+			summary, err := Export(context.Background(), svc, "jellyfin", w)
+			if err != nil {
+				t.Fatalf("failed to export: %s", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("failed to close archive: %s", err)
+			}
+
+			if summary.Users != 2 || summary.Items != 4 {
+				t.Errorf("unexpected summary: %+v", summary)
+			}
+
+			got, err := Read(path)
+			if err != nil {
+				t.Fatalf("failed to read archive: %s", err)
+			}
+
+			alice := testItems["alice-id"]
+			want := &Archive{
+				Header: Header{
+					Version:      Version,
+					CreatedAt:    got.CreatedAt,
+					ServerType:   "jellyfin",
+					Server:       gelatin.GelatinSystemInfo{Id: "server-id", ServerName: "test", Version: "10.8.0"},
+					MediaFolders: testFolders,
+				},
+				Users: []User{
+					// Only played items and the series and season that contain them are exported
+					{GelatinUser: testUsers[0], Items: []gelatin.GelatinLibraryItem{alice[0], alice[4], alice[2], alice[3]}},
+					{GelatinUser: testUsers[1]},
+				},
+			}
+
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("-want,+got: %s", diff)
+			}
+		})
+	}
+}
+
+func TestReadUnsupportedVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.jsonl")
+
+	w, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteHeader(&Header{Version: Version + 1})
+	w.Close()
+
+	if _, err := Read(path); err == nil || !strings.Contains(err.Error(), "unsupported archive version") {
+		t.Errorf("expected a version error, got: %v", err)
+	}
+
+	if _, err := Create(path); err == nil {
+		t.Errorf("expected an error when overwriting an existing archive")
+	}
+}
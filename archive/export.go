@@ -0,0 +1,142 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	gelatin "github.com/aksiksi/gelatin/lib"
+)
+
+// ExportSummary describes the result of an export
+type ExportSummary struct {
+	Users int
+	Items int
+}
+
+// Export writes the users of svc, along with the library items each user has interacted
+// with, to w. serverType is recorded in the header (e.g., "emby" or "jellyfin").
+//
+// Library items are fetched a page at a time. Movies and episodes are only exported if
+// the user has interacted with them; series and seasons are exported if they contain such
+// an item, so that the series hierarchy can be walked when the archive is imported.
+func Export(ctx context.Context, svc gelatin.GelatinService, serverType string, w *Writer) (*ExportSummary, error) {
+	info, err := svc.System().Info(ctx, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get system info: %w", err)
+	}
+
+	folders, err := svc.Library().GetMediaFolders(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get media folders: %w", err)
+	}
+
+	header := &Header{
+		Version:      Version,
+		CreatedAt:    time.Now().UTC(),
+		ServerType:   serverType,
+		Server:       *info,
+		MediaFolders: folders,
+	}
+	if err := w.WriteHeader(header); err != nil {
+		return nil, err
+	}
+
+	users, err := svc.User().GetUsers(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &ExportSummary{}
+
+	for i := range users {
+		user := &users[i]
+
+		if err := w.WriteUser(user); err != nil {
+			return summary, err
+		}
+
+		n, err := exportUserItems(ctx, svc.Library(), user.Id, w)
+		if err != nil {
+			return summary, fmt.Errorf("failed to export items for user %q: %w", user.Name, err)
+		}
+
+		summary.Users++
+		summary.Items += n
+	}
+
+	return summary, nil
+}
+
+// exportUserItems writes the items a user has interacted with and returns their number
+func exportUserItems(ctx context.Context, svc gelatin.GelatinLibraryService, userId string, w *Writer) (int, error) {
+	written := 0
+
+	// Series and seasons are held until all items are seen, since only those that contain
+	// an exported item are needed
+	containers := make(map[string]*gelatin.GelatinLibraryItem)
+	var containerIds []string
+	needed := make(map[string]bool)
+
+	// The episode counts of seasons are needed to match episodes by absolute number
+	filters := map[string]string{
+		svc.GetItemFilterString(gelatin.GelatinItemFilterIncludeItemTypes): "Movie,Series,Season,Episode",
+		svc.GetItemFilterString(gelatin.GelatinItemFilterFields):           "ChildCount",
+	}
+
+	err := svc.WalkItemsByUser(ctx, userId, filters, func(items []gelatin.GelatinLibraryItem) error {
+		for i := range items {
+			item := &items[i]
+
+			switch item.Type {
+			case "Series", "Season":
+				c := *item
+				containers[c.Id] = &c
+				containerIds = append(containerIds, c.Id)
+				if gelatin.HasUserActivity(item) {
+					needed[c.Id] = true
+				}
+				continue
+			}
+
+			if !gelatin.HasUserActivity(item) {
+				continue
+			}
+
+			if err := w.WriteItem(userId, item); err != nil {
+				return err
+			}
+			written++
+
+			needed[item.SeriesId] = true
+			needed[item.SeasonId] = true
+			needed[item.ParentId] = true
+		}
+
+		return nil
+	})
+	if err != nil {
+		return written, err
+	}
+
+	// A season also needs its series
+	for id := range needed {
+		if c, ok := containers[id]; ok && c.Type == "Season" {
+			needed[c.SeriesId] = true
+			needed[c.ParentId] = true
+		}
+	}
+
+	for _, id := range containerIds {
+		if !needed[id] {
+			continue
+		}
+
+		if err := w.WriteItem(userId, containers[id]); err != nil {
+			return written, err
+		}
+		written++
+	}
+
+	return written, nil
+}
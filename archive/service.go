@@ -0,0 +1,400 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	gelatin "github.com/aksiksi/gelatin/lib"
+)
+
+// ErrReadOnly is returned by every Service method that would modify the archive
+var ErrReadOnly = errors.New("archive is read-only")
+
+// Item filters supported by Service. These match the (PascalCase) names used by Emby.
+const (
+	itemFilterIncludeItemTypes   = "IncludeItemTypes"
+	itemFilterParentId           = "ParentId"
+	itemFilterFilters            = "Filters"
+	itemFilterFiltersIsFolder    = "IsFolder"
+	itemFilterFiltersIsNotFolder = "IsNotFolder"
+	itemFilterFiltersIsPlayed    = "IsPlayed"
+
+	// Archives do not record when user data was saved, so this matches the last played date
+	itemFilterMinDateLastSavedForUser = "MinDateLastSavedForUser"
+
+	// Accepted for compatibility with server queries, but ignored
+	itemFilterFields    = "Fields"
+	itemFilterRecursive = "Recursive"
+)
+
+// Service is a read-only gelatin.GelatinService backed by an export archive.
+//
+// It can be used as the "from" service of a gelatin.GelatinClient, so that users and watch
+// history can be migrated from an archive exactly like from a live server.
+type Service struct {
+	archive *Archive
+}
+
+// NewService returns a Service for an archive that was already read
+func NewService(archive *Archive) *Service {
+	return &Service{archive: archive}
+}
+
+// Open reads the archive at path and returns a Service for it
+func Open(path string) (*Service, error) {
+	archive, err := Read(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewService(archive), nil
+}
+
+// ApiKey returns nil, since archives do not need authentication
+func (s *Service) ApiKey() gelatin.ApiKey {
+	return nil
+}
+
+func (s *Service) SetApiKey(key gelatin.ApiKey) {}
+
+func (s *Service) System() gelatin.GelatinSystemService {
+	return s
+}
+
+func (s *Service) User() gelatin.GelatinUserService {
+	return s
+}
+
+func (s *Service) Library() gelatin.GelatinLibraryService {
+	return s
+}
+
+func (s *Service) Playlist() gelatin.GelatinPlaylistService {
+	return s
+}
+
+func (s *Service) Collection() gelatin.GelatinCollectionService {
+	return s
+}
+
+// Version returns the version of the server the archive was exported from
+func (s *Service) Version(ctx context.Context) (string, error) {
+	return s.archive.Server.Version, nil
+}
+
+func (s *Service) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *Service) GetLogs(ctx context.Context) ([]gelatin.GelatinSystemLog, error) {
+	return nil, nil
+}
+
+func (s *Service) GetLogFile(ctx context.Context, name string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("archives do not hold logs")
+}
+
+// Info returns information about the server the archive was exported from
+func (s *Service) Info(ctx context.Context, public bool) (*gelatin.GelatinSystemInfo, error) {
+	info := s.archive.Server
+	return &info, nil
+}
+
+func (s *Service) user(id string) (*User, error) {
+	for i := range s.archive.Users {
+		if s.archive.Users[i].Id == id {
+			return &s.archive.Users[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("user %q not found in archive", id)
+}
+
+func (s *Service) GetUser(ctx context.Context, id string) (*gelatin.GelatinUser, error) {
+	user, err := s.user(id)
+	if err != nil {
+		return nil, err
+	}
+
+	u := user.GelatinUser
+	return &u, nil
+}
+
+func (s *Service) GetUsers(ctx context.Context, public bool) ([]gelatin.GelatinUser, error) {
+	users := make([]gelatin.GelatinUser, 0, len(s.archive.Users))
+	for _, user := range s.archive.Users {
+		if public && user.Policy.IsHidden {
+			continue
+		}
+		users = append(users, user.GelatinUser)
+	}
+
+	return users, nil
+}
+
+func (s *Service) UpdateUser(ctx context.Context, id string, data *gelatin.GelatinUser) error {
+	return ErrReadOnly
+}
+
+func (s *Service) CreateUser(ctx context.Context, name string) (*gelatin.GelatinUser, error) {
+	return nil, ErrReadOnly
+}
+
+func (s *Service) DeleteUser(ctx context.Context, id string) error {
+	return ErrReadOnly
+}
+
+func (s *Service) UpdatePassword(ctx context.Context, id, currentPassword, newPassword string, reset bool) error {
+	return ErrReadOnly
+}
+
+func (s *Service) Authenticate(ctx context.Context, username, password string) (gelatin.ApiKey, error) {
+	return nil, fmt.Errorf("archives do not hold passwords")
+}
+
+func (s *Service) UpdatePolicy(ctx context.Context, id string, policy *gelatin.GelatinUserPolicy) error {
+	return ErrReadOnly
+}
+
+func (s *Service) UpdateConfiguration(ctx context.Context, id string, config *gelatin.GelatinUserConfig) error {
+	return ErrReadOnly
+}
+
+// isChild returns true if the item is contained by the given parent. Unless recursive is set,
+// only direct children match.
+func isChild(item *gelatin.GelatinLibraryItem, parentId string, recursive bool) bool {
+	if item.ParentId == parentId {
+		return true
+	}
+
+	switch item.Type {
+	case "Season":
+		return item.ParentId == "" && item.SeriesId == parentId
+	case "Episode":
+		if recursive && item.SeriesId == parentId {
+			return true
+		}
+		return item.ParentId == "" && item.SeasonId == parentId
+	default:
+		return false
+	}
+}
+
+// playedSince returns true if the item was last played at or after the given time
+func playedSince(item *gelatin.GelatinLibraryItem, since time.Time) bool {
+	if item.UserData == nil {
+		return false
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, item.UserData.LastPlayedDate)
+	return err == nil && !t.Before(since)
+}
+
+// filterItems returns the items that match the given filters
+func filterItems(items []gelatin.GelatinLibraryItem, filters map[string]string, recursive bool) ([]gelatin.GelatinLibraryItem, error) {
+	var (
+		types    map[string]bool
+		parentId string
+		flags    []string
+		since    time.Time
+	)
+
+	for k, v := range filters {
+		switch k {
+		case itemFilterIncludeItemTypes:
+			types = make(map[string]bool)
+			for _, t := range strings.Split(v, ",") {
+				types[strings.TrimSpace(t)] = true
+			}
+		case itemFilterParentId:
+			parentId = v
+		case itemFilterFilters:
+			flags = strings.Split(v, ",")
+		case itemFilterMinDateLastSavedForUser:
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", k, err)
+			}
+			since = t
+		case itemFilterFields, itemFilterRecursive:
+		default:
+			return nil, fmt.Errorf("unsupported item filter for archives: %q", k)
+		}
+	}
+
+	var matched []gelatin.GelatinLibraryItem
+
+	for i := range items {
+		item := &items[i]
+
+		if types != nil && !types[item.Type] {
+			continue
+		}
+
+		if parentId != "" && !isChild(item, parentId, recursive) {
+			continue
+		}
+
+		if !since.IsZero() && !playedSince(item, since) {
+			continue
+		}
+
+		ok := true
+		for _, flag := range flags {
+			switch strings.TrimSpace(flag) {
+			case itemFilterFiltersIsFolder:
+				ok = ok && item.IsFolder
+			case itemFilterFiltersIsNotFolder:
+				ok = ok && !item.IsFolder
+			case itemFilterFiltersIsPlayed:
+				ok = ok && item.UserData != nil && item.UserData.Played
+			default:
+				return nil, fmt.Errorf("unsupported item filter for archives: %q", flag)
+			}
+		}
+
+		if ok {
+			matched = append(matched, *item)
+		}
+	}
+
+	return matched, nil
+}
+
+// GetItems returns the items of all users in the archive, without user activity
+func (s *Service) GetItems(ctx context.Context, filters map[string]string, recursive bool) ([]gelatin.GelatinLibraryItem, error) {
+	var items []gelatin.GelatinLibraryItem
+	seen := make(map[string]bool)
+
+	for _, user := range s.archive.Users {
+		for _, item := range user.Items {
+			if seen[item.Id] {
+				continue
+			}
+			seen[item.Id] = true
+
+			item.UserData = nil
+			items = append(items, item)
+		}
+	}
+
+	return filterItems(items, filters, recursive)
+}
+
+func (s *Service) GetItemsByUser(ctx context.Context, id string, filters map[string]string) ([]gelatin.GelatinLibraryItem, error) {
+	user, err := s.user(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return filterItems(user.Items, filters, true)
+}
+
+// walk passes all items to fn as a single page, since the archive is already in memory
+func walk(items []gelatin.GelatinLibraryItem, err error, fn gelatin.GelatinItemPageFunc) error {
+	if err != nil || len(items) == 0 {
+		return err
+	}
+
+	return fn(items)
+}
+
+func (s *Service) WalkItems(ctx context.Context, filters map[string]string, recursive bool, fn gelatin.GelatinItemPageFunc) error {
+	items, err := s.GetItems(ctx, filters, recursive)
+	return walk(items, err, fn)
+}
+
+func (s *Service) WalkItemsByUser(ctx context.Context, id string, filters map[string]string, fn gelatin.GelatinItemPageFunc) error {
+	items, err := s.GetItemsByUser(ctx, id, filters)
+	return walk(items, err, fn)
+}
+
+func (s *Service) UpdateItem(ctx context.Context, itemId string, item *gelatin.GelatinLibraryItem) error {
+	return ErrReadOnly
+}
+
+func (s *Service) UpdateItemUserActivity(ctx context.Context, itemId string, userId string, old, new *gelatin.GelatinLibraryItemUserActivity) error {
+	return ErrReadOnly
+}
+
+func (s *Service) GetItemFilterString(filter gelatin.GelatinItemFilterName) string {
+	switch filter {
+	case gelatin.GelatinItemFilterFilters:
+		return itemFilterFilters
+	case gelatin.GelatinItemFilterParentId:
+		return itemFilterParentId
+	case gelatin.GelatinItemFilterIncludeItemTypes:
+		return itemFilterIncludeItemTypes
+	case gelatin.GelatinItemFilterFiltersIsFolder:
+		return itemFilterFiltersIsFolder
+	case gelatin.GelatinItemFilterFiltersIsNotFolder:
+		return itemFilterFiltersIsNotFolder
+	case gelatin.GelatinItemFilterFiltersIsPlayed:
+		return itemFilterFiltersIsPlayed
+	case gelatin.GelatinItemFilterMinDateLastSavedForUser:
+		return itemFilterMinDateLastSavedForUser
+	case gelatin.GelatinItemFilterFields:
+		return itemFilterFields
+	default:
+		panic("invalid filter name")
+	}
+}
+
+// GetMediaFolders returns the library folders of the server the archive was exported from
+func (s *Service) GetMediaFolders(ctx context.Context) ([]gelatin.GelatinLibraryItem, error) {
+	return s.archive.MediaFolders, nil
+}
+
+// GetPlaylists returns no playlists, since archives do not hold any
+func (s *Service) GetPlaylists(ctx context.Context, userId string) ([]gelatin.GelatinLibraryItem, error) {
+	if _, err := s.user(userId); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (s *Service) GetPlaylistItems(ctx context.Context, playlistId, userId string) ([]gelatin.GelatinLibraryItem, error) {
+	return nil, fmt.Errorf("playlist %q not found in archive", playlistId)
+}
+
+func (s *Service) CreatePlaylist(ctx context.Context, userId, name, mediaType string, itemIds []string) (string, error) {
+	return "", ErrReadOnly
+}
+
+func (s *Service) AddPlaylistItems(ctx context.Context, playlistId, userId string, itemIds []string) error {
+	return ErrReadOnly
+}
+
+func (s *Service) RemovePlaylistItems(ctx context.Context, playlistId string, entryIds []string) error {
+	return ErrReadOnly
+}
+
+func (s *Service) MovePlaylistItem(ctx context.Context, playlistId, entryId string, index int) error {
+	return ErrReadOnly
+}
+
+// GetCollections returns no collections, since archives do not hold any
+func (s *Service) GetCollections(ctx context.Context) ([]gelatin.GelatinLibraryItem, error) {
+	return nil, nil
+}
+
+func (s *Service) GetCollectionItems(ctx context.Context, collectionId string) ([]gelatin.GelatinLibraryItem, error) {
+	return nil, fmt.Errorf("collection %q not found in archive", collectionId)
+}
+
+func (s *Service) CreateCollection(ctx context.Context, name string, itemIds []string) (string, error) {
+	return "", ErrReadOnly
+}
+
+func (s *Service) AddCollectionItems(ctx context.Context, collectionId string, itemIds []string) error {
+	return ErrReadOnly
+}
+
+func (s *Service) RemoveCollectionItems(ctx context.Context, collectionId string, itemIds []string) error {
+	return ErrReadOnly
+}
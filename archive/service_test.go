@@ -0,0 +1,191 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	gelatin "github.com/aksiksi/gelatin/lib"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestService(t *testing.T) {
+	ctx := context.Background()
+
+	from := NewService(&Archive{
+		Header: Header{Version: Version, MediaFolders: testFolders},
+		Users: []User{
+			{GelatinUser: testUsers[0], Items: testItems["alice-id"]},
+			{GelatinUser: testUsers[1], Items: testItems["bob-id"]},
+		},
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		items, err := from.GetItemsByUser(ctx, "alice-id", map[string]string{
+			from.GetItemFilterString(gelatin.GelatinItemFilterParentId): "wire",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Children are returned recursively, like a server does for user queries
+		var ids []string
+		for _, item := range items {
+			ids = append(ids, item.Id)
+		}
+		if diff := cmp.Diff([]string{"wire-1", "wire-1-1"}, ids); diff != "" {
+			t.Errorf("-want,+got: %s", diff)
+		}
+
+		items, err = from.GetItems(ctx, map[string]string{
+			from.GetItemFilterString(gelatin.GelatinItemFilterIncludeItemTypes): "Movie",
+		}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 2 || items[0].UserData != nil {
+			t.Errorf("expected 2 movies without user data, got: %+v", items)
+		}
+
+		if _, err := from.GetItems(ctx, map[string]string{"SortBy": "Name"}, true); err == nil {
+			t.Errorf("expected an error for an unsupported filter")
+		}
+	})
+
+	t.Run("ReadOnly", func(t *testing.T) {
+		if _, err := from.CreateUser(ctx, "carol"); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("expected ErrReadOnly, got: %v", err)
+		}
+		if err := from.UpdateItemUserActivity(ctx, "heat", "alice-id", unseen, played); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("expected ErrReadOnly, got: %v", err)
+		}
+	})
+
+	// The into service is an archive too, so that the plans can be checked without a server
+	into := NewService(&Archive{
+		Header: Header{Version: Version, MediaFolders: testFolders},
+		Users: []User{
+			{
+				GelatinUser: gelatin.GelatinUser{Name: "alice", Id: "alice-into"},
+				Items: []gelatin.GelatinLibraryItem{
+					{Name: "Heat", Id: "heat-into", Type: "Movie", ImdbId: "tt0113277", UserData: unseen},
+					{Name: "The Wire", Id: "wire-into", Type: "Series", TvdbId: "79126", UserData: unseen},
+					{Name: "The Target", Id: "wire-1-1-into", Type: "Episode", SeriesId: "wire-into", IndexNumber: 1, ParentIndexNumber: 1, UserData: unseen},
+				},
+			},
+		},
+	})
+
+	client := gelatin.NewGelatinClient(from, into, nil)
+
+	t.Run("PlanMigrateUsers", func(t *testing.T) {
+		plan, err := client.PlanMigrateUsers(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, op := range plan.Operations {
+			got = append(got, string(op.Type)+":"+op.Username)
+		}
+
+		want := []string{"CreateUser:bob", "UpdateUserPolicy:bob", "UpdateUserConfig:bob"}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("-want,+got: %s", diff)
+		}
+	})
+
+	t.Run("PlanMigrateUserWatchHistory", func(t *testing.T) {
+		plan, err := client.PlanMigrateUserWatchHistory(ctx, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, op := range plan.Operations {
+			got = append(got, op.ItemId)
+		}
+
+		if diff := cmp.Diff([]string{"heat-into", "wire-1-1-into"}, got); diff != "" {
+			t.Errorf("-want,+got: %s", diff)
+		}
+	})
+	t.Run("PlanSyncUserWatchHistory", func(t *testing.T) {
+		// Ronin was only watched on the into server, so it is synced back to the from side
+		items := append([]gelatin.GelatinLibraryItem(nil), into.archive.Users[0].Items...)
+		items = append(items, gelatin.GelatinLibraryItem{Name: "Ronin", Id: "ronin-into", Type: "Movie", ImdbId: "tt0122690", UserData: played})
+
+		into := NewService(&Archive{
+			Header: into.archive.Header,
+			Users:  []User{{GelatinUser: into.archive.Users[0].GelatinUser, Items: items}},
+		})
+
+		client := gelatin.NewGelatinClient(from, into, nil)
+		plan, err := client.PlanSyncUserWatchHistory(ctx, "alice", gelatin.GelatinConflictNewest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, op := range plan.Operations {
+			got = append(got, string(op.Target)+":"+op.ItemId)
+		}
+
+		want := []string{":heat-into", ":wire-1-1-into", "from:ronin"}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("-want,+got: %s", diff)
+		}
+	})
+	t.Run("PlanSyncAllWatchHistorySince", func(t *testing.T) {
+		since := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+		at := func(played bool, date string) *gelatin.GelatinLibraryItemUserActivity {
+			return &gelatin.GelatinLibraryItemUserActivity{Played: played, LastPlayedDate: date}
+		}
+
+		alice := gelatin.GelatinUser{Name: "alice", Id: "alice-id"}
+		from := NewService(&Archive{
+			Header: Header{Version: Version},
+			Users: []User{{GelatinUser: alice, Items: []gelatin.GelatinLibraryItem{
+				{Name: "Heat", Id: "heat", Type: "Movie", ImdbId: "tt0113277", UserData: at(true, "2023-06-01T10:00:00Z")},
+				{Name: "Ronin", Id: "ronin", Type: "Movie", ImdbId: "tt0122690", UserData: at(false, "")},
+				{Name: "Alien", Id: "alien", Type: "Movie", ImdbId: "tt0078748", UserData: at(false, "")},
+			}}},
+		})
+		into := NewService(&Archive{
+			Header: Header{Version: Version},
+			Users: []User{{GelatinUser: alice, Items: []gelatin.GelatinLibraryItem{
+				{Name: "Heat", Id: "heat-into", Type: "Movie", ImdbId: "tt0113277", UserData: at(false, "")},
+				{Name: "Ronin", Id: "ronin-into", Type: "Movie", ImdbId: "tt0122690", UserData: at(true, "2023-06-02T10:00:00Z")},
+				// Played before the high-water mark, so it is not compared
+				{Name: "Alien", Id: "alien-into", Type: "Movie", ImdbId: "tt0078748", UserData: at(true, "2022-01-01T10:00:00Z")},
+			}}},
+		})
+
+		client := gelatin.NewGelatinClient(from, into, nil)
+		opts := &gelatin.GelatinWatchHistoryOpts{Since: map[string]time.Time{"alice": since}}
+		plan, _, err := client.PlanSyncAllWatchHistory(ctx, opts, gelatin.GelatinConflictNewest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, op := range plan.Operations {
+			got = append(got, string(op.Target)+":"+op.ItemId)
+		}
+
+		want := []string{":heat-into", "from:ronin"}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("-want,+got: %s", diff)
+		}
+
+		// Without a high-water mark, every item is compared
+		plan, _, err = client.PlanSyncAllWatchHistory(ctx, nil, gelatin.GelatinConflictNewest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plan.Operations) != 3 {
+			t.Errorf("expected 3 operations for a full sync, got: %s", plan)
+		}
+	})
+}
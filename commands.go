@@ -0,0 +1,854 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aksiksi/gelatin/archive"
+	"github.com/aksiksi/gelatin/config"
+	gelatin "github.com/aksiksi/gelatin/lib"
+)
+
+// migrationFlags holds the flags shared by all commands that talk to both servers
+type migrationFlags struct {
+	configPath  string
+	from        serverFlags
+	into        serverFlags
+	interactive bool
+	dryRun      bool
+	jsonPlan    bool
+	concurrency int
+	stateDir    string
+	resume      string
+
+	fs *flag.FlagSet
+}
+
+func newMigrationFlagSet(name string) (*flag.FlagSet, *migrationFlags) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	f := &migrationFlags{fs: fs}
+	fs.StringVar(&f.configPath, "config", os.Getenv("GELATIN_CONFIG"), "path to a config file, JSON only (env: GELATIN_CONFIG)")
+	f.from.register(fs, "from")
+	f.into.register(fs, "into")
+	fs.BoolVar(&f.interactive, "interactive", false, "prompt before making any change to the into server")
+	fs.BoolVar(&f.dryRun, "dry-run", false, "print the planned changes without applying them")
+	fs.BoolVar(&f.jsonPlan, "json", false, "print the plan as JSON (with --dry-run)")
+	fs.IntVar(&f.concurrency, "concurrency", 0, "number of library items processed in parallel (default from config: 1)")
+	fs.StringVar(&f.stateDir, "state-dir", "", "directory holding the journals of migration runs (default from config)")
+	fs.StringVar(&f.resume, "resume", "", "ID of an interrupted run to resume, skipping the work it completed")
+	return fs, f
+}
+
+// config loads the config file and applies the environment and flag overrides, in that order
+func (f *migrationFlags) config() (*config.Config, error) {
+	c, err := config.Load(f.configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	f.from.apply(&c.From)
+	f.into.apply(&c.Into)
+
+	f.fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "interactive":
+			c.Migration.Interactive = f.interactive
+		case "concurrency":
+			c.Migration.Concurrency = f.concurrency
+		case "state-dir":
+			c.Migration.StateDir = f.stateDir
+		}
+	})
+
+	return c, nil
+}
+
+// client connects to both servers and returns a GelatinClient for them
+func (f *migrationFlags) client(ctx context.Context) (*gelatin.GelatinClient, error) {
+	c, err := f.config()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Client(ctx)
+}
+
+// startJournal attaches the journal of this run to the client. With --resume, the journal
+// of the earlier run is reopened, and the work it completed is skipped.
+//
+// Dry runs do not change anything, so they have no journal.
+func (f *migrationFlags) startJournal(c *config.Config, client *gelatin.GelatinClient) (*gelatin.GelatinJournal, error) {
+	if f.dryRun {
+		if f.resume != "" {
+			return nil, fmt.Errorf("--resume cannot be used with --dry-run")
+		}
+		return nil, nil
+	}
+
+	dir, err := c.Migration.JournalDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var journal *gelatin.GelatinJournal
+	if f.resume != "" {
+		journal, err = gelatin.OpenJournal(dir, f.resume)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resume run %s: %w", f.resume, err)
+		}
+
+		entries := journal.Entries()
+		fmt.Fprintf(os.Stderr, "Resuming run %s: %d entries in journal\n", journal.RunId(), len(entries))
+		for i := len(entries) - 1; i >= 0; i-- {
+			if op := entries[i].Operation; op != nil {
+				fmt.Fprintf(os.Stderr, "Last applied operation: %s\n", op)
+				break
+			}
+		}
+	} else {
+		journal, err = gelatin.CreateJournal(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Fprintf(os.Stderr, "Run ID: %s\n", journal.RunId())
+	}
+
+	client.SetJournal(journal)
+
+	return journal, nil
+}
+
+// finishJournal closes the journal, and explains how to resume the run if it failed
+func finishJournal(journal *gelatin.GelatinJournal, err error) error {
+	if journal == nil {
+		return err
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Run %s did not complete. Re-run the command with --resume %s to continue.\n", journal.RunId(), journal.RunId())
+	}
+
+	if closeErr := journal.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// run prints the plan if --dry-run is set, and applies it otherwise
+func (f *migrationFlags) run(ctx context.Context, client *gelatin.GelatinClient, plan *gelatin.GelatinPlan) error {
+	if !f.dryRun {
+		return client.Apply(ctx, plan)
+	}
+
+	if f.jsonPlan {
+		return plan.WriteJSON(os.Stdout)
+	}
+
+	fmt.Println(plan)
+
+	return nil
+}
+
+// server returns the config for the named server (from or into)
+func (f *migrationFlags) server(name string) (*config.ServerConfig, error) {
+	c, err := f.config()
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "from":
+		return &c.From, nil
+	case "into":
+		return &c.Into, nil
+	default:
+		return nil, fmt.Errorf("invalid server: %q", name)
+	}
+}
+
+func runUsersDiff(ctx context.Context, args []string) error {
+	fs, f := newMigrationFlagSet("users diff")
+	full := fs.Bool("full", false, "include the full user struct in the diff")
+	fs.Parse(args)
+
+	client, err := f.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	diff, err := client.DiffUsers(ctx, *full)
+	if err != nil {
+		return err
+	}
+
+	if diff == "" {
+		fmt.Println("No differences found")
+	} else {
+		fmt.Print(diff)
+	}
+
+	return nil
+}
+
+func runUsersMigrate(ctx context.Context, args []string) error {
+	fs, f := newMigrationFlagSet("users migrate")
+	prune := fs.String("prune", "", "delete users that only exist on the into server: never, prompt or always (always requires a user login, not an API key; default from config: never)")
+	passwords := fs.String("passwords", "", "password strategy for created users: none, file, random or reset (default from config: none)")
+	passwordFile := fs.String("password-file", "", "CSV of username,password records (with --passwords file)")
+	passwordOutput := fs.String("password-output", "", "CSV file to write generated passwords to (with --passwords random)")
+	fs.Parse(args)
+
+	c, err := f.config()
+	if err != nil {
+		return err
+	}
+
+	if *prune != "" {
+		c.Migration.Prune = *prune
+	}
+	if *passwords != "" {
+		c.Migration.Passwords.Strategy = *passwords
+	}
+	if *passwordFile != "" {
+		c.Migration.Passwords.File = *passwordFile
+	}
+	if *passwordOutput != "" {
+		c.Migration.Passwords.Output = *passwordOutput
+	}
+
+	strategy, err := c.Migration.Passwords.PasswordStrategy(f.resume != "")
+	if err != nil {
+		return err
+	}
+
+	client, err := c.Client(ctx)
+	if err != nil {
+		return err
+	}
+	client.SetPasswords(strategy)
+
+	journal, err := f.startJournal(c, client)
+	if err != nil {
+		return err
+	}
+
+	plan, err := client.PlanMigrateUsers(ctx)
+	if err == nil {
+		err = f.run(ctx, client, plan)
+	}
+
+	// Generated passwords are only handed off once they are safely on disk
+	if closer, ok := strategy.(io.Closer); ok {
+		if closeErr := closer.Close(); closeErr != nil {
+			if err == nil {
+				err = fmt.Errorf("failed to write passwords to %s: %w", c.Migration.Passwords.Output, closeErr)
+			}
+		} else if _, statErr := os.Stat(c.Migration.Passwords.Output); statErr == nil {
+			fmt.Fprintf(os.Stderr, "Passwords of created users written to %s\n", c.Migration.Passwords.Output)
+		}
+	}
+
+	return finishJournal(journal, err)
+}
+
+// splitList splits a comma-separated flag value, ignoring empty entries
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func runWatchMigrate(ctx context.Context, args []string) error {
+	return runWatch(ctx, "watch migrate", args)
+}
+
+func runWatchSync(ctx context.Context, args []string) error {
+	return runWatch(ctx, "watch sync", args)
+}
+
+// watchFlags selects the users whose watch history is migrated or synced
+type watchFlags struct {
+	include     string
+	exclude     string
+	usernameMap string
+	conflict    string
+	report      reportFlags
+}
+
+// register adds the flags to fs. suffix is appended to the usage of the user selection flags.
+// The conflict policy flag is only added for syncs.
+func (w *watchFlags) register(fs *flag.FlagSet, suffix string, sync bool) {
+	fs.StringVar(&w.include, "include", "", "comma-separated users to migrate"+suffix)
+	fs.StringVar(&w.exclude, "exclude", "", "comma-separated users to skip"+suffix)
+	fs.StringVar(&w.usernameMap, "map", "", "comma-separated from=into username pairs for renamed users"+suffix)
+	if sync {
+		fs.StringVar(&w.conflict, "conflict", "", "conflict policy: newest (default), progress, played or source")
+	}
+	w.report.register(fs, "items")
+}
+
+// opts returns the watch history options from the config, with any flags applied
+func (w *watchFlags) opts(c *config.Config) (*gelatin.GelatinWatchHistoryOpts, error) {
+	opts := c.Migration.Watch.Opts()
+	if w.include != "" {
+		opts.Include = splitList(w.include)
+	}
+	if w.exclude != "" {
+		opts.Exclude = splitList(w.exclude)
+	}
+	for _, pair := range splitList(w.usernameMap) {
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid --map entry: %q", pair)
+		}
+		opts.UsernameMap[pair[:i]] = pair[i+1:]
+	}
+
+	return opts, nil
+}
+
+// policy returns the conflict policy for a sync from the flag or the config
+func (w *watchFlags) policy(c *config.Config) (gelatin.GelatinConflictPolicy, error) {
+	if w.conflict != "" {
+		return gelatin.ParseConflictPolicy(w.conflict)
+	}
+	return gelatin.ParseConflictPolicy(c.Migration.Watch.Conflict)
+}
+
+// reportFlags selects the file the match report is written to
+type reportFlags struct {
+	path string
+}
+
+// register adds the --report flag to fs. what names the things that are reported.
+func (r *reportFlags) register(fs *flag.FlagSet, what string) {
+	fs.StringVar(&r.path, "report", "", "write unmatched and ambiguous "+what+" to this file (.json, .csv or a table; - for stderr)")
+}
+
+// start attaches a match report to the client if --report was given
+func (r *reportFlags) start(client *gelatin.GelatinClient) *gelatin.GelatinMatchReport {
+	if r.path == "" {
+		return nil
+	}
+
+	report := gelatin.NewMatchReport()
+	client.SetReport(report)
+	return report
+}
+
+// write writes the match report to the --report file. The format is picked from the file
+// extension.
+func (r *reportFlags) write(report *gelatin.GelatinMatchReport) error {
+	if report == nil {
+		return nil
+	}
+
+	if r.path == "-" {
+		return report.WriteTable(os.Stderr)
+	}
+
+	file, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(r.path)) {
+	case ".json":
+		err = report.WriteJSON(file)
+	case ".csv":
+		err = report.WriteCSV(file)
+	default:
+		err = report.WriteTable(file)
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write report %s: %w", r.path, err)
+	}
+
+	return nil
+}
+
+// runWatch runs "watch migrate" or, for "watch sync", syncs watch history in both directions
+func runWatch(ctx context.Context, name string, args []string) error {
+	sync := name == "watch sync"
+
+	fs, f := newMigrationFlagSet(name)
+	username := fs.String("user", "", "name of the user to migrate")
+	all := fs.Bool("all", false, "migrate every user present on both servers")
+	var incremental bool
+	if !sync {
+		fs.BoolVar(&incremental, "incremental", false, "only compare items whose user data changed since the last incremental migration")
+	}
+	var w watchFlags
+	w.register(fs, " (with --all)", sync)
+	fs.Parse(args)
+
+	if (*username == "") == !*all {
+		return fmt.Errorf("exactly one of --user or --all must be specified")
+	}
+
+	c, err := f.config()
+	if err != nil {
+		return err
+	}
+
+	client, err := c.Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	opts, err := w.opts(c)
+	if err != nil {
+		return err
+	}
+
+	// An empty policy migrates watch history in one direction only
+	var policy gelatin.GelatinConflictPolicy
+	if sync {
+		if policy, err = w.policy(c); err != nil {
+			return err
+		}
+	}
+
+	// An incremental migration of a single user goes through the same path as --all, which
+	// records the high-water mark of each user
+	var state *gelatin.GelatinSyncState
+	var statePath string
+	start := time.Now().UTC()
+	if incremental {
+		if *username != "" {
+			opts.Include = []string{*username}
+		}
+
+		if state, statePath, err = loadWatchState(c, opts); err != nil {
+			return err
+		}
+	}
+
+	journal, err := f.startJournal(c, client)
+	if err != nil {
+		return err
+	}
+
+	report := w.report.start(client)
+
+	if *username != "" && !incremental {
+		var plan *gelatin.GelatinPlan
+		if sync {
+			plan, err = client.PlanSyncUserWatchHistory(ctx, *username, policy)
+		} else {
+			plan, err = client.PlanMigrateUserWatchHistory(ctx, *username)
+		}
+		if err == nil {
+			err = f.run(ctx, client, plan)
+		}
+		if reportErr := w.report.write(report); err == nil {
+			err = reportErr
+		}
+
+		return finishJournal(journal, err)
+	}
+
+	var summaries []gelatin.GelatinWatchHistorySummary
+	switch {
+	case f.dryRun:
+		var plan *gelatin.GelatinPlan
+		if sync {
+			plan, summaries, err = client.PlanSyncAllWatchHistory(ctx, opts, policy)
+		} else {
+			plan, summaries, err = client.PlanAllWatchHistory(ctx, opts)
+		}
+		if err == nil {
+			err = f.run(ctx, client, plan)
+		}
+	case sync:
+		summaries, err = client.SyncAllWatchHistory(ctx, opts, policy)
+	default:
+		summaries, err = client.MigrateAllWatchHistory(ctx, opts)
+	}
+
+	printWatchSummaries(summaries)
+
+	if reportErr := w.report.write(report); err == nil {
+		err = reportErr
+	}
+
+	if state != nil && !f.dryRun {
+		state.Update(start, summaries)
+		for _, summary := range summaries {
+			if _, ok := opts.Since[summary.Username]; !ok && summary.Err == nil {
+				state.Users[summary.Username].LastFullSync = start
+			}
+		}
+		if saveErr := state.Save(statePath); err == nil {
+			err = saveErr
+		}
+	}
+
+	return finishJournal(journal, err)
+}
+
+// loadWatchState loads the state of incremental watch history migrations, and sets the
+// high-water marks of opts from it. Incremental migrations miss items added to a library
+// since the last migration, so users whose last full migration is older than the full sync
+// interval are migrated in full.
+func loadWatchState(c *config.Config, opts *gelatin.GelatinWatchHistoryOpts) (*gelatin.GelatinSyncState, string, error) {
+	path, err := c.Migration.WatchStatePath()
+	if err != nil {
+		return nil, "", err
+	}
+
+	state, err := gelatin.LoadSyncState(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	fullPeriod, err := c.Migration.Sync.FullIntervalDuration()
+	if err != nil {
+		return nil, "", err
+	}
+
+	opts.Since = state.Since()
+	for username, user := range state.Users {
+		if time.Since(user.LastFullSync) >= fullPeriod {
+			delete(opts.Since, username)
+		}
+	}
+
+	return state, path, nil
+}
+
+func runPlaylistMigrate(ctx context.Context, args []string) error {
+	fs, f := newMigrationFlagSet("playlist migrate")
+	username := fs.String("user", "", "name of the user whose playlists are migrated")
+	var r reportFlags
+	r.register(fs, "playlist entries")
+	fs.Parse(args)
+
+	if *username == "" {
+		return fmt.Errorf("--user must be specified")
+	}
+
+	c, err := f.config()
+	if err != nil {
+		return err
+	}
+
+	client, err := c.Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	journal, err := f.startJournal(c, client)
+	if err != nil {
+		return err
+	}
+
+	report := r.start(client)
+
+	plan, err := client.PlanMigratePlaylists(ctx, *username)
+	if err == nil {
+		err = f.run(ctx, client, plan)
+	}
+	if reportErr := r.write(report); err == nil {
+		err = reportErr
+	}
+
+	return finishJournal(journal, err)
+}
+
+func runCollectionMigrate(ctx context.Context, args []string) error {
+	fs, f := newMigrationFlagSet("collection migrate")
+	var r reportFlags
+	r.register(fs, "collection members")
+	fs.Parse(args)
+
+	c, err := f.config()
+	if err != nil {
+		return err
+	}
+
+	client, err := c.Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	journal, err := f.startJournal(c, client)
+	if err != nil {
+		return err
+	}
+
+	report := r.start(client)
+
+	plan, err := client.PlanMigrateCollections(ctx)
+	if err == nil {
+		err = f.run(ctx, client, plan)
+	}
+	if reportErr := r.write(report); err == nil {
+		err = reportErr
+	}
+
+	return finishJournal(journal, err)
+}
+
+func printWatchSummaries(summaries []gelatin.GelatinWatchHistorySummary) {
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "USER\tINTO USER\tUPDATED\tUPDATED (FROM)\tSKIPPED\tUNMATCHED\tAMBIGUOUS\tERROR\n")
+	for _, s := range summaries {
+		errString := ""
+		if s.Err != nil {
+			errString = s.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%s\n", s.Username, s.IntoUsername, s.Updated, s.UpdatedFrom, s.Skipped, s.Unmatched, s.Ambiguous, errString)
+	}
+	w.Flush()
+}
+
+func runPlanApply(ctx context.Context, args []string) error {
+	fs, f := newMigrationFlagSet("plan apply")
+	path := fs.String("plan", "", "path to a plan written with --dry-run --json")
+	fs.Parse(args)
+
+	if *path == "" {
+		return fmt.Errorf("--plan must be specified")
+	}
+
+	file, err := os.Open(*path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	plan, err := gelatin.ReadPlan(file)
+	if err != nil {
+		return fmt.Errorf("failed to read plan %s: %w", *path, err)
+	}
+
+	c, err := f.config()
+	if err != nil {
+		return err
+	}
+
+	client, err := c.Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	journal, err := f.startJournal(c, client)
+	if err != nil {
+		return err
+	}
+
+	return finishJournal(journal, client.Apply(ctx, plan))
+}
+
+func runSystemInfo(ctx context.Context, args []string) error {
+	fs, f := newMigrationFlagSet("system info")
+	fs.Parse(args)
+
+	c, err := f.config()
+	if err != nil {
+		return err
+	}
+
+	if c.From.Url == "" && c.Into.Url == "" {
+		return fmt.Errorf("at least one of the from or into servers must be configured")
+	}
+
+	servers := []struct {
+		name   string
+		config *config.ServerConfig
+	}{
+		{"from", &c.From},
+		{"into", &c.Into},
+	}
+
+	for _, server := range servers {
+		if server.config.Url == "" {
+			continue
+		}
+
+		client, err := server.config.Connect(ctx)
+		if err != nil {
+			return fmt.Errorf("%s: %w", server.name, err)
+		}
+
+		info, err := client.System().Info(ctx, false)
+		if err != nil {
+			return fmt.Errorf("failed to get %s system info: %w", server.name, err)
+		}
+
+		fmt.Printf("%s (%s):\n", server.name, server.config.Url)
+		fmt.Printf("  Server name: %s\n", info.ServerName)
+		fmt.Printf("  Server ID: %s\n", info.Id)
+		fmt.Printf("  Version: %s\n", info.Version)
+		fmt.Printf("  Operating system: %s\n", info.OperatingSystem)
+		fmt.Printf("  Local address: %s\n", info.LocalAddress)
+		fmt.Printf("  WAN address: %s\n", info.WanAddress)
+	}
+
+	return nil
+}
+
+func runLogsFetch(ctx context.Context, args []string) error {
+	fs, f := newMigrationFlagSet("logs fetch")
+	target := fs.String("target", "from", "server to fetch logs from (from or into)")
+	name := fs.String("name", "", "name of the log file to fetch (default: list available logs)")
+	out := fs.String("out", "", "file to write the log to (default: stdout)")
+	fs.Parse(args)
+
+	server, err := f.server(*target)
+	if err != nil {
+		return err
+	}
+
+	client, err := server.Connect(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *target, err)
+	}
+
+	if *name == "" {
+		logs, err := client.System().GetLogs(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, l := range logs {
+			fmt.Printf("%s\t%d\t%s\n", l.Name, l.Size, l.DateModified)
+		}
+
+		return nil
+	}
+
+	data, err := client.System().GetLogFile(ctx, *name)
+	if err != nil {
+		return fmt.Errorf("failed to get log %s: %w", *name, err)
+	}
+	defer data.Close()
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	_, err = io.Copy(w, data)
+
+	return err
+}
+
+func runUndo(ctx context.Context, args []string) error {
+	fs, f := newMigrationFlagSet("undo")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gelatin undo <run-id> [flags]\n")
+		fs.PrintDefaults()
+	}
+
+	// Accept the run ID before or after the flags
+	var runId string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		runId, args = args[0], args[1:]
+	}
+	fs.Parse(args)
+	if runId == "" {
+		runId = fs.Arg(0)
+	}
+
+	if runId == "" {
+		fs.Usage()
+		return fmt.Errorf("a run ID must be specified")
+	}
+
+	c, err := f.config()
+	if err != nil {
+		return err
+	}
+
+	dir, err := c.Migration.JournalDir()
+	if err != nil {
+		return err
+	}
+
+	entries, err := gelatin.ReadJournal(dir, runId)
+	if err != nil {
+		return err
+	}
+
+	client, err := c.Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Undoing is a run of its own, so that it can be resumed (or undone)
+	journal, err := f.startJournal(c, client)
+	if err != nil {
+		return err
+	}
+
+	plan, err := client.PlanUndo(ctx, entries)
+	if err == nil {
+		err = f.run(ctx, client, plan)
+	}
+
+	return finishJournal(journal, err)
+}
+
+func runExport(ctx context.Context, args []string) error {
+	fs, f := newMigrationFlagSet("export")
+	target := fs.String("target", "from", "server to export (from or into)")
+	out := fs.String("out", "", "archive to write: .json, .jsonl or .jsonl.gz (must not exist)")
+	fs.Parse(args)
+
+	if *out == "" {
+		return fmt.Errorf("--out must be specified")
+	}
+
+	server, err := f.server(*target)
+	if err != nil {
+		return err
+	}
+
+	client, err := server.Connect(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *target, err)
+	}
+
+	w, err := archive.Create(*out)
+	if err != nil {
+		return err
+	}
+
+	summary, err := archive.Export(ctx, client, server.Type, w)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Do not leave an incomplete archive behind
+		os.Remove(*out)
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Exported %d user(s) and %d item(s) to %s\n", summary.Users, summary.Items, *out)
+
+	return nil
+}
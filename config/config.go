@@ -0,0 +1,501 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aksiksi/gelatin/archive"
+	"github.com/aksiksi/gelatin/emby"
+	"github.com/aksiksi/gelatin/jellyfin"
+	gelatin "github.com/aksiksi/gelatin/lib"
+)
+
+const (
+	ServerTypeEmby     = "emby"
+	ServerTypeJellyfin = "jellyfin"
+
+	// ServerTypeArchive reads from an archive written by "gelatin export" (from server only)
+	ServerTypeArchive = "archive"
+)
+
+const envPrefix = "GELATIN_"
+
+// ServerConfig describes how to connect to a single server
+type ServerConfig struct {
+	// Type is one of "emby", "jellyfin" or "archive"
+	Type string `json:"type"`
+	Url  string `json:"url"`
+
+	// Path is the archive to read (archive only)
+	Path string `json:"path"`
+
+	// Either ApiKey or Username must be set. If both are set, ApiKey takes precedence.
+	ApiKey   string `json:"api_key"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	// PasswordFile is read when Password is empty. Useful for Docker secrets and
+	// for keeping passwords out of checked-in profiles.
+	PasswordFile string `json:"password_file"`
+
+	// RateLimit is the maximum number of requests per second sent to the server (0 = unlimited)
+	RateLimit float64 `json:"rate_limit"`
+
+	// PageSize is the number of library items requested at a time (0 = default)
+	PageSize int `json:"page_size"`
+
+	// MaxRetries is the number of times a request that fails with a transient error is
+	// retried (0 = default, negative = never)
+	MaxRetries int `json:"max_retries"`
+}
+
+// MigrationConfig holds options that apply to all migrations
+type MigrationConfig struct {
+	Interactive bool `json:"interactive"`
+
+	// Prune is one of "never" (default), "prompt" or "always"
+	Prune string `json:"prune"`
+
+	// Concurrency is the number of library items processed in parallel (default: 1)
+	Concurrency int `json:"concurrency"`
+
+	Passwords PasswordConfig `json:"passwords"`
+
+	Watch WatchConfig `json:"watch"`
+
+	Sync SyncConfig `json:"sync"`
+
+	// Matchers are the names of the strategies used to match library items across servers,
+	// in order (default: all of them, see gelatin.DefaultMatchers)
+	Matchers []string `json:"matchers"`
+
+	// StateDir holds the journals of migration runs and the state of continuous syncs
+	// (default: gelatin/runs in the user's config directory)
+	StateDir string `json:"state_dir"`
+}
+
+// JournalDir returns the directory that holds the journals of migration runs
+func (m *MigrationConfig) JournalDir() (string, error) {
+	if m.StateDir != "" {
+		return m.StateDir, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("no state_dir configured: %w", err)
+	}
+
+	return filepath.Join(dir, "gelatin", "runs"), nil
+}
+
+// MatcherChain returns the configured item matchers. An empty list means the default chain.
+func (m *MigrationConfig) MatcherChain() ([]gelatin.GelatinMatcher, error) {
+	var matchers []gelatin.GelatinMatcher
+	for _, name := range m.Matchers {
+		matcher, err := gelatin.NewMatcher(name)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+
+	return matchers, nil
+}
+
+// SyncStatePath returns the path of the file that holds the state of continuous syncs
+func (m *MigrationConfig) SyncStatePath() (string, error) {
+	dir, err := m.JournalDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "sync-state.json"), nil
+}
+
+// WatchStatePath returns the path of the file that holds the state of incremental watch
+// history migrations. It is kept apart from the sync state, since a one-way migration does
+// not look at changes made in the into service.
+func (m *MigrationConfig) WatchStatePath() (string, error) {
+	dir, err := m.JournalDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "watch-state.json"), nil
+}
+
+const (
+	// DefaultSyncInterval is the time between passes of a continuous sync
+	DefaultSyncInterval = 15 * time.Minute
+
+	// DefaultFullSyncInterval is the time between full passes of a continuous sync
+	DefaultFullSyncInterval = 24 * time.Hour
+)
+
+// SyncConfig controls continuous syncs ("gelatin sync --watch"). Users are selected with
+// WatchConfig.
+type SyncConfig struct {
+	// Interval between sync passes, e.g. "15m" (default: DefaultSyncInterval)
+	Interval string `json:"interval"`
+
+	// FullInterval is the time between full sync passes, e.g. "24h" (default:
+	// DefaultFullSyncInterval). Other passes only compare items whose user data changed since
+	// the last pass, which misses items that were added to a library since then.
+	FullInterval string `json:"full_interval"`
+}
+
+// IntervalDuration parses the sync interval
+func (s *SyncConfig) IntervalDuration() (time.Duration, error) {
+	return parseInterval("interval", s.Interval, DefaultSyncInterval)
+}
+
+// FullIntervalDuration parses the full sync interval
+func (s *SyncConfig) FullIntervalDuration() (time.Duration, error) {
+	return parseInterval("full_interval", s.FullInterval, DefaultFullSyncInterval)
+}
+
+func parseInterval(name, value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sync %s: %w", name, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid sync %s: %q must be positive", name, value)
+	}
+
+	return d, nil
+}
+
+// WatchConfig selects the users whose watch history is migrated with --all
+type WatchConfig struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+
+	// UsernameMap maps "from" usernames to "into" usernames for renamed accounts
+	UsernameMap map[string]string `json:"username_map"`
+
+	// Conflict is the conflict policy used by "watch sync" (see gelatin.ParseConflictPolicy)
+	Conflict string `json:"conflict"`
+}
+
+// Opts returns the watch history options for the config
+func (w *WatchConfig) Opts() *gelatin.GelatinWatchHistoryOpts {
+	opts := &gelatin.GelatinWatchHistoryOpts{
+		Include:     w.Include,
+		Exclude:     w.Exclude,
+		UsernameMap: make(map[string]string),
+	}
+
+	for k, v := range w.UsernameMap {
+		opts.UsernameMap[k] = v
+	}
+
+	return opts
+}
+
+// PasswordConfig controls the initial password of migrated users
+type PasswordConfig struct {
+	// Strategy is one of "none" (default), "file", "random" or "reset"
+	Strategy string `json:"strategy"`
+
+	// File is a CSV of username,password records (file strategy only)
+	File string `json:"file"`
+
+	// Output is the CSV file generated passwords are written to (random strategy only)
+	Output string `json:"output"`
+
+	// Length of generated passwords (random strategy only)
+	Length int `json:"length"`
+}
+
+// PasswordStrategy builds the configured password strategy. Returns nil for "none".
+//
+// The random strategy refuses to overwrite an existing output file, unless resume is set
+// (see gelatin.NewRandomPasswordStrategy).
+func (p *PasswordConfig) PasswordStrategy(resume bool) (gelatin.GelatinPasswordStrategy, error) {
+	switch strings.ToLower(p.Strategy) {
+	case "", "none":
+		return nil, nil
+	case "file":
+		if p.File == "" {
+			return nil, fmt.Errorf("passwords: file must be specified")
+		}
+
+		passwords, err := gelatin.ReadPasswordFile(p.File)
+		if err != nil {
+			return nil, err
+		}
+
+		return gelatin.NewMapPasswordStrategy(passwords), nil
+	case "random":
+		if p.Output == "" {
+			return nil, fmt.Errorf("passwords: output must be specified")
+		}
+
+		// Fail before any user is created
+		if _, err := os.Stat(p.Output); err == nil && !resume {
+			return nil, fmt.Errorf("passwords: output %s already exists", p.Output)
+		}
+
+		return gelatin.NewRandomPasswordStrategy(p.Output, p.Length, resume), nil
+	case "reset":
+		return gelatin.NewResetPasswordStrategy(), nil
+	default:
+		return nil, fmt.Errorf("invalid password strategy: %q", p.Strategy)
+	}
+}
+
+// Config describes a single migration profile
+type Config struct {
+	From      ServerConfig    `json:"from"`
+	Into      ServerConfig    `json:"into"`
+	Migration MigrationConfig `json:"migration"`
+}
+
+// Default returns the default config: migrate from Emby into Jellyfin
+func Default() *Config {
+	return &Config{
+		From: ServerConfig{Type: ServerTypeEmby},
+		Into: ServerConfig{Type: ServerTypeJellyfin},
+	}
+}
+
+// Load reads the config at the given path and applies any overrides from the environment
+//
+// If path is empty, only the defaults and environment are used. Only JSON config files are
+// supported.
+func Load(path string) (*Config, error) {
+	c := Default()
+
+	if path != "" {
+		switch ext := strings.ToLower(filepath.Ext(path)); ext {
+		case ".yaml", ".yml", ".toml":
+			return nil, fmt.Errorf("config %s: %s config files are not supported, use JSON", path, strings.TrimPrefix(ext, "."))
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(c); err != nil {
+			return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+		}
+	}
+
+	if err := c.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ApplyEnv overrides config values with environment variables
+//
+// Server variables are named GELATIN_<SERVER>_<FIELD>, e.g. GELATIN_FROM_URL or
+// GELATIN_INTO_PASSWORD.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	c.From.applyEnv("FROM", lookup)
+	c.Into.applyEnv("INTO", lookup)
+
+	if v, ok := lookup(envPrefix + "INTERACTIVE"); ok {
+		interactive, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid %sINTERACTIVE: %w", envPrefix, err)
+		}
+		c.Migration.Interactive = interactive
+	}
+
+	if v, ok := lookup(envPrefix + "PRUNE"); ok {
+		c.Migration.Prune = v
+	}
+
+	if v, ok := lookup(envPrefix + "PASSWORDS"); ok {
+		c.Migration.Passwords.Strategy = v
+	}
+
+	if v, ok := lookup(envPrefix + "STATE_DIR"); ok {
+		c.Migration.StateDir = v
+	}
+
+	return nil
+}
+
+func (s *ServerConfig) applyEnv(name string, lookup func(string) (string, bool)) {
+	vars := map[string]*string{
+		"TYPE":          &s.Type,
+		"URL":           &s.Url,
+		"PATH":          &s.Path,
+		"API_KEY":       &s.ApiKey,
+		"USERNAME":      &s.Username,
+		"PASSWORD":      &s.Password,
+		"PASSWORD_FILE": &s.PasswordFile,
+	}
+
+	for suffix, field := range vars {
+		if v, ok := lookup(envPrefix + name + "_" + suffix); ok {
+			*field = v
+		}
+	}
+}
+
+// Validate checks that the server config is complete
+func (s *ServerConfig) Validate() error {
+	if strings.ToLower(s.Type) == ServerTypeArchive {
+		if s.Path == "" {
+			return fmt.Errorf("path must be specified for an archive")
+		}
+		return nil
+	}
+
+	if s.Url == "" {
+		return fmt.Errorf("url must be specified")
+	}
+
+	switch strings.ToLower(s.Type) {
+	case ServerTypeEmby, ServerTypeJellyfin:
+	default:
+		return fmt.Errorf("invalid server type: %q", s.Type)
+	}
+
+	if s.ApiKey == "" && s.Username == "" {
+		return fmt.Errorf("either an API key or a username must be specified")
+	}
+
+	return nil
+}
+
+func (s *ServerConfig) password() (string, error) {
+	if s.Password != "" || s.PasswordFile == "" {
+		return s.Password, nil
+	}
+
+	data, err := os.ReadFile(s.PasswordFile)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Connect builds a client for the server and authenticates against it
+func (s *ServerConfig) Connect(ctx context.Context) (gelatin.GelatinService, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	if strings.ToLower(s.Type) == ServerTypeArchive {
+		// Archives are read-only files, so there is nothing to authenticate against
+		svc, err := archive.Open(s.Path)
+		if err != nil {
+			return nil, err
+		}
+		return svc, nil
+	}
+
+	var client gelatin.GelatinHttpService
+	var apiKey gelatin.ApiKey
+
+	switch strings.ToLower(s.Type) {
+	case ServerTypeEmby:
+		client = emby.NewEmbyApiClient(s.Url, nil)
+		if s.ApiKey != "" {
+			apiKey = emby.NewApiKey(s.ApiKey)
+		}
+	case ServerTypeJellyfin:
+		client = jellyfin.NewJellyfinApiClient(s.Url, nil)
+		if s.ApiKey != "" {
+			apiKey = jellyfin.NewApiKey(s.ApiKey)
+		}
+	}
+
+	if s.RateLimit > 0 {
+		client.SetRateLimit(s.RateLimit)
+	}
+
+	if s.PageSize > 0 {
+		client.SetPageSize(s.PageSize)
+	}
+
+	if s.MaxRetries != 0 {
+		var policy *gelatin.RetryPolicy
+		if s.MaxRetries > 0 {
+			p := gelatin.DefaultRetryPolicy
+			p.MaxRetries = s.MaxRetries
+			policy = &p
+		}
+		client.SetRetryPolicy(policy)
+	}
+
+	if err := client.System().Ping(ctx); err != nil {
+		return nil, fmt.Errorf("failed to ping: %w", err)
+	}
+
+	if apiKey == nil {
+		password, err := s.password()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read password: %w", err)
+		}
+
+		apiKey, err = client.User().Authenticate(ctx, s.Username, password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+
+	client.SetApiKey(apiKey)
+
+	return client, nil
+}
+
+// Client connects to both servers and returns a GelatinClient for them.
+//
+// The client has no password strategy: the strategy can hold an open file, so it is built
+// by the caller that creates users (see PasswordConfig.PasswordStrategy).
+func (c *Config) Client(ctx context.Context) (*gelatin.GelatinClient, error) {
+	prune, err := gelatin.ParsePrunePolicy(c.Migration.Prune)
+	if err != nil {
+		return nil, err
+	}
+
+	matchers, err := c.Migration.MatcherChain()
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := c.From.Connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("from: %w", err)
+	}
+
+	if strings.ToLower(c.Into.Type) == ServerTypeArchive {
+		return nil, fmt.Errorf("into: archives are read-only and can only be migrated from")
+	}
+
+	into, err := c.Into.Connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("into: %w", err)
+	}
+
+	opts := &gelatin.GelatinClientOpts{
+		Interactive: c.Migration.Interactive,
+		Prune:       prune,
+		Concurrency: c.Migration.Concurrency,
+		Matchers:    matchers,
+	}
+
+	return gelatin.NewGelatinClient(from, into, opts), nil
+}
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeTestFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatalf("failed to write test file: %s", err)
+	}
+	return path
+}
+
+func TestConfigLoad(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		got, err := Load("")
+		if err != nil {
+			t.Fatalf("failed to load config: %s", err)
+		}
+
+		if got.From.Type != ServerTypeEmby || got.Into.Type != ServerTypeJellyfin {
+			t.Errorf("unexpected default server types: %q, %q", got.From.Type, got.Into.Type)
+		}
+	})
+
+	t.Run("File", func(t *testing.T) {
+		path := writeTestFile(t, "gelatin.json", `{
+			"from": {"type": "emby", "url": "http://emby:8096", "username": "admin", "password": "abc"},
+			"into": {"type": "jellyfin", "url": "http://jellyfin:8096", "api_key": "1234"},
+			"migration": {"interactive": true}
+		}`)
+
+		want := &Config{
+			From:      ServerConfig{Type: "emby", Url: "http://emby:8096", Username: "admin", Password: "abc"},
+			Into:      ServerConfig{Type: "jellyfin", Url: "http://jellyfin:8096", ApiKey: "1234"},
+			Migration: MigrationConfig{Interactive: true},
+		}
+
+		got, err := Load(path)
+		if err != nil {
+			t.Fatalf("failed to load config: %s", err)
+		}
+
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("-want,+got: %s", diff)
+		}
+	})
+
+	t.Run("Yaml", func(t *testing.T) {
+		path := writeTestFile(t, "gelatin.yaml", "from:\n  url: http://emby:8096\n")
+
+		if _, err := Load(path); err == nil {
+			t.Errorf("expected an error for a YAML config")
+		}
+	})
+
+	t.Run("UnknownField", func(t *testing.T) {
+		path := writeTestFile(t, "gelatin.json", `{"form": {"url": "http://emby:8096"}}`)
+
+		if _, err := Load(path); err == nil {
+			t.Errorf("expected an error for an unknown field")
+		}
+	})
+}
+
+func TestConfigApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"GELATIN_FROM_URL":      "http://emby:8096",
+		"GELATIN_INTO_TYPE":     "emby",
+		"GELATIN_INTO_PASSWORD": "secret",
+		"GELATIN_INTERACTIVE":   "true",
+		"GELATIN_STATE_DIR":     "/var/lib/gelatin",
+	}
+	lookup := func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+
+	c := Default()
+	c.From.Url = "http://old:8096"
+	c.Into.Username = "admin"
+
+	if err := c.ApplyEnv(lookup); err != nil {
+		t.Fatalf("failed to apply env: %s", err)
+	}
+
+	want := &Config{
+		From:      ServerConfig{Type: ServerTypeEmby, Url: "http://emby:8096"},
+		Into:      ServerConfig{Type: "emby", Username: "admin", Password: "secret"},
+		Migration: MigrationConfig{Interactive: true, StateDir: "/var/lib/gelatin"},
+	}
+
+	if diff := cmp.Diff(want, c); diff != "" {
+		t.Errorf("-want,+got: %s", diff)
+	}
+
+	env["GELATIN_INTERACTIVE"] = "maybe"
+	if err := c.ApplyEnv(lookup); err == nil {
+		t.Errorf("expected an error for an invalid bool")
+	}
+}
+
+func TestServerConfigPassword(t *testing.T) {
+	path := writeTestFile(t, "password", "hunter2\n")
+
+	s := &ServerConfig{PasswordFile: path}
+	got, err := s.password()
+	if err != nil {
+		t.Fatalf("failed to read password: %s", err)
+	}
+
+	if got != "hunter2" {
+		t.Errorf("password mismatch: want %q != got %q", "hunter2", got)
+	}
+}
+
+func TestSyncInterval(t *testing.T) {
+	testCases := []struct {
+		interval string
+		want     time.Duration
+		wantErr  bool
+	}{
+		{interval: "", want: DefaultSyncInterval},
+		{interval: "1h30m", want: 90 * time.Minute},
+		{interval: "-5m", wantErr: true},
+		{interval: "often", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		s := SyncConfig{Interval: tc.interval}
+		got, err := s.IntervalDuration()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%q: unexpected error: %v", tc.interval, err)
+		}
+		if got != tc.want {
+			t.Errorf("%q: expected %s, got %s", tc.interval, tc.want, got)
+		}
+	}
+}
+
+func TestPasswordStrategyOutput(t *testing.T) {
+	path := writeTestFile(t, "passwords.csv", "username,password\n")
+	p := &PasswordConfig{Strategy: "random", Output: path}
+
+	if _, err := p.PasswordStrategy(false); err == nil {
+		t.Errorf("expected an existing output to be refused")
+	}
+	if _, err := p.PasswordStrategy(true); err != nil {
+		t.Errorf("expected a resumed run to reuse the output: %s", err)
+	}
+}
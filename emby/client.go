@@ -2,11 +2,13 @@ package emby
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -34,25 +36,55 @@ const (
 	embyUserPasswordEndpoint     = "/Users"
 	embyUserAuthEndpoint         = "/Users/AuthenticateByName"
 	embyUserPolicyEndpoint       = "/Users"
+	embyUserConfigEndpoint       = "/Users"
+	embyLibraryFoldersEndpoint   = "/Library/MediaFolders"
+	embyPlaylistsEndpoint        = "/Playlists"
+	embyCollectionsEndpoint      = "/Collections"
 )
 
 const (
+	embyItemFilterIncludeItemTypes   = "IncludeItemTypes"
 	embyItemFilterParentId           = "ParentId"
 	embyItemFilterFields             = "Fields"
 	embyItemFilterRecursive          = "Recursive"
+	embyItemFilterSortBy             = "SortBy"
+	embyItemFilterSortOrder          = "SortOrder"
+	embyItemFilterStartIndex         = "StartIndex"
+	embyItemFilterLimit              = "Limit"
 	embyItemFilterUserId             = "UserId"
 	embyItemFilterFilters            = "Filters"
 	embyItemFilterFiltersIsFolder    = "IsFolder"
 	embyItemFilterFiltersIsNotFolder = "IsNotFolder"
 	embyItemFilterFiltersIsPlayed    = "IsPlayed"
 
+	embyItemFilterMinDateLastSavedForUser = "MinDateLastSavedForUser"
+
 	embyProviderIdImdb = "imdb"
 	embyProviderIdTmdb = "tmdb"
 	embyProviderIdTvdb = "tvdb"
 )
 
+const (
+	embyPlaylistParamName      = "Name"
+	embyPlaylistParamIds       = "Ids"
+	embyPlaylistParamEntryIds  = "EntryIds"
+	embyPlaylistParamMediaType = "MediaType"
+
+	embyCollectionParamName = "Name"
+	embyCollectionParamIds  = "Ids"
+)
+
+// embyItemFields are always requested for library items, since they are used to match items
+var embyItemFields = []string{"ProviderIds", "Path"}
+
+// embyItemSortBy is the order of paged library items. The server's default order is not
+// stable between requests, and there is no sort by ID, so ties in name are broken by the date
+// the item was added.
+const embyItemSortBy = "SortName,DateCreated"
+
 type embyApiKey struct {
 	key     string
+	userId  string
 	isAdmin bool
 }
 
@@ -71,10 +103,16 @@ func (k *embyApiKey) IsAdmin() bool {
 	return k.isAdmin
 }
 
+func (k *embyApiKey) UserId() string {
+	return k.userId
+}
+
 type EmbyApiClient struct {
 	client   *http.Client
 	hostname string
 	apiKey   gelatin.ApiKey
+	pageSize int
+	retry    *gelatin.RetryPolicy
 	mu       sync.Mutex
 }
 
@@ -85,6 +123,7 @@ func NewEmbyApiClient(hostname string, apiKey gelatin.ApiKey) *EmbyApiClient {
 		},
 		hostname: fmt.Sprintf("%s/emby", hostname),
 		apiKey:   apiKey,
+		retry:    &gelatin.DefaultRetryPolicy,
 	}
 }
 
@@ -98,6 +137,30 @@ func (c *EmbyApiClient) SetApiKey(key gelatin.ApiKey) {
 	c.mu.Unlock()
 }
 
+// SetRateLimit limits the number of requests per second sent to the server. A rate of 0
+// or less removes the limit.
+func (c *EmbyApiClient) SetRateLimit(requestsPerSecond float64) {
+	c.mu.Lock()
+	gelatin.SetRateLimit(c.client, requestsPerSecond)
+	c.mu.Unlock()
+}
+
+// SetRetryPolicy sets how requests that fail with a transient error are retried. A nil
+// policy disables retries.
+func (c *EmbyApiClient) SetRetryPolicy(policy *gelatin.RetryPolicy) {
+	c.mu.Lock()
+	c.retry = policy
+	c.mu.Unlock()
+}
+
+// SetPageSize sets the number of library items requested at a time. A size of 0 or less
+// uses gelatin.DefaultPageSize.
+func (c *EmbyApiClient) SetPageSize(size int) {
+	c.mu.Lock()
+	c.pageSize = size
+	c.mu.Unlock()
+}
+
 func (c *EmbyApiClient) System() gelatin.GelatinSystemService {
 	// TODO: Move this out
 	return c
@@ -118,7 +181,12 @@ func (c *EmbyApiClient) Playlist() gelatin.GelatinPlaylistService {
 	return c
 }
 
-func (c *EmbyApiClient) request(method string, url string, body io.Reader, key gelatin.ApiKey) (*http.Response, error) {
+func (c *EmbyApiClient) Collection() gelatin.GelatinCollectionService {
+	// TODO: Move this out
+	return c
+}
+
+func (c *EmbyApiClient) request(ctx context.Context, method string, url string, body io.Reader, key gelatin.ApiKey) (*http.Response, error) {
 	headers := map[string]string{
 		embyApiKeyAuthHeader: `Emby Client="gelatin", Device="gelatin", DeviceId="007", Version="0.0.1"`,
 	}
@@ -127,13 +195,17 @@ func (c *EmbyApiClient) request(method string, url string, body io.Reader, key g
 		headers[embyApiKeyTokenHeader] = key.ToString()
 	}
 
-	resp, err := gelatin.HttpRequest(c.client, method, url, body, headers)
+	c.mu.Lock()
+	retry := c.retry
+	c.mu.Unlock()
+
+	resp, err := gelatin.HttpRequestWithRetry(ctx, c.client, retry, method, url, body, headers)
 
 	return resp, err
 }
 
-func (c *EmbyApiClient) get(url string, key gelatin.ApiKey) (*http.Response, error) {
-	resp, err := c.request(http.MethodGet, url, nil, key)
+func (c *EmbyApiClient) get(ctx context.Context, url string, key gelatin.ApiKey) (*http.Response, error) {
+	resp, err := c.request(ctx, http.MethodGet, url, nil, key)
 	if err != nil {
 		return nil, err
 	}
@@ -141,8 +213,8 @@ func (c *EmbyApiClient) get(url string, key gelatin.ApiKey) (*http.Response, err
 	return resp, nil
 }
 
-func (c *EmbyApiClient) Version() (string, error) {
-	info, err := c.Info(true)
+func (c *EmbyApiClient) Version(ctx context.Context) (string, error) {
+	info, err := c.Info(ctx, true)
 	if err != nil {
 		return "", err
 	}
@@ -150,9 +222,9 @@ func (c *EmbyApiClient) Version() (string, error) {
 	return info.Version, nil
 }
 
-func (c *EmbyApiClient) Ping() error {
+func (c *EmbyApiClient) Ping(ctx context.Context) error {
 	url := fmt.Sprintf("%s%s", c.hostname, embySystemPingEndpoint)
-	_, err := c.request(http.MethodPost, url, nil, nil)
+	_, err := c.request(gelatin.WithIdempotentRequest(ctx), http.MethodPost, url, nil, nil)
 	if err != nil {
 		return err
 	}
@@ -160,9 +232,9 @@ func (c *EmbyApiClient) Ping() error {
 	return nil
 }
 
-func (c *EmbyApiClient) GetLogs() ([]gelatin.GelatinSystemLog, error) {
+func (c *EmbyApiClient) GetLogs(ctx context.Context) ([]gelatin.GelatinSystemLog, error) {
 	url := fmt.Sprintf("%s%s", c.hostname, embySystemLogsQueryEndpoint)
-	raw, err := c.get(url, c.apiKey)
+	raw, err := c.get(ctx, url, c.apiKey)
 	if err != nil {
 		return nil, err
 	}
@@ -180,9 +252,9 @@ func (c *EmbyApiClient) GetLogs() ([]gelatin.GelatinSystemLog, error) {
 	return resp.Items, nil
 }
 
-func (c *EmbyApiClient) GetLogFile(name string) (io.ReadCloser, error) {
+func (c *EmbyApiClient) GetLogFile(ctx context.Context, name string) (io.ReadCloser, error) {
 	url := fmt.Sprintf("%s%s/%s", c.hostname, embySystemLogsEndpoint, name)
-	resp, err := c.get(url, c.apiKey)
+	resp, err := c.get(ctx, url, c.apiKey)
 	if err != nil {
 		return nil, err
 	}
@@ -190,7 +262,7 @@ func (c *EmbyApiClient) GetLogFile(name string) (io.ReadCloser, error) {
 	return resp.Body, nil
 }
 
-func (c *EmbyApiClient) Info(public bool) (*gelatin.GelatinSystemInfo, error) {
+func (c *EmbyApiClient) Info(ctx context.Context, public bool) (*gelatin.GelatinSystemInfo, error) {
 	var url string
 
 	if public {
@@ -199,7 +271,7 @@ func (c *EmbyApiClient) Info(public bool) (*gelatin.GelatinSystemInfo, error) {
 		url = fmt.Sprintf("%s%s", c.hostname, embySystemInfoEndpoint)
 	}
 
-	raw, err := c.get(url, c.apiKey)
+	raw, err := c.get(ctx, url, c.apiKey)
 	if err != nil {
 		return nil, err
 	}
@@ -213,9 +285,9 @@ func (c *EmbyApiClient) Info(public bool) (*gelatin.GelatinSystemInfo, error) {
 	return resp, nil
 }
 
-func (c *EmbyApiClient) GetUser(id string) (*gelatin.GelatinUser, error) {
+func (c *EmbyApiClient) GetUser(ctx context.Context, id string) (*gelatin.GelatinUser, error) {
 	url := fmt.Sprintf("%s%s/%s", c.hostname, embyUserGetEndpoint, id)
-	raw, err := c.get(url, c.apiKey)
+	raw, err := c.get(ctx, url, c.apiKey)
 	if err != nil {
 		return nil, err
 	}
@@ -229,7 +301,7 @@ func (c *EmbyApiClient) GetUser(id string) (*gelatin.GelatinUser, error) {
 	return resp, nil
 }
 
-func (c *EmbyApiClient) GetUsers(public bool) ([]gelatin.GelatinUser, error) {
+func (c *EmbyApiClient) GetUsers(ctx context.Context, public bool) ([]gelatin.GelatinUser, error) {
 	var url string
 
 	if public {
@@ -238,7 +310,7 @@ func (c *EmbyApiClient) GetUsers(public bool) ([]gelatin.GelatinUser, error) {
 		url = fmt.Sprintf("%s%s", c.hostname, embyUserQueryEndpoint)
 	}
 
-	raw, err := c.get(url, c.apiKey)
+	raw, err := c.get(ctx, url, c.apiKey)
 	if err != nil {
 		return nil, err
 	}
@@ -267,7 +339,7 @@ func (c *EmbyApiClient) GetUsers(public bool) ([]gelatin.GelatinUser, error) {
 	}
 }
 
-func (c *EmbyApiClient) UpdateUser(id string, data *gelatin.GelatinUser) error {
+func (c *EmbyApiClient) UpdateUser(ctx context.Context, id string, data *gelatin.GelatinUser) error {
 	url := fmt.Sprintf("%s%s/%s", c.hostname, embyUserUpdateEndpoint, id)
 
 	raw, err := json.Marshal(data)
@@ -275,7 +347,7 @@ func (c *EmbyApiClient) UpdateUser(id string, data *gelatin.GelatinUser) error {
 		return err
 	}
 
-	_, err = c.request(http.MethodPost, url, bytes.NewReader(raw), c.apiKey)
+	_, err = c.request(gelatin.WithIdempotentRequest(ctx), http.MethodPost, url, bytes.NewReader(raw), c.apiKey)
 	if err != nil {
 		return err
 	}
@@ -283,7 +355,7 @@ func (c *EmbyApiClient) UpdateUser(id string, data *gelatin.GelatinUser) error {
 	return nil
 }
 
-func (c *EmbyApiClient) CreateUser(name string) (*gelatin.GelatinUser, error) {
+func (c *EmbyApiClient) CreateUser(ctx context.Context, name string) (*gelatin.GelatinUser, error) {
 	type createUserByName struct {
 		Name string
 	}
@@ -295,7 +367,7 @@ func (c *EmbyApiClient) CreateUser(name string) (*gelatin.GelatinUser, error) {
 	}
 
 	url := fmt.Sprintf("%s%s", c.hostname, embyUserNewEndpoint)
-	raw, err := c.request(http.MethodPost, url, bytes.NewReader(data), c.apiKey)
+	raw, err := c.request(ctx, http.MethodPost, url, bytes.NewReader(data), c.apiKey)
 	if err != nil {
 		return nil, err
 	}
@@ -309,10 +381,10 @@ func (c *EmbyApiClient) CreateUser(name string) (*gelatin.GelatinUser, error) {
 	return resp, nil
 }
 
-func (c *EmbyApiClient) DeleteUser(id string) error {
+func (c *EmbyApiClient) DeleteUser(ctx context.Context, id string) error {
 	url := fmt.Sprintf("%s%s/%s", c.hostname, embyUserDeleteEndpoint, id)
 
-	_, err := c.request(http.MethodDelete, url, nil, c.apiKey)
+	_, err := c.request(ctx, http.MethodDelete, url, nil, c.apiKey)
 	if err != nil {
 		return err
 	}
@@ -320,7 +392,7 @@ func (c *EmbyApiClient) DeleteUser(id string) error {
 	return nil
 }
 
-func (c *EmbyApiClient) UpdatePassword(id, currentPassword, newPassword string, reset bool) error {
+func (c *EmbyApiClient) UpdatePassword(ctx context.Context, id, currentPassword, newPassword string, reset bool) error {
 	type setUserPassword struct {
 		Id        string
 		CurrentPw string
@@ -341,7 +413,7 @@ func (c *EmbyApiClient) UpdatePassword(id, currentPassword, newPassword string,
 	}
 
 	url := fmt.Sprintf("%s%s/%s/Password", c.hostname, embyUserPasswordEndpoint, id)
-	_, err = c.request(http.MethodPost, url, bytes.NewReader(data), c.apiKey)
+	_, err = c.request(gelatin.WithIdempotentRequest(ctx), http.MethodPost, url, bytes.NewReader(data), c.apiKey)
 	if err != nil {
 		return err
 	}
@@ -349,7 +421,7 @@ func (c *EmbyApiClient) UpdatePassword(id, currentPassword, newPassword string,
 	return nil
 }
 
-func (c *EmbyApiClient) Authenticate(username, password string) (key gelatin.ApiKey, err error) {
+func (c *EmbyApiClient) Authenticate(ctx context.Context, username, password string) (key gelatin.ApiKey, err error) {
 	req := map[string]string{
 		"Username": username,
 		"Pw":       password,
@@ -361,7 +433,7 @@ func (c *EmbyApiClient) Authenticate(username, password string) (key gelatin.Api
 	}
 
 	url := fmt.Sprintf("%s%s", c.hostname, embyUserAuthEndpoint)
-	raw, err := c.request(http.MethodPost, url, bytes.NewReader(data), nil)
+	raw, err := c.request(gelatin.WithIdempotentRequest(ctx), http.MethodPost, url, bytes.NewReader(data), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -373,10 +445,14 @@ func (c *EmbyApiClient) Authenticate(username, password string) (key gelatin.Api
 		return nil, err
 	}
 
-	return NewApiKey(resp.AccessToken), nil
+	return &embyApiKey{
+		key:     resp.AccessToken,
+		userId:  resp.User.Id,
+		isAdmin: resp.User.Policy.IsAdministrator,
+	}, nil
 }
 
-func (c *EmbyApiClient) UpdatePolicy(id string, policy *gelatin.GelatinUserPolicy) error {
+func (c *EmbyApiClient) UpdatePolicy(ctx context.Context, id string, policy *gelatin.GelatinUserPolicy) error {
 	url := fmt.Sprintf("%s%s/%s/Policy", c.hostname, embyUserPolicyEndpoint, id)
 
 	data, err := json.Marshal(policy)
@@ -384,7 +460,7 @@ func (c *EmbyApiClient) UpdatePolicy(id string, policy *gelatin.GelatinUserPolic
 		return err
 	}
 
-	_, err = c.request(http.MethodPost, url, bytes.NewReader(data), c.apiKey)
+	_, err = c.request(gelatin.WithIdempotentRequest(ctx), http.MethodPost, url, bytes.NewReader(data), c.apiKey)
 	if err != nil {
 		return err
 	}
@@ -392,44 +468,94 @@ func (c *EmbyApiClient) UpdatePolicy(id string, policy *gelatin.GelatinUserPolic
 	return nil
 }
 
-func (c *EmbyApiClient) GetItems(filters map[string]string, recursive bool) ([]gelatin.GelatinLibraryItem, error) {
-	if filters == nil {
-		filters = make(map[string]string)
+func (c *EmbyApiClient) UpdateConfiguration(ctx context.Context, id string, config *gelatin.GelatinUserConfig) error {
+	url := fmt.Sprintf("%s%s/%s/Configuration", c.hostname, embyUserConfigEndpoint, id)
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.request(gelatin.WithIdempotentRequest(ctx), http.MethodPost, url, bytes.NewReader(data), c.apiKey)
+	if err != nil {
+		return err
 	}
 
-	endpoint := fmt.Sprintf("%s/Items", c.hostname)
+	return nil
+}
 
-	// Apply filters to URL string
-	parsedUrl, _ := url.Parse(endpoint)
-	query := parsedUrl.Query()
+func (c *EmbyApiClient) GetMediaFolders(ctx context.Context) ([]gelatin.GelatinLibraryItem, error) {
+	url := fmt.Sprintf("%s%s", c.hostname, embyLibraryFoldersEndpoint)
+	raw, err := c.get(ctx, url, c.apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &EmbyLibraryItemResponse{}
+	dec := json.NewDecoder(raw.Body)
+	if err := dec.Decode(resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Items, nil
+}
+
+// itemsQuery builds the query string for an items request with the given filters
+func (c *EmbyApiClient) itemsQuery(filters map[string]string, recursive bool) url.Values {
+	query := url.Values{}
 	for k, v := range filters {
-		// Always include the ProviderIds in each returned library item
-		if k == embyItemFilterFields && !strings.Contains(v, "ProviderIds") {
-			v += ", ProviderIds"
+		// Always include the fields used to match items in each returned library item
+		if k == embyItemFilterFields {
+			for _, field := range embyItemFields {
+				if !strings.Contains(v, field) {
+					v += ", " + field
+				}
+			}
 		}
 
 		query.Set(k, v)
 	}
 
 	if _, ok := filters[embyItemFilterFields]; !ok {
-		query.Set(embyItemFilterFields, "ProviderIds")
+		query.Set(embyItemFilterFields, strings.Join(embyItemFields, ", "))
 	}
 
 	if recursive {
 		query.Set(embyItemFilterRecursive, "true")
 	}
 
-	parsedUrl.RawQuery = query.Encode()
+	return query
+}
+
+// getItemsPage fetches a single page of items for the given query
+//
+// endpoint is the path of the items to list (e.g., /Items or the items of a playlist). Items
+// are sorted by embyItemSortBy, so that pages neither overlap nor skip items, except for
+// the items of a playlist, which are listed in playlist order.
+func (c *EmbyApiClient) getItemsPage(ctx context.Context, endpoint string, query url.Values, startIndex, limit int) ([]gelatin.GelatinLibraryItem, int, error) {
+	parsedUrl, _ := url.Parse(c.hostname + endpoint)
 
-	raw, err := c.get(parsedUrl.String(), c.apiKey)
+	pageQuery := url.Values{}
+	for k, v := range query {
+		pageQuery[k] = v
+	}
+	pageQuery.Set(embyItemFilterStartIndex, strconv.Itoa(startIndex))
+	pageQuery.Set(embyItemFilterLimit, strconv.Itoa(limit))
+	if !strings.HasPrefix(endpoint, embyPlaylistsEndpoint) {
+		pageQuery.Set(embyItemFilterSortBy, embyItemSortBy)
+		pageQuery.Set(embyItemFilterSortOrder, "Ascending")
+	}
+	parsedUrl.RawQuery = pageQuery.Encode()
+
+	raw, err := c.get(ctx, parsedUrl.String(), c.apiKey)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	resp := &EmbyLibraryItemResponse{}
 	dec := json.NewDecoder(raw.Body)
 	if err := dec.Decode(resp); err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	// Include the provider IDs as struct fields
@@ -448,20 +574,46 @@ func (c *EmbyApiClient) GetItems(filters map[string]string, recursive bool) ([]g
 		}
 	}
 
-	return resp.Items, nil
+	return resp.Items, int(resp.TotalRecordCount), nil
+}
+
+func (c *EmbyApiClient) WalkItems(ctx context.Context, filters map[string]string, recursive bool, fn gelatin.GelatinItemPageFunc) error {
+	query := c.itemsQuery(filters, recursive)
+
+	c.mu.Lock()
+	pageSize := c.pageSize
+	c.mu.Unlock()
+
+	fetch := func(ctx context.Context, startIndex, limit int) ([]gelatin.GelatinLibraryItem, int, error) {
+		return c.getItemsPage(ctx, "/Items", query, startIndex, limit)
+	}
+
+	return gelatin.WalkPages(ctx, pageSize, fetch, fn)
 }
 
-func (c *EmbyApiClient) GetItemsByUser(id string, filters map[string]string) ([]gelatin.GelatinLibraryItem, error) {
+func (c *EmbyApiClient) GetItems(ctx context.Context, filters map[string]string, recursive bool) ([]gelatin.GelatinLibraryItem, error) {
+	return gelatin.CollectPages(func(fn gelatin.GelatinItemPageFunc) error {
+		return c.WalkItems(ctx, filters, recursive, fn)
+	})
+}
+
+func (c *EmbyApiClient) WalkItemsByUser(ctx context.Context, id string, filters map[string]string, fn gelatin.GelatinItemPageFunc) error {
 	if filters == nil {
 		filters = make(map[string]string)
 	}
 
 	filters[embyItemFilterUserId] = id
 
-	return c.GetItems(filters, true)
+	return c.WalkItems(ctx, filters, true, fn)
 }
 
-func (c *EmbyApiClient) UpdateItem(itemId string, item *gelatin.GelatinLibraryItem) error {
+func (c *EmbyApiClient) GetItemsByUser(ctx context.Context, id string, filters map[string]string) ([]gelatin.GelatinLibraryItem, error) {
+	return gelatin.CollectPages(func(fn gelatin.GelatinItemPageFunc) error {
+		return c.WalkItemsByUser(ctx, id, filters, fn)
+	})
+}
+
+func (c *EmbyApiClient) UpdateItem(ctx context.Context, itemId string, item *gelatin.GelatinLibraryItem) error {
 	url := fmt.Sprintf("%s/Items/%s", c.hostname, itemId)
 
 	data, err := json.Marshal(item)
@@ -469,7 +621,7 @@ func (c *EmbyApiClient) UpdateItem(itemId string, item *gelatin.GelatinLibraryIt
 		return err
 	}
 
-	_, err = c.request(http.MethodPost, url, bytes.NewReader(data), c.apiKey)
+	_, err = c.request(gelatin.WithIdempotentRequest(ctx), http.MethodPost, url, bytes.NewReader(data), c.apiKey)
 	if err != nil {
 		return err
 	}
@@ -477,7 +629,7 @@ func (c *EmbyApiClient) UpdateItem(itemId string, item *gelatin.GelatinLibraryIt
 	return nil
 }
 
-func (c *EmbyApiClient) UpdateItemUserActivity(itemId string, userId string, _, activity *gelatin.GelatinLibraryItemUserActivity) error {
+func (c *EmbyApiClient) UpdateItemUserActivity(ctx context.Context, itemId string, userId string, _, activity *gelatin.GelatinLibraryItemUserActivity) error {
 	url := fmt.Sprintf("%s/Users/%s/Items/%s/UserData", c.hostname, userId, itemId)
 
 	data, err := json.Marshal(activity)
@@ -485,7 +637,7 @@ func (c *EmbyApiClient) UpdateItemUserActivity(itemId string, userId string, _,
 		return err
 	}
 
-	_, err = c.request(http.MethodPost, url, bytes.NewReader(data), c.apiKey)
+	_, err = c.request(gelatin.WithIdempotentRequest(ctx), http.MethodPost, url, bytes.NewReader(data), c.apiKey)
 	if err != nil {
 		return err
 	}
@@ -499,13 +651,170 @@ func (c *EmbyApiClient) GetItemFilterString(filter gelatin.GelatinItemFilterName
 		return embyItemFilterFilters
 	case gelatin.GelatinItemFilterParentId:
 		return embyItemFilterParentId
+	case gelatin.GelatinItemFilterIncludeItemTypes:
+		return embyItemFilterIncludeItemTypes
 	case gelatin.GelatinItemFilterFiltersIsFolder:
 		return embyItemFilterFiltersIsFolder
 	case gelatin.GelatinItemFilterFiltersIsNotFolder:
 		return embyItemFilterFiltersIsNotFolder
 	case gelatin.GelatinItemFilterFiltersIsPlayed:
 		return embyItemFilterFiltersIsPlayed
+	case gelatin.GelatinItemFilterMinDateLastSavedForUser:
+		return embyItemFilterMinDateLastSavedForUser
+	case gelatin.GelatinItemFilterFields:
+		return embyItemFilterFields
 	default:
 		panic("invalid filter name")
 	}
 }
+
+func (c *EmbyApiClient) GetPlaylists(ctx context.Context, userId string) ([]gelatin.GelatinLibraryItem, error) {
+	filters := map[string]string{
+		embyItemFilterIncludeItemTypes: "Playlist",
+	}
+
+	return c.GetItemsByUser(ctx, userId, filters)
+}
+
+// getAllItems fetches all pages of items of the given endpoint
+func (c *EmbyApiClient) getAllItems(ctx context.Context, endpoint string, query url.Values) ([]gelatin.GelatinLibraryItem, error) {
+	c.mu.Lock()
+	pageSize := c.pageSize
+	c.mu.Unlock()
+
+	fetch := func(ctx context.Context, startIndex, limit int) ([]gelatin.GelatinLibraryItem, int, error) {
+		return c.getItemsPage(ctx, endpoint, query, startIndex, limit)
+	}
+
+	return gelatin.CollectPages(func(fn gelatin.GelatinItemPageFunc) error {
+		return gelatin.WalkPages(ctx, pageSize, fetch, fn)
+	})
+}
+
+func (c *EmbyApiClient) GetPlaylistItems(ctx context.Context, playlistId, userId string) ([]gelatin.GelatinLibraryItem, error) {
+	endpoint := fmt.Sprintf("%s/%s/Items", embyPlaylistsEndpoint, playlistId)
+	query := c.itemsQuery(map[string]string{embyItemFilterUserId: userId}, false)
+
+	return c.getAllItems(ctx, endpoint, query)
+}
+
+func (c *EmbyApiClient) CreatePlaylist(ctx context.Context, userId, name, mediaType string, itemIds []string) (string, error) {
+	query := url.Values{}
+	query.Set(embyPlaylistParamName, name)
+	query.Set(embyItemFilterUserId, userId)
+	if mediaType != "" {
+		query.Set(embyPlaylistParamMediaType, mediaType)
+	}
+	if len(itemIds) > 0 {
+		query.Set(embyPlaylistParamIds, strings.Join(itemIds, ","))
+	}
+
+	url := fmt.Sprintf("%s%s?%s", c.hostname, embyPlaylistsEndpoint, query.Encode())
+	raw, err := c.request(ctx, http.MethodPost, url, nil, c.apiKey)
+	if err != nil {
+		return "", err
+	}
+
+	resp := &EmbyPlaylistCreationResult{}
+	dec := json.NewDecoder(raw.Body)
+	if err := dec.Decode(resp); err != nil {
+		return "", err
+	}
+
+	return resp.Id, nil
+}
+
+func (c *EmbyApiClient) AddPlaylistItems(ctx context.Context, playlistId, userId string, itemIds []string) error {
+	query := url.Values{}
+	query.Set(embyPlaylistParamIds, strings.Join(itemIds, ","))
+	query.Set(embyItemFilterUserId, userId)
+
+	url := fmt.Sprintf("%s%s/%s/Items?%s", c.hostname, embyPlaylistsEndpoint, playlistId, query.Encode())
+	_, err := c.request(ctx, http.MethodPost, url, nil, c.apiKey)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *EmbyApiClient) RemovePlaylistItems(ctx context.Context, playlistId string, entryIds []string) error {
+	query := url.Values{}
+	query.Set(embyPlaylistParamEntryIds, strings.Join(entryIds, ","))
+
+	url := fmt.Sprintf("%s%s/%s/Items?%s", c.hostname, embyPlaylistsEndpoint, playlistId, query.Encode())
+	_, err := c.request(ctx, http.MethodDelete, url, nil, c.apiKey)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *EmbyApiClient) MovePlaylistItem(ctx context.Context, playlistId, entryId string, index int) error {
+	url := fmt.Sprintf("%s%s/%s/Items/%s/Move/%d", c.hostname, embyPlaylistsEndpoint, playlistId, entryId, index)
+
+	_, err := c.request(ctx, http.MethodPost, url, nil, c.apiKey)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *EmbyApiClient) GetCollections(ctx context.Context) ([]gelatin.GelatinLibraryItem, error) {
+	filters := map[string]string{
+		embyItemFilterIncludeItemTypes: "BoxSet",
+	}
+
+	return c.GetItems(ctx, filters, true)
+}
+
+func (c *EmbyApiClient) GetCollectionItems(ctx context.Context, collectionId string) ([]gelatin.GelatinLibraryItem, error) {
+	query := c.itemsQuery(map[string]string{embyItemFilterParentId: collectionId}, false)
+
+	return c.getAllItems(ctx, "/Items", query)
+}
+
+func (c *EmbyApiClient) CreateCollection(ctx context.Context, name string, itemIds []string) (string, error) {
+	query := url.Values{}
+	query.Set(embyCollectionParamName, name)
+	if len(itemIds) > 0 {
+		query.Set(embyCollectionParamIds, strings.Join(itemIds, ","))
+	}
+
+	url := fmt.Sprintf("%s%s?%s", c.hostname, embyCollectionsEndpoint, query.Encode())
+	raw, err := c.request(ctx, http.MethodPost, url, nil, c.apiKey)
+	if err != nil {
+		return "", err
+	}
+
+	resp := &EmbyCollectionCreationResult{}
+	dec := json.NewDecoder(raw.Body)
+	if err := dec.Decode(resp); err != nil {
+		return "", err
+	}
+
+	return resp.Id, nil
+}
+
+func (c *EmbyApiClient) AddCollectionItems(ctx context.Context, collectionId string, itemIds []string) error {
+	return c.updateCollectionItems(ctx, http.MethodPost, collectionId, itemIds)
+}
+
+func (c *EmbyApiClient) RemoveCollectionItems(ctx context.Context, collectionId string, itemIds []string) error {
+	return c.updateCollectionItems(ctx, http.MethodDelete, collectionId, itemIds)
+}
+
+func (c *EmbyApiClient) updateCollectionItems(ctx context.Context, method, collectionId string, itemIds []string) error {
+	query := url.Values{}
+	query.Set(embyCollectionParamIds, strings.Join(itemIds, ","))
+
+	url := fmt.Sprintf("%s%s/%s/Items?%s", c.hostname, embyCollectionsEndpoint, collectionId, query.Encode())
+	_, err := c.request(ctx, method, url, nil, c.apiKey)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -1,12 +1,14 @@
 package emby
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -57,10 +59,12 @@ func TestEmbySystemEndpoints(t *testing.T) {
 	client, srv, s := setUp(t)
 	defer srv.Close()
 
+	ctx := context.Background()
+
 	s.status = http.StatusOK
 
 	t.Run("Ping", func(t *testing.T) {
-		err := client.Ping()
+		err := client.Ping(ctx)
 		if err != nil {
 			t.Errorf("failed to call ping endpoint")
 		}
@@ -78,7 +82,7 @@ func TestEmbySystemEndpoints(t *testing.T) {
 		want := &gelatin.GelatinSystemInfo{}
 		json.Unmarshal(wantResp, want)
 
-		got, err := client.Info(true)
+		got, err := client.Info(ctx, true)
 		if err != nil {
 			t.Errorf("failed to call SystemInfoPublic endpoint")
 		}
@@ -99,7 +103,7 @@ func TestEmbySystemEndpoints(t *testing.T) {
 		want := &gelatin.GelatinSystemInfo{}
 		json.Unmarshal(wantResp, want)
 
-		version, err := client.Version()
+		version, err := client.Version(ctx)
 		if err != nil {
 			t.Errorf("failed to call GetVersion")
 		}
@@ -113,7 +117,7 @@ func TestEmbySystemEndpoints(t *testing.T) {
 		wantResp := []byte("this is a log file")
 		s.resp = wantResp
 
-		logReader, err := client.GetLogFile("test")
+		logReader, err := client.GetLogFile(ctx, "test")
 		if err != nil {
 			t.Errorf("failed to call SystemLogs")
 		}
@@ -132,7 +136,7 @@ func TestEmbySystemEndpoints(t *testing.T) {
 		want := &EmbySystemLogsQueryResponse{}
 		json.Unmarshal(data, want)
 
-		got, err := client.GetLogs()
+		got, err := client.GetLogs(ctx)
 		if err != nil {
 			t.Errorf("failed to call SystemLogsQuery")
 		}
@@ -147,6 +151,8 @@ func TestEmbyUserEndpoints(t *testing.T) {
 	client, srv, s := setUp(t)
 	defer srv.Close()
 
+	ctx := context.Background()
+
 	s.status = http.StatusOK
 
 	t.Run("GetUsers_public", func(t *testing.T) {
@@ -163,7 +169,7 @@ func TestEmbyUserEndpoints(t *testing.T) {
 		var want []gelatin.GelatinUser
 		json.Unmarshal(wantResp, &want)
 
-		got, err := client.GetUsers(true)
+		got, err := client.GetUsers(ctx, true)
 		if err != nil {
 			t.Errorf("failed to call endpoint")
 		}
@@ -190,7 +196,7 @@ func TestEmbyUserEndpoints(t *testing.T) {
 		var want *EmbyUserQueryResponse
 		json.Unmarshal(wantResp, &want)
 
-		got, err := client.GetUsers(false)
+		got, err := client.GetUsers(ctx, false)
 		if err != nil {
 			t.Errorf("failed to call endpoint")
 		}
@@ -212,7 +218,7 @@ func TestEmbyUserEndpoints(t *testing.T) {
 		var want *gelatin.GelatinUser
 		json.Unmarshal(wantResp, &want)
 
-		got, err := client.GetUser(want.Id)
+		got, err := client.GetUser(ctx, want.Id)
 		if err != nil {
 			t.Errorf("failed to call endpoint")
 		}
@@ -224,7 +230,7 @@ func TestEmbyUserEndpoints(t *testing.T) {
 
 	t.Run("UserUpdate", func(t *testing.T) {
 		user := &gelatin.GelatinUser{Id: "abcd123"}
-		err := client.UpdateUser(user.Id, user)
+		err := client.UpdateUser(ctx, user.Id, user)
 		if err != nil {
 			t.Errorf("failed to call endpoint")
 		}
@@ -242,7 +248,7 @@ func TestEmbyUserEndpoints(t *testing.T) {
 		var want *gelatin.GelatinUser
 		json.Unmarshal(wantResp, &want)
 
-		got, err := client.CreateUser(want.Name)
+		got, err := client.CreateUser(ctx, want.Name)
 		if err != nil {
 			t.Errorf("failed to call endpoint")
 		}
@@ -253,14 +259,14 @@ func TestEmbyUserEndpoints(t *testing.T) {
 	})
 
 	t.Run("UserDelete", func(t *testing.T) {
-		err := client.DeleteUser("test123")
+		err := client.DeleteUser(ctx, "test123")
 		if err != nil {
 			t.Errorf("failed to call endpoint")
 		}
 	})
 
 	t.Run("UserPassword", func(t *testing.T) {
-		err := client.UpdatePassword("1000x1000", "", "test123", true)
+		err := client.UpdatePassword(ctx, "1000x1000", "", "test123", true)
 		if err != nil {
 			t.Errorf("failed to call endpoint")
 		}
@@ -270,12 +276,16 @@ func TestEmbyUserEndpoints(t *testing.T) {
 		wantToken := "12345"
 		wantUserAuthResp := &EmbyUserAuthResponse{
 			AccessToken: wantToken,
+			User: gelatin.GelatinUser{
+				Id:     "1000x1000",
+				Policy: gelatin.GelatinUserPolicy{IsAdministrator: true},
+			},
 		}
 		wantResp, _ := json.Marshal(wantUserAuthResp)
 
 		s.resp = wantResp
 
-		key, err := client.Authenticate("abcd", "test123")
+		key, err := client.Authenticate(ctx, "abcd", "test123")
 		if err != nil {
 			t.Errorf("failed to call endpoint")
 		}
@@ -285,13 +295,235 @@ func TestEmbyUserEndpoints(t *testing.T) {
 		if diff := cmp.Diff(wantToken, token); diff != "" {
 			t.Errorf("-want,+got: %s", diff)
 		}
+
+		if key.UserId() != "1000x1000" || !key.IsAdmin() {
+			t.Errorf("unexpected key user: %q, admin = %v", key.UserId(), key.IsAdmin())
+		}
 	})
 
 	t.Run("UserPolicy", func(t *testing.T) {
 		policy := &gelatin.GelatinUserPolicy{}
-		err := client.UpdatePolicy("abcd", policy)
+		err := client.UpdatePolicy(ctx, "abcd", policy)
+		if err != nil {
+			t.Errorf("failed to call endpoint")
+		}
+	})
+	t.Run("UserConfiguration", func(t *testing.T) {
+		config := &gelatin.GelatinUserConfig{SubtitleMode: "Smart"}
+		err := client.UpdateConfiguration(ctx, "abcd", config)
 		if err != nil {
 			t.Errorf("failed to call endpoint")
 		}
 	})
 }
+
+func TestEmbyLibraryEndpoints(t *testing.T) {
+	client, srv, s := setUp(t)
+	defer srv.Close()
+
+	ctx := context.Background()
+
+	s.status = http.StatusOK
+
+	t.Run("GetMediaFolders", func(t *testing.T) {
+		wantResp := []byte(`{
+			"Items": [
+				{"Name": "Movies", "Id": "f137a2dd21bbc1b99aa5c0f6bf02a805", "IsFolder": true},
+				{"Name": "Shows", "Id": "767bffe4f11c93ef34b805451a696a4e", "IsFolder": true}
+			],
+			"TotalRecordCount": 2
+		}`)
+
+		s.resp = wantResp
+
+		var want struct {
+			Items []gelatin.GelatinLibraryItem
+		}
+		json.Unmarshal(wantResp, &want)
+
+		got, err := client.GetMediaFolders(ctx)
+		if err != nil {
+			t.Errorf("failed to call endpoint")
+		}
+
+		if diff := cmp.Diff(want.Items, got); diff != "" {
+			t.Errorf("-want,+got: %s", diff)
+		}
+	})
+}
+
+func TestEmbyGetItemsPaged(t *testing.T) {
+	var library []gelatin.GelatinLibraryItem
+	for i := 0; i < 25; i++ {
+		library = append(library, gelatin.GelatinLibraryItem{
+			Id:          fmt.Sprintf("item-%d", i),
+			ProviderIds: map[string]string{"Imdb": fmt.Sprintf("tt%d", i)},
+		})
+	}
+
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		requests = append(requests, query.Get("StartIndex")+"/"+query.Get("Limit"))
+		if query.Get("SortBy") == "" {
+			t.Errorf("expected a fixed sort order, got: %s", req.URL.RawQuery)
+		}
+
+		startIndex, _ := strconv.Atoi(query.Get("StartIndex"))
+		limit, _ := strconv.Atoi(query.Get("Limit"))
+		end := startIndex + limit
+		if end > len(library) {
+			end = len(library)
+		}
+
+		json.NewEncoder(resp).Encode(&EmbyLibraryItemResponse{
+			Items:            library[startIndex:end],
+			TotalRecordCount: int32(len(library)),
+		})
+	}))
+	defer srv.Close()
+
+	client := NewEmbyApiClient(srv.URL, NewApiKey("test123"))
+	client.SetPageSize(10)
+
+	got, err := client.GetItems(context.Background(), nil, true)
+	if err != nil {
+		t.Fatalf("failed to get items: %v", err)
+	}
+
+	if diff := cmp.Diff([]string{"0/10", "10/10", "20/10"}, requests); diff != "" {
+		t.Errorf("-want,+got: %s", diff)
+	}
+
+	if len(got) != len(library) {
+		t.Fatalf("expected %d items, got %d", len(library), len(got))
+	}
+	if got[24].Id != "item-24" || got[24].ImdbId != "tt24" {
+		t.Errorf("unexpected last item: %+v", got[24])
+	}
+}
+
+func TestEmbyPlaylistEndpoints(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		query.Del("Fields")
+		query.Del("StartIndex")
+		query.Del("Limit")
+		requests = append(requests, req.Method+" "+req.URL.Path+"?"+query.Encode())
+
+		switch {
+		case req.Method == http.MethodPost && req.URL.Path == "/emby/Playlists":
+			resp.Write([]byte(`{"Id": "pl-new"}`))
+		case req.Method == http.MethodGet:
+			resp.Write([]byte(`{
+				"Items": [
+					{"Id": "heat", "Type": "Movie", "PlaylistItemId": "1"},
+					{"Id": "ronin", "Type": "Movie", "PlaylistItemId": "2"}
+				],
+				"TotalRecordCount": 2
+			}`))
+		default:
+			resp.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	client := NewEmbyApiClient(srv.URL, NewApiKey("test123"))
+	ctx := context.Background()
+
+	if _, err := client.GetPlaylists(ctx, "alice"); err != nil {
+		t.Fatalf("failed to get playlists: %v", err)
+	}
+
+	items, err := client.GetPlaylistItems(ctx, "pl", "alice")
+	if err != nil {
+		t.Fatalf("failed to get playlist items: %v", err)
+	}
+	if len(items) != 2 || items[1].Id != "ronin" || items[1].PlaylistItemId != "2" {
+		t.Errorf("unexpected playlist items: %+v", items)
+	}
+
+	id, err := client.CreatePlaylist(ctx, "alice", "Heists", "Video", []string{"heat", "ronin"})
+	if err != nil || id != "pl-new" {
+		t.Fatalf("failed to create playlist: %q, %v", id, err)
+	}
+
+	if err := client.AddPlaylistItems(ctx, "pl", "alice", []string{"heat"}); err != nil {
+		t.Errorf("failed to add playlist items: %v", err)
+	}
+	if err := client.RemovePlaylistItems(ctx, "pl", []string{"1", "2"}); err != nil {
+		t.Errorf("failed to remove playlist items: %v", err)
+	}
+	if err := client.MovePlaylistItem(ctx, "pl", "2", 0); err != nil {
+		t.Errorf("failed to move playlist item: %v", err)
+	}
+
+	want := []string{
+		"GET /emby/Items?IncludeItemTypes=Playlist&Recursive=true&SortBy=SortName%2CDateCreated&SortOrder=Ascending&UserId=alice",
+		"GET /emby/Playlists/pl/Items?UserId=alice",
+		"POST /emby/Playlists?Ids=heat%2Cronin&MediaType=Video&Name=Heists&UserId=alice",
+		"POST /emby/Playlists/pl/Items?Ids=heat&UserId=alice",
+		"DELETE /emby/Playlists/pl/Items?EntryIds=1%2C2",
+		"POST /emby/Playlists/pl/Items/2/Move/0?",
+	}
+	if diff := cmp.Diff(want, requests); diff != "" {
+		t.Errorf("-want,+got: %s", diff)
+	}
+}
+
+func TestEmbyCollectionEndpoints(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		query.Del("Fields")
+		query.Del("StartIndex")
+		query.Del("Limit")
+		requests = append(requests, req.Method+" "+req.URL.Path+"?"+query.Encode())
+
+		switch req.Method {
+		case http.MethodGet:
+			resp.Write([]byte(`{"Items": [{"Id": "thor", "Type": "Movie"}], "TotalRecordCount": 1}`))
+		case http.MethodPost:
+			resp.Write([]byte(`{"Id": "mcu"}`))
+		default:
+			resp.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	client := NewEmbyApiClient(srv.URL, NewApiKey("test123"))
+	ctx := context.Background()
+
+	if _, err := client.GetCollections(ctx); err != nil {
+		t.Fatalf("failed to get collections: %v", err)
+	}
+
+	items, err := client.GetCollectionItems(ctx, "mcu")
+	if err != nil || len(items) != 1 || items[0].Id != "thor" {
+		t.Fatalf("unexpected collection items: %+v, %v", items, err)
+	}
+
+	id, err := client.CreateCollection(ctx, "Marvel Cinematic Universe", []string{"thor", "loki"})
+	if err != nil || id != "mcu" {
+		t.Fatalf("failed to create collection: %q, %v", id, err)
+	}
+
+	if err := client.AddCollectionItems(ctx, "mcu", []string{"eternals"}); err != nil {
+		t.Errorf("failed to add collection items: %v", err)
+	}
+	if err := client.RemoveCollectionItems(ctx, "mcu", []string{"thor", "loki"}); err != nil {
+		t.Errorf("failed to remove collection items: %v", err)
+	}
+
+	want := []string{
+		"GET /emby/Items?IncludeItemTypes=BoxSet&Recursive=true&SortBy=SortName%2CDateCreated&SortOrder=Ascending",
+		"GET /emby/Items?ParentId=mcu&SortBy=SortName%2CDateCreated&SortOrder=Ascending",
+		"POST /emby/Collections?Ids=thor%2Cloki&Name=Marvel+Cinematic+Universe",
+		"POST /emby/Collections/mcu/Items?Ids=eternals",
+		"DELETE /emby/Collections/mcu/Items?Ids=thor%2Cloki",
+	}
+	if diff := cmp.Diff(want, requests); diff != "" {
+		t.Errorf("-want,+got: %s", diff)
+	}
+}
@@ -4,6 +4,7 @@ import gelatin "github.com/aksiksi/gelatin/lib"
 
 type EmbyUserAuthResponse struct {
 	AccessToken string
+	User        gelatin.GelatinUser
 	// Other fields ommitted
 }
 
@@ -158,3 +159,11 @@ type EmbyLibraryItemResponse struct {
 	Items            []gelatin.GelatinLibraryItem
 	TotalRecordCount int32
 }
+
+type EmbyPlaylistCreationResult struct {
+	Id string
+}
+
+type EmbyCollectionCreationResult struct {
+	Id string
+}
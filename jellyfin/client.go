@@ -2,6 +2,7 @@ package jellyfin
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -36,25 +37,55 @@ const (
 	jellyfinUserPasswordEndpoint     = "/Users"
 	jellyfinUserAuthEndpoint         = "/Users/AuthenticateByName"
 	jellyfinUserPolicyEndpoint       = "/Users"
+	jellyfinUserConfigEndpoint       = "/Users"
+	jellyfinLibraryFoldersEndpoint   = "/Library/MediaFolders"
+	jellyfinPlaylistsEndpoint        = "/Playlists"
+	jellyfinCollectionsEndpoint      = "/Collections"
 )
 
 const (
+	jellyfinItemFilterIncludeItemTypes   = "includeItemTypes"
 	jellyfinItemFilterParentId           = "parentId"
 	jellyfinItemFilterFields             = "fields"
 	jellyfinItemFilterRecursive          = "recursive"
+	jellyfinItemFilterSortBy             = "sortBy"
+	jellyfinItemFilterSortOrder          = "sortOrder"
+	jellyfinItemFilterStartIndex         = "startIndex"
+	jellyfinItemFilterLimit              = "limit"
 	jellyfinItemFilterUserId             = "userId"
 	jellyfinItemFilterFilters            = "filters"
 	jellyfinItemFilterFiltersIsFolder    = "IsFolder"
 	jellyfinItemFilterFiltersIsNotFolder = "IsNotFolder"
 	jellyfinItemFilterFiltersIsPlayed    = "IsPlayed"
 
+	jellyfinItemFilterMinDateLastSavedForUser = "minDateLastSavedForUser"
+
 	jellyfinProviderIdImdb = "imdb"
 	jellyfinProviderIdTmdb = "tmdb"
 	jellyfinProviderIdTvdb = "tvdb"
 )
 
+const (
+	jellyfinPlaylistParamIds      = "ids"
+	jellyfinPlaylistParamEntryIds = "entryIds"
+
+	jellyfinCollectionParamName = "name"
+	jellyfinCollectionParamIds  = "ids"
+
+	jellyfinUserDataParamDatePlayed = "datePlayed"
+)
+
+// jellyfinItemFields are always requested for library items, since they are used to match items
+var jellyfinItemFields = []string{"ProviderIds", "Path"}
+
+// jellyfinItemSortBy is the order of paged library items. The server's default order is not
+// stable between requests, and there is no sort by ID, so ties in name are broken by the date
+// the item was added.
+const jellyfinItemSortBy = "SortName,DateCreated"
+
 type jellyfinApiKey struct {
 	key     string
+	userId  string
 	isAdmin bool
 }
 
@@ -73,10 +104,16 @@ func (k *jellyfinApiKey) IsAdmin() bool {
 	return k.isAdmin
 }
 
+func (k *jellyfinApiKey) UserId() string {
+	return k.userId
+}
+
 type JellyfinApiClient struct {
 	client   *http.Client
 	hostname string
 	apiKey   gelatin.ApiKey
+	pageSize int
+	retry    *gelatin.RetryPolicy
 	mu       sync.Mutex
 }
 
@@ -87,6 +124,7 @@ func NewJellyfinApiClient(hostname string, apiKey gelatin.ApiKey) *JellyfinApiCl
 		},
 		hostname: hostname,
 		apiKey:   apiKey,
+		retry:    &gelatin.DefaultRetryPolicy,
 	}
 }
 
@@ -100,6 +138,30 @@ func (c *JellyfinApiClient) SetApiKey(key gelatin.ApiKey) {
 	c.mu.Unlock()
 }
 
+// SetRateLimit limits the number of requests per second sent to the server. A rate of 0
+// or less removes the limit.
+func (c *JellyfinApiClient) SetRateLimit(requestsPerSecond float64) {
+	c.mu.Lock()
+	gelatin.SetRateLimit(c.client, requestsPerSecond)
+	c.mu.Unlock()
+}
+
+// SetRetryPolicy sets how requests that fail with a transient error are retried. A nil
+// policy disables retries.
+func (c *JellyfinApiClient) SetRetryPolicy(policy *gelatin.RetryPolicy) {
+	c.mu.Lock()
+	c.retry = policy
+	c.mu.Unlock()
+}
+
+// SetPageSize sets the number of library items requested at a time. A size of 0 or less
+// uses gelatin.DefaultPageSize.
+func (c *JellyfinApiClient) SetPageSize(size int) {
+	c.mu.Lock()
+	c.pageSize = size
+	c.mu.Unlock()
+}
+
 func (c *JellyfinApiClient) System() gelatin.GelatinSystemService {
 	// TODO: Move this out
 	return c
@@ -120,7 +182,12 @@ func (c *JellyfinApiClient) Playlist() gelatin.GelatinPlaylistService {
 	return c
 }
 
-func (c *JellyfinApiClient) request(method string, url string, body io.Reader, key gelatin.ApiKey) (*http.Response, error) {
+func (c *JellyfinApiClient) Collection() gelatin.GelatinCollectionService {
+	// TODO: Move this out
+	return c
+}
+
+func (c *JellyfinApiClient) request(ctx context.Context, method string, url string, body io.Reader, key gelatin.ApiKey) (*http.Response, error) {
 	headers := map[string]string{
 		jellyfinApiKeyAuthHeader: `MediaBrowser Client="gelatin", Device="gelatin", DeviceId="007", Version="0.0.1"`,
 	}
@@ -129,13 +196,17 @@ func (c *JellyfinApiClient) request(method string, url string, body io.Reader, k
 		headers[jellyfinApiKeyTokenHeader] = key.ToString()
 	}
 
-	resp, err := gelatin.HttpRequest(c.client, method, url, body, headers)
+	c.mu.Lock()
+	retry := c.retry
+	c.mu.Unlock()
+
+	resp, err := gelatin.HttpRequestWithRetry(ctx, c.client, retry, method, url, body, headers)
 
 	return resp, err
 }
 
-func (c *JellyfinApiClient) get(url string, key gelatin.ApiKey) (*http.Response, error) {
-	resp, err := c.request(http.MethodGet, url, nil, key)
+func (c *JellyfinApiClient) get(ctx context.Context, url string, key gelatin.ApiKey) (*http.Response, error) {
+	resp, err := c.request(ctx, http.MethodGet, url, nil, key)
 	if err != nil {
 		return nil, err
 	}
@@ -143,8 +214,8 @@ func (c *JellyfinApiClient) get(url string, key gelatin.ApiKey) (*http.Response,
 	return resp, nil
 }
 
-func (c *JellyfinApiClient) Version() (string, error) {
-	resp, err := c.Info(true)
+func (c *JellyfinApiClient) Version(ctx context.Context) (string, error) {
+	resp, err := c.Info(ctx, true)
 	if err != nil {
 		return "", err
 	}
@@ -152,9 +223,9 @@ func (c *JellyfinApiClient) Version() (string, error) {
 	return resp.Version, nil
 }
 
-func (c *JellyfinApiClient) Ping() error {
+func (c *JellyfinApiClient) Ping(ctx context.Context) error {
 	url := fmt.Sprintf("%s%s", c.hostname, jellyfinSystemPingEndpoint)
-	_, err := c.get(url, nil)
+	_, err := c.get(ctx, url, nil)
 	if err != nil {
 		return err
 	}
@@ -162,9 +233,9 @@ func (c *JellyfinApiClient) Ping() error {
 	return nil
 }
 
-func (c *JellyfinApiClient) GetLogs() ([]gelatin.GelatinSystemLog, error) {
+func (c *JellyfinApiClient) GetLogs(ctx context.Context) ([]gelatin.GelatinSystemLog, error) {
 	url := fmt.Sprintf("%s%s", c.hostname, jellyfinSystemLogsEndpoint)
-	raw, err := c.get(url, c.apiKey)
+	raw, err := c.get(ctx, url, c.apiKey)
 	if err != nil {
 		return nil, err
 	}
@@ -178,10 +249,10 @@ func (c *JellyfinApiClient) GetLogs() ([]gelatin.GelatinSystemLog, error) {
 	return resp, nil
 }
 
-func (c *JellyfinApiClient) GetLogFile(name string) (io.ReadCloser, error) {
+func (c *JellyfinApiClient) GetLogFile(ctx context.Context, name string) (io.ReadCloser, error) {
 	url := fmt.Sprintf("%s%s?name=%s", c.hostname, jellyfinSystemLogsNameEndpoint, name)
 
-	resp, err := c.get(url, c.apiKey)
+	resp, err := c.get(ctx, url, c.apiKey)
 	if err != nil {
 		return nil, err
 	}
@@ -189,7 +260,7 @@ func (c *JellyfinApiClient) GetLogFile(name string) (io.ReadCloser, error) {
 	return resp.Body, nil
 }
 
-func (c *JellyfinApiClient) Info(public bool) (*gelatin.GelatinSystemInfo, error) {
+func (c *JellyfinApiClient) Info(ctx context.Context, public bool) (*gelatin.GelatinSystemInfo, error) {
 	var url string
 	if public {
 		url = fmt.Sprintf("%s%s", c.hostname, jellyfinSystemInfoPublicEndpoint)
@@ -197,7 +268,7 @@ func (c *JellyfinApiClient) Info(public bool) (*gelatin.GelatinSystemInfo, error
 		url = fmt.Sprintf("%s%s", c.hostname, jellyfinSystemInfoEndpoint)
 	}
 
-	raw, err := c.get(url, c.apiKey)
+	raw, err := c.get(ctx, url, c.apiKey)
 	if err != nil {
 		return nil, err
 	}
@@ -211,9 +282,9 @@ func (c *JellyfinApiClient) Info(public bool) (*gelatin.GelatinSystemInfo, error
 	return resp, nil
 }
 
-func (c *JellyfinApiClient) GetUser(id string) (*gelatin.GelatinUser, error) {
+func (c *JellyfinApiClient) GetUser(ctx context.Context, id string) (*gelatin.GelatinUser, error) {
 	url := fmt.Sprintf("%s%s/%s", c.hostname, jellyfinUserGetEndpoint, id)
-	raw, err := c.get(url, c.apiKey)
+	raw, err := c.get(ctx, url, c.apiKey)
 	if err != nil {
 		return nil, err
 	}
@@ -227,7 +298,7 @@ func (c *JellyfinApiClient) GetUser(id string) (*gelatin.GelatinUser, error) {
 	return resp, nil
 }
 
-func (c *JellyfinApiClient) GetUsers(public bool) ([]gelatin.GelatinUser, error) {
+func (c *JellyfinApiClient) GetUsers(ctx context.Context, public bool) ([]gelatin.GelatinUser, error) {
 	var url string
 	if public {
 		url = fmt.Sprintf("%s%s", c.hostname, jellyfinUserQueryPublicEndpoint)
@@ -235,7 +306,7 @@ func (c *JellyfinApiClient) GetUsers(public bool) ([]gelatin.GelatinUser, error)
 		url = fmt.Sprintf("%s%s", c.hostname, jellyfinUserQueryEndpoint)
 	}
 
-	raw, err := c.get(url, c.apiKey)
+	raw, err := c.get(ctx, url, c.apiKey)
 	if err != nil {
 		return nil, err
 	}
@@ -249,7 +320,7 @@ func (c *JellyfinApiClient) GetUsers(public bool) ([]gelatin.GelatinUser, error)
 	return resp, nil
 }
 
-func (c *JellyfinApiClient) UpdateUser(id string, data *gelatin.GelatinUser) error {
+func (c *JellyfinApiClient) UpdateUser(ctx context.Context, id string, data *gelatin.GelatinUser) error {
 	url := fmt.Sprintf("%s%s/%s", c.hostname, jellyfinUserUpdateEndpoint, id)
 
 	raw, err := json.Marshal(data)
@@ -257,7 +328,7 @@ func (c *JellyfinApiClient) UpdateUser(id string, data *gelatin.GelatinUser) err
 		return err
 	}
 
-	_, err = c.request(http.MethodPost, url, bytes.NewReader(raw), c.apiKey)
+	_, err = c.request(gelatin.WithIdempotentRequest(ctx), http.MethodPost, url, bytes.NewReader(raw), c.apiKey)
 	if err != nil {
 		return err
 	}
@@ -265,7 +336,7 @@ func (c *JellyfinApiClient) UpdateUser(id string, data *gelatin.GelatinUser) err
 	return nil
 }
 
-func (c *JellyfinApiClient) CreateUser(name string) (*gelatin.GelatinUser, error) {
+func (c *JellyfinApiClient) CreateUser(ctx context.Context, name string) (*gelatin.GelatinUser, error) {
 	type createUserByName struct {
 		Name string
 	}
@@ -277,7 +348,7 @@ func (c *JellyfinApiClient) CreateUser(name string) (*gelatin.GelatinUser, error
 	}
 
 	url := fmt.Sprintf("%s%s", c.hostname, jellyfinUserNewEndpoint)
-	raw, err := c.request(http.MethodPost, url, bytes.NewReader(data), c.apiKey)
+	raw, err := c.request(ctx, http.MethodPost, url, bytes.NewReader(data), c.apiKey)
 	if err != nil {
 		return nil, err
 	}
@@ -291,10 +362,10 @@ func (c *JellyfinApiClient) CreateUser(name string) (*gelatin.GelatinUser, error
 	return resp, nil
 }
 
-func (c *JellyfinApiClient) DeleteUser(id string) error {
+func (c *JellyfinApiClient) DeleteUser(ctx context.Context, id string) error {
 	url := fmt.Sprintf("%s%s/%s", c.hostname, jellyfinUserDeleteEndpoint, id)
 
-	_, err := c.request(http.MethodDelete, url, nil, c.apiKey)
+	_, err := c.request(ctx, http.MethodDelete, url, nil, c.apiKey)
 	if err != nil {
 		return err
 	}
@@ -302,7 +373,7 @@ func (c *JellyfinApiClient) DeleteUser(id string) error {
 	return nil
 }
 
-func (c *JellyfinApiClient) UpdatePassword(id, currentPassword, newPassword string, reset bool) error {
+func (c *JellyfinApiClient) UpdatePassword(ctx context.Context, id, currentPassword, newPassword string, reset bool) error {
 	type setUserPassword struct {
 		Id        string
 		CurrentPw string
@@ -323,7 +394,7 @@ func (c *JellyfinApiClient) UpdatePassword(id, currentPassword, newPassword stri
 	}
 
 	url := fmt.Sprintf("%s%s/%s/Password", c.hostname, jellyfinUserPasswordEndpoint, id)
-	_, err = c.request(http.MethodPost, url, bytes.NewReader(data), c.apiKey)
+	_, err = c.request(gelatin.WithIdempotentRequest(ctx), http.MethodPost, url, bytes.NewReader(data), c.apiKey)
 	if err != nil {
 		return err
 	}
@@ -331,7 +402,7 @@ func (c *JellyfinApiClient) UpdatePassword(id, currentPassword, newPassword stri
 	return nil
 }
 
-func (c *JellyfinApiClient) Authenticate(username, password string) (key gelatin.ApiKey, err error) {
+func (c *JellyfinApiClient) Authenticate(ctx context.Context, username, password string) (key gelatin.ApiKey, err error) {
 	req := map[string]string{
 		"Username": username,
 		"Pw":       password,
@@ -343,7 +414,7 @@ func (c *JellyfinApiClient) Authenticate(username, password string) (key gelatin
 	}
 
 	url := fmt.Sprintf("%s%s", c.hostname, jellyfinUserAuthEndpoint)
-	raw, err := c.request(http.MethodPost, url, bytes.NewReader(data), nil)
+	raw, err := c.request(gelatin.WithIdempotentRequest(ctx), http.MethodPost, url, bytes.NewReader(data), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -355,10 +426,14 @@ func (c *JellyfinApiClient) Authenticate(username, password string) (key gelatin
 		return nil, err
 	}
 
-	return NewApiKey(resp.AccessToken), nil
+	return &jellyfinApiKey{
+		key:     resp.AccessToken,
+		userId:  resp.User.Id,
+		isAdmin: resp.User.Policy.IsAdministrator,
+	}, nil
 }
 
-func (c *JellyfinApiClient) UpdatePolicy(userId string, policy *gelatin.GelatinUserPolicy) error {
+func (c *JellyfinApiClient) UpdatePolicy(ctx context.Context, userId string, policy *gelatin.GelatinUserPolicy) error {
 	url := fmt.Sprintf("%s%s/%s/Policy", c.hostname, jellyfinUserPolicyEndpoint, userId)
 
 	data, err := json.Marshal(policy)
@@ -366,7 +441,7 @@ func (c *JellyfinApiClient) UpdatePolicy(userId string, policy *gelatin.GelatinU
 		return err
 	}
 
-	_, err = c.request(http.MethodPost, url, bytes.NewReader(data), c.apiKey)
+	_, err = c.request(gelatin.WithIdempotentRequest(ctx), http.MethodPost, url, bytes.NewReader(data), c.apiKey)
 	if err != nil {
 		return err
 	}
@@ -374,41 +449,94 @@ func (c *JellyfinApiClient) UpdatePolicy(userId string, policy *gelatin.GelatinU
 	return nil
 }
 
-func (c *JellyfinApiClient) GetItems(filters map[string]string, recursive bool) ([]gelatin.GelatinLibraryItem, error) {
-	if filters == nil {
-		filters = make(map[string]string)
+func (c *JellyfinApiClient) UpdateConfiguration(ctx context.Context, id string, config *gelatin.GelatinUserConfig) error {
+	url := fmt.Sprintf("%s%s/%s/Configuration", c.hostname, jellyfinUserConfigEndpoint, id)
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.request(gelatin.WithIdempotentRequest(ctx), http.MethodPost, url, bytes.NewReader(data), c.apiKey)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *JellyfinApiClient) GetMediaFolders(ctx context.Context) ([]gelatin.GelatinLibraryItem, error) {
+	url := fmt.Sprintf("%s%s", c.hostname, jellyfinLibraryFoldersEndpoint)
+	raw, err := c.get(ctx, url, c.apiKey)
+	if err != nil {
+		return nil, err
 	}
 
-	endpoint := fmt.Sprintf("%s/Items", c.hostname)
+	resp := &JellyfinLibraryItemResponse{}
+	dec := json.NewDecoder(raw.Body)
+	if err := dec.Decode(resp); err != nil {
+		return nil, err
+	}
 
-	// Apply filters to URL string
-	parsedUrl, _ := url.Parse(endpoint)
-	query := parsedUrl.Query()
+	return resp.Items, nil
+}
+
+// itemsQuery builds the query string for an items request with the given filters
+func (c *JellyfinApiClient) itemsQuery(filters map[string]string, recursive bool) url.Values {
+	query := url.Values{}
 	for k, v := range filters {
-		// Always include the ProviderIds in each returned library item
-		if k == jellyfinItemFilterFields && !strings.Contains(v, "ProviderIds") {
-			v += ", ProviderIds"
+		// Always include the fields used to match items in each returned library item
+		if k == jellyfinItemFilterFields {
+			for _, field := range jellyfinItemFields {
+				if !strings.Contains(v, field) {
+					v += ", " + field
+				}
+			}
 		}
 
 		query.Set(k, v)
 	}
 
 	if _, ok := filters[jellyfinItemFilterFields]; !ok {
-		query.Set(jellyfinItemFilterFields, "ProviderIds")
+		query.Set(jellyfinItemFilterFields, strings.Join(jellyfinItemFields, ", "))
 	}
-	query.Set(jellyfinItemFilterRecursive, "true")
 
-	parsedUrl.RawQuery = query.Encode()
+	if recursive {
+		query.Set(jellyfinItemFilterRecursive, "true")
+	}
+
+	return query
+}
 
-	raw, err := c.get(parsedUrl.String(), c.apiKey)
+// getItemsPage fetches a single page of items for the given query
+//
+// endpoint is the path of the items to list (e.g., /Items or the items of a playlist). Items
+// are sorted by jellyfinItemSortBy, so that pages neither overlap nor skip items, except for
+// the items of a playlist, which are listed in playlist order.
+func (c *JellyfinApiClient) getItemsPage(ctx context.Context, endpoint string, query url.Values, startIndex, limit int) ([]gelatin.GelatinLibraryItem, int, error) {
+	parsedUrl, _ := url.Parse(c.hostname + endpoint)
+
+	pageQuery := url.Values{}
+	for k, v := range query {
+		pageQuery[k] = v
+	}
+	pageQuery.Set(jellyfinItemFilterStartIndex, strconv.Itoa(startIndex))
+	pageQuery.Set(jellyfinItemFilterLimit, strconv.Itoa(limit))
+	if !strings.HasPrefix(endpoint, jellyfinPlaylistsEndpoint) {
+		pageQuery.Set(jellyfinItemFilterSortBy, jellyfinItemSortBy)
+		pageQuery.Set(jellyfinItemFilterSortOrder, "Ascending")
+	}
+	parsedUrl.RawQuery = pageQuery.Encode()
+
+	raw, err := c.get(ctx, parsedUrl.String(), c.apiKey)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	resp := &JellyfinLibraryItemResponse{}
 	dec := json.NewDecoder(raw.Body)
 	if err := dec.Decode(resp); err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	// Include the provider IDs as struct fields
@@ -427,20 +555,46 @@ func (c *JellyfinApiClient) GetItems(filters map[string]string, recursive bool)
 		}
 	}
 
-	return resp.Items, nil
+	return resp.Items, int(resp.TotalRecordCount), nil
+}
+
+func (c *JellyfinApiClient) WalkItems(ctx context.Context, filters map[string]string, recursive bool, fn gelatin.GelatinItemPageFunc) error {
+	query := c.itemsQuery(filters, recursive)
+
+	c.mu.Lock()
+	pageSize := c.pageSize
+	c.mu.Unlock()
+
+	fetch := func(ctx context.Context, startIndex, limit int) ([]gelatin.GelatinLibraryItem, int, error) {
+		return c.getItemsPage(ctx, "/Items", query, startIndex, limit)
+	}
+
+	return gelatin.WalkPages(ctx, pageSize, fetch, fn)
+}
+
+func (c *JellyfinApiClient) GetItems(ctx context.Context, filters map[string]string, recursive bool) ([]gelatin.GelatinLibraryItem, error) {
+	return gelatin.CollectPages(func(fn gelatin.GelatinItemPageFunc) error {
+		return c.WalkItems(ctx, filters, recursive, fn)
+	})
 }
 
-func (c *JellyfinApiClient) GetItemsByUser(id string, filters map[string]string) ([]gelatin.GelatinLibraryItem, error) {
+func (c *JellyfinApiClient) WalkItemsByUser(ctx context.Context, id string, filters map[string]string, fn gelatin.GelatinItemPageFunc) error {
 	if filters == nil {
 		filters = make(map[string]string)
 	}
 
 	filters[jellyfinItemFilterUserId] = id
 
-	return c.GetItems(filters, true)
+	return c.WalkItems(ctx, filters, true, fn)
+}
+
+func (c *JellyfinApiClient) GetItemsByUser(ctx context.Context, id string, filters map[string]string) ([]gelatin.GelatinLibraryItem, error) {
+	return gelatin.CollectPages(func(fn gelatin.GelatinItemPageFunc) error {
+		return c.WalkItemsByUser(ctx, id, filters, fn)
+	})
 }
 
-func (c *JellyfinApiClient) UpdateItem(itemId string, item *gelatin.GelatinLibraryItem) error {
+func (c *JellyfinApiClient) UpdateItem(ctx context.Context, itemId string, item *gelatin.GelatinLibraryItem) error {
 	url := fmt.Sprintf("%s/Items/%s", c.hostname, itemId)
 
 	data, err := json.Marshal(item)
@@ -448,7 +602,7 @@ func (c *JellyfinApiClient) UpdateItem(itemId string, item *gelatin.GelatinLibra
 		return err
 	}
 
-	_, err = c.request(http.MethodPost, url, bytes.NewReader(data), nil)
+	_, err = c.request(gelatin.WithIdempotentRequest(ctx), http.MethodPost, url, bytes.NewReader(data), nil)
 	if err != nil {
 		return err
 	}
@@ -456,7 +610,7 @@ func (c *JellyfinApiClient) UpdateItem(itemId string, item *gelatin.GelatinLibra
 	return nil
 }
 
-func (c *JellyfinApiClient) updateItemFavoriteState(itemId string, userId string, favorite bool) error {
+func (c *JellyfinApiClient) updateItemFavoriteState(ctx context.Context, itemId string, userId string, favorite bool) error {
 	url := fmt.Sprintf("%s/Users/%s/FavoriteItems/%s", c.hostname, userId, itemId)
 
 	var method string
@@ -466,7 +620,7 @@ func (c *JellyfinApiClient) updateItemFavoriteState(itemId string, userId string
 		method = http.MethodDelete
 	}
 
-	_, err := c.request(method, url, nil, c.apiKey)
+	_, err := c.request(gelatin.WithIdempotentRequest(ctx), method, url, nil, c.apiKey)
 	if err != nil {
 		return err
 	}
@@ -474,8 +628,17 @@ func (c *JellyfinApiClient) updateItemFavoriteState(itemId string, userId string
 	return nil
 }
 
-func (c *JellyfinApiClient) updateItemPlayedState(itemId string, userId string, played bool) error {
+// updateItemPlayedState marks an item played (on the given date, if any) or unplayed
+func (c *JellyfinApiClient) updateItemPlayedState(ctx context.Context, itemId string, userId string, played bool, datePlayed string) error {
+	query := url.Values{}
+	if played && datePlayed != "" {
+		query.Set(jellyfinUserDataParamDatePlayed, datePlayed)
+	}
+
 	url := fmt.Sprintf("%s/Users/%s/PlayedItems/%s", c.hostname, userId, itemId)
+	if len(query) > 0 {
+		url += "?" + query.Encode()
+	}
 
 	var method string
 	if played {
@@ -484,7 +647,7 @@ func (c *JellyfinApiClient) updateItemPlayedState(itemId string, userId string,
 		method = http.MethodDelete
 	}
 
-	_, err := c.request(method, url, nil, c.apiKey)
+	_, err := c.request(gelatin.WithIdempotentRequest(ctx), method, url, nil, c.apiKey)
 	if err != nil {
 		return err
 	}
@@ -492,19 +655,44 @@ func (c *JellyfinApiClient) updateItemPlayedState(itemId string, userId string,
 	return nil
 }
 
-func (c *JellyfinApiClient) updateItemPlayingState(itemId string, userId string, ticks int64) error {
-	url := fmt.Sprintf("%s/Users/%s/PlayingItems/%s/Progress", c.hostname, userId, itemId)
+// updateItemUserData sets the playback position, rating, likes, play count and last played
+// date of an item. Unlike Emby's, Jellyfin's user data endpoint leaves out the fields that are
+// not sent, so all of them are always sent.
+//
+// The playback position is set here rather than by reporting a playback session: the
+// session endpoints ignore the userId parameter and record playback for the user the API
+// key belongs to.
+func (c *JellyfinApiClient) updateItemUserData(ctx context.Context, itemId string, userId string, activity *gelatin.GelatinLibraryItemUserActivity) error {
+	query := url.Values{}
+	query.Set(jellyfinItemFilterUserId, userId)
 
-	playingStateRequest := map[string]string{
-		"positionTicks": strconv.FormatInt(ticks, 10),
+	url := fmt.Sprintf("%s/UserItems/%s/UserData?%s", c.hostname, itemId, query.Encode())
+
+	type updateUserItemData struct {
+		PlaybackPositionTicks int64
+		Rating                float64
+		Likes                 *bool
+		PlayCount             int32
+		LastPlayedDate        *string
+	}
+	req := &updateUserItemData{
+		PlaybackPositionTicks: activity.PlaybackPositionTicks,
+		Rating:                activity.Rating,
+		Likes:                 activity.Likes,
+		PlayCount:             activity.PlayCount,
+	}
+	// An item that was never played is sent as null, which clears the date set by marking it
+	// played
+	if activity.LastPlayedDate != "" {
+		req.LastPlayedDate = &activity.LastPlayedDate
 	}
 
-	data, err := json.Marshal(playingStateRequest)
+	data, err := json.Marshal(req)
 	if err != nil {
 		return err
 	}
 
-	_, err = c.request(http.MethodPost, url, bytes.NewReader(data), c.apiKey)
+	_, err = c.request(gelatin.WithIdempotentRequest(ctx), http.MethodPost, url, bytes.NewReader(data), c.apiKey)
 	if err != nil {
 		return err
 	}
@@ -512,33 +700,42 @@ func (c *JellyfinApiClient) updateItemPlayingState(itemId string, userId string,
 	return nil
 }
 
-func (c *JellyfinApiClient) UpdateItemUserActivity(itemId string, userId string, old, new *gelatin.GelatinLibraryItemUserActivity) error {
+func equalLikes(a, b *bool) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (c *JellyfinApiClient) UpdateItemUserActivity(ctx context.Context, itemId string, userId string, old, new *gelatin.GelatinLibraryItemUserActivity) error {
 	/*
-		NOTE(aksiksi): Jellyfin does not expose a UserData update endpoint. So, to achieve the same thing,
-		we need to use 3 different endpoints.
+		NOTE(aksiksi): Jellyfin's UserData update endpoint does not handle favorites and played
+		state like Emby's does. So, to achieve the same thing, we need to use several endpoints.
 
-		- Favorite: /Users/{userId}/PlayedItems/{itemId} (POST)
+		- Favorite: /Users/{userId}/FavoriteItems/{itemId} (POST)
 			- DELETE to remove
-		- Played: /Users/{userId}/FavoriteItems/{itemId} (POST)
+		- Played: /Users/{userId}/PlayedItems/{itemId}?datePlayed= (POST)
 			- DELETE to remove
-
-		As far as watch progress goes, you need to start a play session and report an update...
+		- Playback position, rating, likes, play count and last played date:
+		  /UserItems/{itemId}/UserData (POST)
 	*/
 	if old.IsFavorite != new.IsFavorite {
-		if err := c.updateItemFavoriteState(itemId, userId, new.IsFavorite); err != nil {
+		if err := c.updateItemFavoriteState(ctx, itemId, userId, new.IsFavorite); err != nil {
 			return err
 		}
 	}
 
 	if old.Played != new.Played {
-		if err := c.updateItemPlayedState(itemId, userId, new.Played); err != nil {
+		if err := c.updateItemPlayedState(ctx, itemId, userId, new.Played, new.LastPlayedDate); err != nil {
 			return err
 		}
 	}
 
-	// TODO(aksiksi): Figure out why this isn't working. Do we need to use /Sessions?
-	if !new.Played && old.PlaybackPositionTicks != new.PlaybackPositionTicks {
-		if err := c.updateItemPlayingState(itemId, userId, new.PlaybackPositionTicks); err != nil {
+	// Marking an item played also changes its play count and last played date, so the user
+	// data is set last
+	if old.Played != new.Played || old.PlaybackPositionTicks != new.PlaybackPositionTicks || old.Rating != new.Rating ||
+		!equalLikes(old.Likes, new.Likes) || old.PlayCount != new.PlayCount || old.LastPlayedDate != new.LastPlayedDate {
+		if err := c.updateItemUserData(ctx, itemId, userId, new); err != nil {
 			return err
 		}
 	}
@@ -552,13 +749,174 @@ func (c *JellyfinApiClient) GetItemFilterString(filter gelatin.GelatinItemFilter
 		return jellyfinItemFilterFilters
 	case gelatin.GelatinItemFilterParentId:
 		return jellyfinItemFilterParentId
+	case gelatin.GelatinItemFilterIncludeItemTypes:
+		return jellyfinItemFilterIncludeItemTypes
 	case gelatin.GelatinItemFilterFiltersIsFolder:
 		return jellyfinItemFilterFiltersIsFolder
 	case gelatin.GelatinItemFilterFiltersIsNotFolder:
 		return jellyfinItemFilterFiltersIsNotFolder
 	case gelatin.GelatinItemFilterFiltersIsPlayed:
 		return jellyfinItemFilterFiltersIsPlayed
+	case gelatin.GelatinItemFilterMinDateLastSavedForUser:
+		return jellyfinItemFilterMinDateLastSavedForUser
+	case gelatin.GelatinItemFilterFields:
+		return jellyfinItemFilterFields
 	default:
 		panic("invalid filter name")
 	}
 }
+
+func (c *JellyfinApiClient) GetPlaylists(ctx context.Context, userId string) ([]gelatin.GelatinLibraryItem, error) {
+	filters := map[string]string{
+		jellyfinItemFilterIncludeItemTypes: "Playlist",
+	}
+
+	return c.GetItemsByUser(ctx, userId, filters)
+}
+
+// getAllItems fetches all pages of items of the given endpoint
+func (c *JellyfinApiClient) getAllItems(ctx context.Context, endpoint string, query url.Values) ([]gelatin.GelatinLibraryItem, error) {
+	c.mu.Lock()
+	pageSize := c.pageSize
+	c.mu.Unlock()
+
+	fetch := func(ctx context.Context, startIndex, limit int) ([]gelatin.GelatinLibraryItem, int, error) {
+		return c.getItemsPage(ctx, endpoint, query, startIndex, limit)
+	}
+
+	return gelatin.CollectPages(func(fn gelatin.GelatinItemPageFunc) error {
+		return gelatin.WalkPages(ctx, pageSize, fetch, fn)
+	})
+}
+
+func (c *JellyfinApiClient) GetPlaylistItems(ctx context.Context, playlistId, userId string) ([]gelatin.GelatinLibraryItem, error) {
+	endpoint := fmt.Sprintf("%s/%s/Items", jellyfinPlaylistsEndpoint, playlistId)
+	query := c.itemsQuery(map[string]string{jellyfinItemFilterUserId: userId}, false)
+
+	return c.getAllItems(ctx, endpoint, query)
+}
+
+func (c *JellyfinApiClient) CreatePlaylist(ctx context.Context, userId, name, mediaType string, itemIds []string) (string, error) {
+	type createPlaylist struct {
+		Name      string
+		Ids       []string `json:",omitempty"`
+		UserId    string
+		MediaType string `json:",omitempty"`
+	}
+	req := &createPlaylist{Name: name, Ids: itemIds, UserId: userId, MediaType: mediaType}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	url := fmt.Sprintf("%s%s", c.hostname, jellyfinPlaylistsEndpoint)
+	raw, err := c.request(ctx, http.MethodPost, url, bytes.NewReader(data), c.apiKey)
+	if err != nil {
+		return "", err
+	}
+
+	resp := &JellyfinPlaylistCreationResult{}
+	dec := json.NewDecoder(raw.Body)
+	if err := dec.Decode(resp); err != nil {
+		return "", err
+	}
+
+	return resp.Id, nil
+}
+
+func (c *JellyfinApiClient) AddPlaylistItems(ctx context.Context, playlistId, userId string, itemIds []string) error {
+	query := url.Values{}
+	query.Set(jellyfinPlaylistParamIds, strings.Join(itemIds, ","))
+	query.Set(jellyfinItemFilterUserId, userId)
+
+	url := fmt.Sprintf("%s%s/%s/Items?%s", c.hostname, jellyfinPlaylistsEndpoint, playlistId, query.Encode())
+	_, err := c.request(ctx, http.MethodPost, url, nil, c.apiKey)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *JellyfinApiClient) RemovePlaylistItems(ctx context.Context, playlistId string, entryIds []string) error {
+	query := url.Values{}
+	query.Set(jellyfinPlaylistParamEntryIds, strings.Join(entryIds, ","))
+
+	url := fmt.Sprintf("%s%s/%s/Items?%s", c.hostname, jellyfinPlaylistsEndpoint, playlistId, query.Encode())
+	_, err := c.request(ctx, http.MethodDelete, url, nil, c.apiKey)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *JellyfinApiClient) MovePlaylistItem(ctx context.Context, playlistId, entryId string, index int) error {
+	url := fmt.Sprintf("%s%s/%s/Items/%s/Move/%d", c.hostname, jellyfinPlaylistsEndpoint, playlistId, entryId, index)
+
+	_, err := c.request(ctx, http.MethodPost, url, nil, c.apiKey)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *JellyfinApiClient) GetCollections(ctx context.Context) ([]gelatin.GelatinLibraryItem, error) {
+	filters := map[string]string{
+		jellyfinItemFilterIncludeItemTypes: "BoxSet",
+	}
+
+	return c.GetItems(ctx, filters, true)
+}
+
+func (c *JellyfinApiClient) GetCollectionItems(ctx context.Context, collectionId string) ([]gelatin.GelatinLibraryItem, error) {
+	// Only the members themselves, not the seasons and episodes of member series
+	query := c.itemsQuery(map[string]string{jellyfinItemFilterParentId: collectionId}, false)
+
+	return c.getAllItems(ctx, "/Items", query)
+}
+
+func (c *JellyfinApiClient) CreateCollection(ctx context.Context, name string, itemIds []string) (string, error) {
+	query := url.Values{}
+	query.Set(jellyfinCollectionParamName, name)
+	if len(itemIds) > 0 {
+		query.Set(jellyfinCollectionParamIds, strings.Join(itemIds, ","))
+	}
+
+	url := fmt.Sprintf("%s%s?%s", c.hostname, jellyfinCollectionsEndpoint, query.Encode())
+	raw, err := c.request(ctx, http.MethodPost, url, nil, c.apiKey)
+	if err != nil {
+		return "", err
+	}
+
+	resp := &JellyfinCollectionCreationResult{}
+	dec := json.NewDecoder(raw.Body)
+	if err := dec.Decode(resp); err != nil {
+		return "", err
+	}
+
+	return resp.Id, nil
+}
+
+func (c *JellyfinApiClient) AddCollectionItems(ctx context.Context, collectionId string, itemIds []string) error {
+	return c.updateCollectionItems(ctx, http.MethodPost, collectionId, itemIds)
+}
+
+func (c *JellyfinApiClient) RemoveCollectionItems(ctx context.Context, collectionId string, itemIds []string) error {
+	return c.updateCollectionItems(ctx, http.MethodDelete, collectionId, itemIds)
+}
+
+func (c *JellyfinApiClient) updateCollectionItems(ctx context.Context, method, collectionId string, itemIds []string) error {
+	query := url.Values{}
+	query.Set(jellyfinCollectionParamIds, strings.Join(itemIds, ","))
+
+	url := fmt.Sprintf("%s%s/%s/Items?%s", c.hostname, jellyfinCollectionsEndpoint, collectionId, query.Encode())
+	_, err := c.request(ctx, method, url, nil, c.apiKey)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
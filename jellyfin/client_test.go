@@ -1,12 +1,15 @@
 package jellyfin
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -15,11 +18,14 @@ import (
 )
 
 type mockJellyfinServer struct {
-	resp   []byte
-	status int
+	resp    []byte
+	status  int
+	lastReq *http.Request
 }
 
 func (s *mockJellyfinServer) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	s.lastReq = req
+
 	if s.status != http.StatusOK {
 		http.Error(resp, http.StatusText(s.status), s.status)
 		return
@@ -57,13 +63,36 @@ func TestJellyfinSystemEndpoints(t *testing.T) {
 	client, srv, s := setUp(t)
 	defer srv.Close()
 
+	ctx := context.Background()
+
 	s.status = http.StatusOK
 
 	t.Run("SystemPing", func(t *testing.T) {
-		err := client.Ping()
+		err := client.Ping(ctx)
+		if err != nil {
+			t.Errorf("failed to call ping endpoint")
+		}
+	})
+
+	t.Run("SystemPing_cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		err := client.Ping(ctx)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got: %v", err)
+		}
+	})
+
+	t.Run("SystemPing_traceId", func(t *testing.T) {
+		err := client.Ping(gelatin.WithTraceId(ctx, "trace-123"))
 		if err != nil {
 			t.Errorf("failed to call ping endpoint")
 		}
+
+		if got := s.lastReq.Header.Get("X-Request-ID"); got != "trace-123" {
+			t.Errorf("trace ID mismatch: want %q != got %q", "trace-123", got)
+		}
 	})
 
 	t.Run("SystemInfoPublic", func(t *testing.T) {
@@ -78,7 +107,7 @@ func TestJellyfinSystemEndpoints(t *testing.T) {
 		want := &gelatin.GelatinSystemInfo{}
 		json.Unmarshal(wantResp, want)
 
-		got, err := client.Info(true)
+		got, err := client.Info(ctx, true)
 		if err != nil {
 			t.Errorf("failed to call SystemInfoPublic endpoint")
 		}
@@ -99,7 +128,7 @@ func TestJellyfinSystemEndpoints(t *testing.T) {
 		want := &JellyfinSystemInfoPublicResponse{}
 		json.Unmarshal(wantResp, want)
 
-		version, err := client.Version()
+		version, err := client.Version(ctx)
 		if err != nil {
 			t.Errorf("failed to call GetVersion")
 		}
@@ -113,7 +142,7 @@ func TestJellyfinSystemEndpoints(t *testing.T) {
 		wantResp := []byte("this is a log file")
 		s.resp = wantResp
 
-		logReader, err := client.GetLogFile("test")
+		logReader, err := client.GetLogFile(ctx, "test")
 		if err != nil {
 			t.Errorf("failed to call SystemLogs")
 		}
@@ -133,7 +162,7 @@ func TestJellyfinSystemEndpoints(t *testing.T) {
 		var want []gelatin.GelatinSystemLog
 		json.Unmarshal(data, &want)
 
-		got, err := client.GetLogs()
+		got, err := client.GetLogs(ctx)
 		if err != nil {
 			t.Errorf("failed to call SystemLogsQuery")
 		}
@@ -148,6 +177,8 @@ func TestJellyfinUserEndpoints(t *testing.T) {
 	client, srv, s := setUp(t)
 	defer srv.Close()
 
+	ctx := context.Background()
+
 	s.status = http.StatusOK
 
 	t.Run("GetUsers_public", func(t *testing.T) {
@@ -164,7 +195,7 @@ func TestJellyfinUserEndpoints(t *testing.T) {
 		var want []gelatin.GelatinUser
 		json.Unmarshal(wantResp, &want)
 
-		got, err := client.GetUsers(true)
+		got, err := client.GetUsers(ctx, true)
 		if err != nil {
 			t.Errorf("failed to call endpoint")
 		}
@@ -182,7 +213,7 @@ func TestJellyfinUserEndpoints(t *testing.T) {
 		var want []gelatin.GelatinUser
 		json.Unmarshal(wantResp, &want)
 
-		got, err := client.GetUsers(false)
+		got, err := client.GetUsers(ctx, false)
 		if err != nil {
 			t.Errorf("failed to call endpoint")
 		}
@@ -204,7 +235,7 @@ func TestJellyfinUserEndpoints(t *testing.T) {
 		var want *gelatin.GelatinUser
 		json.Unmarshal(wantResp, &want)
 
-		got, err := client.GetUser(want.Id)
+		got, err := client.GetUser(ctx, want.Id)
 		if err != nil {
 			t.Errorf("failed to call endpoint")
 		}
@@ -216,7 +247,7 @@ func TestJellyfinUserEndpoints(t *testing.T) {
 
 	t.Run("UserUpdate", func(t *testing.T) {
 		user := &gelatin.GelatinUser{Id: "abcd123"}
-		err := client.UpdateUser(user.Id, user)
+		err := client.UpdateUser(ctx, user.Id, user)
 		if err != nil {
 			t.Errorf("failed to call endpoint")
 		}
@@ -234,7 +265,7 @@ func TestJellyfinUserEndpoints(t *testing.T) {
 		var want *gelatin.GelatinUser
 		json.Unmarshal(wantResp, &want)
 
-		got, err := client.CreateUser(want.Name)
+		got, err := client.CreateUser(ctx, want.Name)
 		if err != nil {
 			t.Errorf("failed to call endpoint")
 		}
@@ -245,14 +276,14 @@ func TestJellyfinUserEndpoints(t *testing.T) {
 	})
 
 	t.Run("UserDelete", func(t *testing.T) {
-		err := client.DeleteUser("test123")
+		err := client.DeleteUser(ctx, "test123")
 		if err != nil {
 			t.Errorf("failed to call endpoint")
 		}
 	})
 
 	t.Run("UserPassword", func(t *testing.T) {
-		err := client.UpdatePassword("1000x1000", "", "test123", true)
+		err := client.UpdatePassword(ctx, "1000x1000", "", "test123", true)
 		if err != nil {
 			t.Errorf("failed to call endpoint")
 		}
@@ -262,12 +293,16 @@ func TestJellyfinUserEndpoints(t *testing.T) {
 		wantToken := "12345"
 		wantUserAuthResp := &JellyfinUserAuthResponse{
 			AccessToken: wantToken,
+			User: gelatin.GelatinUser{
+				Id:     "1000x1000",
+				Policy: gelatin.GelatinUserPolicy{IsAdministrator: true},
+			},
 		}
 		wantResp, _ := json.Marshal(wantUserAuthResp)
 
 		s.resp = wantResp
 
-		key, err := client.Authenticate("abcd", "test123")
+		key, err := client.Authenticate(ctx, "abcd", "test123")
 		if err != nil {
 			t.Errorf("failed to call endpoint")
 		}
@@ -277,13 +312,374 @@ func TestJellyfinUserEndpoints(t *testing.T) {
 		if diff := cmp.Diff(wantToken, token); diff != "" {
 			t.Errorf("-want,+got: %s", diff)
 		}
+
+		if key.UserId() != "1000x1000" || !key.IsAdmin() {
+			t.Errorf("unexpected key user: %q, admin = %v", key.UserId(), key.IsAdmin())
+		}
 	})
 
 	t.Run("UserPolicy", func(t *testing.T) {
 		policy := &gelatin.GelatinUserPolicy{}
-		err := client.UpdatePolicy("abcd", policy)
+		err := client.UpdatePolicy(ctx, "abcd", policy)
+		if err != nil {
+			t.Errorf("failed to call endpoint")
+		}
+	})
+	t.Run("UserConfiguration", func(t *testing.T) {
+		config := &gelatin.GelatinUserConfig{SubtitleMode: "Smart"}
+		err := client.UpdateConfiguration(ctx, "abcd", config)
+		if err != nil {
+			t.Errorf("failed to call endpoint")
+		}
+	})
+}
+
+func TestJellyfinLibraryEndpoints(t *testing.T) {
+	client, srv, s := setUp(t)
+	defer srv.Close()
+
+	ctx := context.Background()
+
+	s.status = http.StatusOK
+
+	t.Run("GetMediaFolders", func(t *testing.T) {
+		wantResp := []byte(`{
+			"Items": [
+				{"Name": "Movies", "Id": "f137a2dd21bbc1b99aa5c0f6bf02a805", "IsFolder": true},
+				{"Name": "Shows", "Id": "767bffe4f11c93ef34b805451a696a4e", "IsFolder": true}
+			],
+			"TotalRecordCount": 2
+		}`)
+
+		s.resp = wantResp
+
+		var want struct {
+			Items []gelatin.GelatinLibraryItem
+		}
+		json.Unmarshal(wantResp, &want)
+
+		got, err := client.GetMediaFolders(ctx)
 		if err != nil {
 			t.Errorf("failed to call endpoint")
 		}
+
+		if diff := cmp.Diff(want.Items, got); diff != "" {
+			t.Errorf("-want,+got: %s", diff)
+		}
 	})
 }
+
+func TestJellyfinGetItemsPaged(t *testing.T) {
+	var library []gelatin.GelatinLibraryItem
+	for i := 0; i < 25; i++ {
+		library = append(library, gelatin.GelatinLibraryItem{
+			Id:          fmt.Sprintf("item-%d", i),
+			ProviderIds: map[string]string{"Imdb": fmt.Sprintf("tt%d", i)},
+		})
+	}
+
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		requests = append(requests, query.Get("startIndex")+"/"+query.Get("limit"))
+		if query.Get("sortBy") == "" {
+			t.Errorf("expected a fixed sort order, got: %s", req.URL.RawQuery)
+		}
+
+		startIndex, _ := strconv.Atoi(query.Get("startIndex"))
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		end := startIndex + limit
+		if end > len(library) {
+			end = len(library)
+		}
+
+		json.NewEncoder(resp).Encode(&JellyfinLibraryItemResponse{
+			Items:            library[startIndex:end],
+			TotalRecordCount: int32(len(library)),
+		})
+	}))
+	defer srv.Close()
+
+	client := NewJellyfinApiClient(srv.URL, NewApiKey("test123"))
+	client.SetPageSize(10)
+
+	got, err := client.GetItems(context.Background(), nil, true)
+	if err != nil {
+		t.Fatalf("failed to get items: %v", err)
+	}
+
+	if diff := cmp.Diff([]string{"0/10", "10/10", "20/10"}, requests); diff != "" {
+		t.Errorf("-want,+got: %s", diff)
+	}
+
+	if len(got) != len(library) {
+		t.Fatalf("expected %d items, got %d", len(library), len(got))
+	}
+	if got[24].Id != "item-24" || got[24].ImdbId != "tt24" {
+		t.Errorf("unexpected last item: %+v", got[24])
+	}
+}
+
+func TestJellyfinPlaylistEndpoints(t *testing.T) {
+	var requests []string
+	var created map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		query.Del("fields")
+		query.Del("startIndex")
+		query.Del("limit")
+		requests = append(requests, req.Method+" "+req.URL.Path+"?"+query.Encode())
+
+		switch {
+		case req.Method == http.MethodPost && req.URL.Path == "/Playlists":
+			json.NewDecoder(req.Body).Decode(&created)
+			resp.Write([]byte(`{"Id": "pl-new"}`))
+		case req.Method == http.MethodGet:
+			resp.Write([]byte(`{
+				"Items": [
+					{"Id": "heat", "Type": "Movie", "PlaylistItemId": "1"},
+					{"Id": "ronin", "Type": "Movie", "PlaylistItemId": "2"}
+				],
+				"TotalRecordCount": 2
+			}`))
+		default:
+			resp.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	client := NewJellyfinApiClient(srv.URL, NewApiKey("test123"))
+	ctx := context.Background()
+
+	if _, err := client.GetPlaylists(ctx, "alice"); err != nil {
+		t.Fatalf("failed to get playlists: %v", err)
+	}
+
+	items, err := client.GetPlaylistItems(ctx, "pl", "alice")
+	if err != nil {
+		t.Fatalf("failed to get playlist items: %v", err)
+	}
+	if len(items) != 2 || items[1].Id != "ronin" || items[1].PlaylistItemId != "2" {
+		t.Errorf("unexpected playlist items: %+v", items)
+	}
+
+	id, err := client.CreatePlaylist(ctx, "alice", "Heists", "", []string{"heat", "ronin"})
+	if err != nil || id != "pl-new" {
+		t.Fatalf("failed to create playlist: %q, %v", id, err)
+	}
+	wantCreated := map[string]interface{}{"Name": "Heists", "UserId": "alice", "Ids": []interface{}{"heat", "ronin"}}
+	if diff := cmp.Diff(wantCreated, created); diff != "" {
+		t.Errorf("-want,+got: %s", diff)
+	}
+
+	if err := client.AddPlaylistItems(ctx, "pl", "alice", []string{"heat"}); err != nil {
+		t.Errorf("failed to add playlist items: %v", err)
+	}
+	if err := client.RemovePlaylistItems(ctx, "pl", []string{"1", "2"}); err != nil {
+		t.Errorf("failed to remove playlist items: %v", err)
+	}
+	if err := client.MovePlaylistItem(ctx, "pl", "2", 0); err != nil {
+		t.Errorf("failed to move playlist item: %v", err)
+	}
+
+	want := []string{
+		"GET /Items?includeItemTypes=Playlist&recursive=true&sortBy=SortName%2CDateCreated&sortOrder=Ascending&userId=alice",
+		"GET /Playlists/pl/Items?userId=alice",
+		"POST /Playlists?",
+		"POST /Playlists/pl/Items?ids=heat&userId=alice",
+		"DELETE /Playlists/pl/Items?entryIds=1%2C2",
+		"POST /Playlists/pl/Items/2/Move/0?",
+	}
+	if diff := cmp.Diff(want, requests); diff != "" {
+		t.Errorf("-want,+got: %s", diff)
+	}
+}
+
+func TestJellyfinCollectionEndpoints(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		query.Del("fields")
+		query.Del("startIndex")
+		query.Del("limit")
+		requests = append(requests, req.Method+" "+req.URL.Path+"?"+query.Encode())
+
+		switch req.Method {
+		case http.MethodGet:
+			resp.Write([]byte(`{"Items": [{"Id": "thor", "Type": "Movie"}], "TotalRecordCount": 1}`))
+		case http.MethodPost:
+			resp.Write([]byte(`{"Id": "mcu"}`))
+		default:
+			resp.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	client := NewJellyfinApiClient(srv.URL, NewApiKey("test123"))
+	ctx := context.Background()
+
+	if _, err := client.GetCollections(ctx); err != nil {
+		t.Fatalf("failed to get collections: %v", err)
+	}
+
+	items, err := client.GetCollectionItems(ctx, "mcu")
+	if err != nil || len(items) != 1 || items[0].Id != "thor" {
+		t.Fatalf("unexpected collection items: %+v, %v", items, err)
+	}
+
+	id, err := client.CreateCollection(ctx, "Marvel Cinematic Universe", []string{"thor", "loki"})
+	if err != nil || id != "mcu" {
+		t.Fatalf("failed to create collection: %q, %v", id, err)
+	}
+
+	if err := client.AddCollectionItems(ctx, "mcu", []string{"eternals"}); err != nil {
+		t.Errorf("failed to add collection items: %v", err)
+	}
+	if err := client.RemoveCollectionItems(ctx, "mcu", []string{"thor", "loki"}); err != nil {
+		t.Errorf("failed to remove collection items: %v", err)
+	}
+
+	want := []string{
+		"GET /Items?includeItemTypes=BoxSet&recursive=true&sortBy=SortName%2CDateCreated&sortOrder=Ascending",
+		"GET /Items?parentId=mcu&sortBy=SortName%2CDateCreated&sortOrder=Ascending",
+		"POST /Collections?ids=thor%2Cloki&name=Marvel+Cinematic+Universe",
+		"POST /Collections/mcu/Items?ids=eternals",
+		"DELETE /Collections/mcu/Items?ids=thor%2Cloki",
+	}
+	if diff := cmp.Diff(want, requests); diff != "" {
+		t.Errorf("-want,+got: %s", diff)
+	}
+}
+
+func TestJellyfinUpdateItemUserActivity(t *testing.T) {
+	var requests []string
+	var userData map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req.Method+" "+req.URL.Path+"?"+req.URL.Query().Encode())
+
+		if strings.HasSuffix(req.URL.Path, "/UserData") {
+			if err := json.NewDecoder(req.Body).Decode(&userData); err != nil {
+				t.Errorf("failed to decode user data: %v", err)
+			}
+		}
+		resp.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	client := NewJellyfinApiClient(srv.URL, NewApiKey("test123"))
+	ctx := context.Background()
+
+	likes := true
+	old := &gelatin.GelatinLibraryItemUserActivity{}
+	new := &gelatin.GelatinLibraryItemUserActivity{
+		Played:         true,
+		PlayCount:      3,
+		Rating:         8,
+		Likes:          &likes,
+		LastPlayedDate: "2023-04-01T20:15:00Z",
+	}
+	if err := client.UpdateItemUserActivity(ctx, "heat", "alice", old, new); err != nil {
+		t.Fatalf("failed to update user activity: %v", err)
+	}
+
+	// The user data is set last, since marking an item played bumps its play count
+	want := []string{
+		"POST /Users/alice/PlayedItems/heat?datePlayed=2023-04-01T20%3A15%3A00Z",
+		"POST /UserItems/heat/UserData?userId=alice",
+	}
+	if diff := cmp.Diff(want, requests); diff != "" {
+		t.Errorf("-want,+got: %s", diff)
+	}
+
+	wantData := map[string]interface{}{
+		"PlaybackPositionTicks": 0.0,
+		"Rating":                8.0,
+		"Likes":                 true,
+		"PlayCount":             3.0,
+		"LastPlayedDate":        "2023-04-01T20:15:00Z",
+	}
+	if diff := cmp.Diff(wantData, userData); diff != "" {
+		t.Errorf("-want,+got: %s", diff)
+	}
+
+	// Nothing is sent if the activity is unchanged
+	requests = nil
+	if err := client.UpdateItemUserActivity(ctx, "heat", "alice", new, new); err != nil {
+		t.Fatalf("failed to update user activity: %v", err)
+	}
+	if len(requests) != 0 {
+		t.Errorf("expected no requests, got: %v", requests)
+	}
+}
+
+func TestJellyfinUpdateItemPlaybackPosition(t *testing.T) {
+	// User data by user ID and item ID, as sent to the user data endpoint
+	userData := make(map[string]map[string]map[string]interface{})
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		path := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+		switch {
+		case len(path) == 3 && path[0] == "UserItems" && path[2] == "UserData":
+			userId := req.URL.Query().Get("userId")
+			if userData[userId] == nil {
+				userData[userId] = make(map[string]map[string]interface{})
+			}
+
+			var data map[string]interface{}
+			if err := json.NewDecoder(req.Body).Decode(&data); err != nil {
+				t.Errorf("failed to decode user data: %v", err)
+			}
+			userData[userId][path[1]] = data
+		case path[0] == "Sessions":
+			// Playback sessions belong to the user of the API key, whatever the userId
+			t.Errorf("unexpected playback report: %s", req.URL)
+		}
+		resp.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	client := NewJellyfinApiClient(srv.URL, NewApiKey("test123"))
+	ctx := context.Background()
+
+	old := &gelatin.GelatinLibraryItemUserActivity{}
+	new := &gelatin.GelatinLibraryItemUserActivity{
+		PlaybackPositionTicks: 36000000000,
+		PlayCount:             1,
+		LastPlayedDate:        "2023-04-01T20:15:00Z",
+	}
+	if err := client.UpdateItemUserActivity(ctx, "heat", "alice", old, new); err != nil {
+		t.Fatalf("failed to update user activity: %v", err)
+	}
+
+	heat := userData["alice"]["heat"]
+	if heat["PlaybackPositionTicks"] != float64(new.PlaybackPositionTicks) {
+		t.Errorf("expected the playback position of alice to be set, got: %v", heat)
+	}
+	if heat["LastPlayedDate"] != new.LastPlayedDate {
+		t.Errorf("expected the last played date of alice to be set, got: %v", heat)
+	}
+	if len(userData) != 1 {
+		t.Errorf("expected only the user data of alice to be set, got: %v", userData)
+	}
+
+	// Without a last played date, the date is cleared
+	undated := &gelatin.GelatinLibraryItemUserActivity{PlaybackPositionTicks: 12000000000}
+	if err := client.UpdateItemUserActivity(ctx, "ronin", "alice", old, undated); err != nil {
+		t.Fatalf("failed to update user activity: %v", err)
+	}
+	ronin := userData["alice"]["ronin"]
+	if ronin["PlaybackPositionTicks"] != float64(undated.PlaybackPositionTicks) {
+		t.Errorf("expected the playback position of alice to be set, got: %v", ronin)
+	}
+	if date, ok := ronin["LastPlayedDate"]; !ok || date != nil {
+		t.Errorf("expected an explicit null last played date, got: %v", date)
+	}
+
+	// Played items have no playback position
+	played := &gelatin.GelatinLibraryItemUserActivity{Played: true, PlaybackPositionTicks: 0}
+	if err := client.UpdateItemUserActivity(ctx, "heat", "alice", new, played); err != nil {
+		t.Fatalf("failed to update user activity: %v", err)
+	}
+	if ticks := userData["alice"]["heat"]["PlaybackPositionTicks"]; ticks != float64(0) {
+		t.Errorf("expected the playback position of a played item to be cleared, got: %v", ticks)
+	}
+}
@@ -4,6 +4,7 @@ import gelatin "github.com/aksiksi/gelatin/lib"
 
 type JellyfinUserAuthResponse struct {
 	AccessToken string
+	User        gelatin.GelatinUser
 }
 
 type JellyfinUserAccessSchedule struct {
@@ -148,3 +149,11 @@ type JellyfinLibraryItemResponse struct {
 	Items            []gelatin.GelatinLibraryItem
 	TotalRecordCount int32
 }
+
+type JellyfinPlaylistCreationResult struct {
+	Id string
+}
+
+type JellyfinCollectionCreationResult struct {
+	Id string
+}
@@ -1,47 +1,344 @@
 package gelatin
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
+	"math/rand"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 type ApiKey interface {
 	ToString() string
 	IsAdmin() bool
+
+	// UserId returns the ID of the user this key was issued to.
+	//
+	// This is empty for keys that were not obtained through Authenticate().
+	UserId() string
+}
+
+// maxErrorBodySize is the maximum number of bytes of a response body kept in an HttpError
+const maxErrorBodySize = 512
+
+// HttpError is returned for requests that fail with an unexpected HTTP status.
+//
+// Use errors.As to inspect it, e.g. to check for a 404.
+type HttpError struct {
+	StatusCode int
+	Method     string
+
+	// Endpoint is the path of the request URL. The query string is omitted, since it can
+	// hold credentials.
+	Endpoint string
+
+	// Body holds the start of the response body, which usually explains the error
+	Body string
+}
+
+func (e *HttpError) Error() string {
+	msg := fmt.Sprintf("%s %s: %d %s", e.Method, e.Endpoint, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+// newHttpError builds an HttpError from the response, and closes the response body
+func newHttpError(req *http.Request, resp *http.Response) *HttpError {
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+
+	return &HttpError{
+		StatusCode: resp.StatusCode,
+		Method:     req.Method,
+		Endpoint:   req.URL.Path,
+		Body:       strings.TrimSpace(string(body)),
+	}
+}
+
+// RetryPolicy controls how requests that fail with a transient error are retried.
+//
+// Requests are retried on a 429, 502, 503 or 504 status and when the connection is reset
+// or refused (e.g., while the server restarts). A request that reached the server may
+// have been acted on, so only idempotent requests (GET, HEAD, PUT, DELETE, or any request
+// made with a context from WithIdempotentRequest) are retried in that case; other requests
+// are only retried if they were refused (a 429 status or a failure to connect).
+//
+// The delay before each retry grows exponentially from MinBackoff up to MaxBackoff, with
+// random jitter so that parallel requests do not retry in lockstep. A Retry-After header
+// sent by the server is honored if it asks for a longer delay.
+type RetryPolicy struct {
+	// MaxRetries is the maximum number of times a request is retried (0 = no retries)
+	MaxRetries int
+
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
 }
 
-func httpStatusToErr(code int) error {
+// DefaultRetryPolicy is the retry policy used by API clients unless one is set
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 5,
+	MinBackoff: 500 * time.Millisecond,
+	MaxBackoff: 30 * time.Second,
+}
+
+// backoff returns the delay before the given retry (starting from 0)
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	d := p.MinBackoff
+	for i := 0; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	// Wait at least half of the backoff, plus a random part of the rest
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+func isRetryableStatus(code int) bool {
 	switch code {
-	case http.StatusOK, http.StatusNoContent:
-		return nil
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
 	default:
-		return errors.New(http.StatusText(code))
+		return false
 	}
 }
 
-func HttpRequest(client *http.Client, method string, url string, body io.Reader, headers map[string]string) (*http.Response, error) {
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return nil, err
+func isRetryableErr(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+// isDialErr returns true if the request failed before it was sent, while connecting
+func isDialErr(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+type idempotentKey struct{}
+
+// WithIdempotentRequest returns a context for requests that can safely be sent more than
+// once, even though their method is not idempotent (e.g., a POST that sets user data).
+// Such requests are retried like GET requests. See RetryPolicy.
+func WithIdempotentRequest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+func isIdempotent(ctx context.Context, method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	default:
+		idempotent, _ := ctx.Value(idempotentKey{}).(bool)
+		return idempotent
 	}
+}
 
-	for k, v := range headers {
-		req.Header.Add(k, v)
+// parseRetryAfter parses a Retry-After header, which holds either a number of seconds or
+// an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
 	}
 
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		d := t.Sub(now)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+
+	return 0, false
+}
+
+type traceIdKey struct{}
+
+// WithTraceId returns a context that carries the given trace ID. Requests made with the
+// context send the ID in the X-Request-ID header, so that they can be correlated with
+// server logs.
+func WithTraceId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceIdKey{}, id)
+}
+
+// TraceId returns the trace ID carried by the context, if any
+func TraceId(ctx context.Context) string {
+	id, _ := ctx.Value(traceIdKey{}).(string)
+	return id
+}
+
+// HttpRequest sends a single request without retries. See HttpRequestWithRetry.
+func HttpRequest(ctx context.Context, client *http.Client, method string, url string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	return HttpRequestWithRetry(ctx, client, nil, method, url, body, headers)
+}
+
+// HttpRequestWithRetry sends a request, retrying transient failures according to the given
+// policy (nil = no retries).
+//
+// Any status other than 200 or 204 is returned as an *HttpError. The request body is
+// buffered so that it can be sent again.
+func HttpRequestWithRetry(ctx context.Context, client *http.Client, policy *RetryPolicy, method string, url string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	var data []byte
 	if body != nil {
-		req.Header.Add("Content-Type", "application/json")
+		var err error
+		if data, err = io.ReadAll(body); err != nil {
+			return nil, err
+		}
+	}
+
+	idempotent := isIdempotent(ctx, method)
+
+	for retry := 0; ; retry++ {
+		var reqBody io.Reader
+		if body != nil {
+			reqBody = bytes.NewReader(data)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range headers {
+			req.Header.Add(k, v)
+		}
+
+		if id := TraceId(ctx); id != "" {
+			req.Header.Set("X-Request-ID", id)
+		}
+
+		if body != nil {
+			req.Header.Add("Content-Type", "application/json")
+		}
+
+		var (
+			retryAfter    time.Duration
+			hasRetryAfter bool
+		)
+
+		resp, err := client.Do(req)
+		switch {
+		case err != nil:
+			if ctx.Err() != nil || !isRetryableErr(err) || (!idempotent && !isDialErr(err)) {
+				return nil, err
+			}
+		case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent:
+			return resp, nil
+		default:
+			retryAfter, hasRetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			httpErr := newHttpError(req, resp)
+			if !isRetryableStatus(resp.StatusCode) || (!idempotent && resp.StatusCode != http.StatusTooManyRequests) {
+				return nil, httpErr
+			}
+			err = httpErr
+		}
+
+		if policy == nil || retry >= policy.MaxRetries {
+			return nil, err
+		}
+
+		wait := policy.backoff(retry)
+		if hasRetryAfter && retryAfter > wait {
+			wait = retryAfter
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
 	}
+}
+
+// RateLimitedTransport is an http.RoundTripper that spaces out requests so that at most
+// a fixed number of requests per second are sent to a server.
+type RateLimitedTransport struct {
+	base     http.RoundTripper
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// NewRateLimitedTransport wraps base (or http.DefaultTransport if nil) with a rate limit
+func NewRateLimitedTransport(base http.RoundTripper, requestsPerSecond float64) *RateLimitedTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &RateLimitedTransport{
+		base:     base,
+		interval: time.Duration(float64(time.Second) / requestsPerSecond),
+	}
+}
+
+// reserve returns how long the caller has to wait before it may send a request
+func (t *RateLimitedTransport) reserve() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+
+	wait := t.next.Sub(now)
+	t.next = t.next.Add(t.interval)
+
+	return wait
+}
+
+func (t *RateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if wait := t.reserve(); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+
+	return t.base.RoundTrip(req)
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+// SetRateLimit limits the requests sent by the given client. A rate of 0 or less removes the limit.
+func SetRateLimit(client *http.Client, requestsPerSecond float64) {
+	base := client.Transport
+	if t, ok := base.(*RateLimitedTransport); ok {
+		base = t.base
 	}
 
-	if err := httpStatusToErr(resp.StatusCode); err != nil {
-		return nil, err
+	if requestsPerSecond <= 0 {
+		client.Transport = base
+		return
 	}
 
-	return resp, nil
+	client.Transport = NewRateLimitedTransport(base, requestsPerSecond)
 }
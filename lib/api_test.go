@@ -0,0 +1,182 @@
+package gelatin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testRetryPolicy = &RetryPolicy{
+	MaxRetries: 3,
+	MinBackoff: time.Millisecond,
+	MaxBackoff: 5 * time.Millisecond,
+}
+
+func TestHttpRequestRetry(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Transient", func(t *testing.T) {
+		var bodies []string
+		srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			data, _ := io.ReadAll(req.Body)
+			bodies = append(bodies, string(data))
+
+			if len(bodies) < 3 {
+				http.Error(resp, "restarting", http.StatusServiceUnavailable)
+				return
+			}
+			resp.WriteHeader(http.StatusNoContent)
+		}))
+		defer srv.Close()
+
+		_, err := HttpRequestWithRetry(WithIdempotentRequest(ctx), srv.Client(), testRetryPolicy, http.MethodPost, srv.URL, strings.NewReader(`{"a":1}`), nil)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+
+		if len(bodies) != 3 {
+			t.Fatalf("expected 3 attempts, got %d", len(bodies))
+		}
+		for _, body := range bodies {
+			if body != `{"a":1}` {
+				t.Errorf("body was not resent: %q", body)
+			}
+		}
+	})
+
+	t.Run("NotIdempotent", func(t *testing.T) {
+		attempts := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			attempts++
+			http.Error(resp, "restarting", http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+
+		// The server may have created the user before failing
+		_, err := HttpRequestWithRetry(ctx, srv.Client(), testRetryPolicy, http.MethodPost, srv.URL+"/Users/New", strings.NewReader(`{"Name":"bob"}`), nil)
+
+		var httpErr *HttpError
+		if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("expected a 503 HttpError, got: %v", err)
+		}
+		if attempts != 1 {
+			t.Errorf("expected 1 attempt, got %d", attempts)
+		}
+	})
+
+	t.Run("GiveUp", func(t *testing.T) {
+		attempts := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			attempts++
+			http.Error(resp, "busy", http.StatusTooManyRequests)
+		}))
+		defer srv.Close()
+
+		_, err := HttpRequestWithRetry(ctx, srv.Client(), testRetryPolicy, http.MethodGet, srv.URL+"/Items", nil, nil)
+
+		var httpErr *HttpError
+		if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusTooManyRequests {
+			t.Fatalf("expected a 429 HttpError, got: %v", err)
+		}
+		if attempts != testRetryPolicy.MaxRetries+1 {
+			t.Errorf("expected %d attempts, got %d", testRetryPolicy.MaxRetries+1, attempts)
+		}
+	})
+
+	t.Run("NotRetryable", func(t *testing.T) {
+		attempts := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			attempts++
+			http.Error(resp, "User not found", http.StatusNotFound)
+		}))
+		defer srv.Close()
+
+		_, err := HttpRequestWithRetry(ctx, srv.Client(), testRetryPolicy, http.MethodGet, srv.URL+"/Users/123?api_key=secret", nil, nil)
+
+		want := &HttpError{
+			StatusCode: http.StatusNotFound,
+			Method:     http.MethodGet,
+			Endpoint:   "/Users/123",
+			Body:       "User not found",
+		}
+
+		var httpErr *HttpError
+		if !errors.As(err, &httpErr) || *httpErr != *want {
+			t.Fatalf("want %v, got: %v", want, err)
+		}
+		if attempts != 1 {
+			t.Errorf("expected 1 attempt, got %d", attempts)
+		}
+	})
+
+	t.Run("ConnectionRefused", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		url := srv.URL
+		srv.Close()
+
+		// Requests that never reached the server are retried whatever their method
+		start := time.Now()
+		_, err := HttpRequestWithRetry(ctx, http.DefaultClient, testRetryPolicy, http.MethodPost, url, nil, nil)
+		if err == nil {
+			t.Fatal("expected request to a closed server to fail")
+		}
+		if time.Since(start) < 3*testRetryPolicy.MinBackoff/2 {
+			t.Errorf("expected request to be retried")
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			resp.Header().Set("Retry-After", "60")
+			http.Error(resp, "busy", http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+
+		_, err := HttpRequestWithRetry(ctx, srv.Client(), testRetryPolicy, http.MethodGet, srv.URL, nil, nil)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected the Retry-After wait to be cancelled, got: %v", err)
+		}
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"-1", 0, false},
+		{"Sat, 01 Jan 2022 00:00:30 GMT", 30 * time.Second, true},
+		{"Fri, 31 Dec 2021 23:59:00 GMT", 0, true},
+		{"soon", 0, false},
+	} {
+		got, ok := parseRetryAfter(tc.value, now)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; want %v, %v", tc.value, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := &RetryPolicy{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	for retry, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		got := policy.backoff(retry)
+		if got < max/2 || got > max {
+			t.Errorf("backoff(%d) = %v, want within [%v, %v]", retry, got, max/2, max)
+		}
+	}
+}
@@ -1,15 +1,69 @@
 package gelatin
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
+	"os"
 	"strings"
 
 	"github.com/google/go-cmp/cmp"
 )
 
+// GelatinPrunePolicy controls what happens to users that only exist in the "into" service
+type GelatinPrunePolicy string
+
+const (
+	// Never delete users from the into service (default)
+	GelatinPruneNever GelatinPrunePolicy = "never"
+
+	// Prompt before deleting each user, even if the client is not interactive. Only an
+	// explicit yes deletes the user.
+	GelatinPrunePrompt GelatinPrunePolicy = "prompt"
+
+	// Delete users without prompting (unless the client is interactive). Requires the into
+	// service to be accessed as a user, not with an API key.
+	GelatinPruneAlways GelatinPrunePolicy = "always"
+)
+
+// ParsePrunePolicy parses a prune policy string. An empty string maps to GelatinPruneNever.
+func ParsePrunePolicy(s string) (GelatinPrunePolicy, error) {
+	switch p := GelatinPrunePolicy(strings.ToLower(s)); p {
+	case "":
+		return GelatinPruneNever, nil
+	case GelatinPruneNever, GelatinPrunePrompt, GelatinPruneAlways:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid prune policy: %q", s)
+	}
+}
+
 type GelatinClientOpts struct {
 	Interactive bool
+
+	// Prune controls whether users that only exist in the into service are deleted
+	Prune GelatinPrunePolicy
+
+	// Passwords sets the password of created users. If nil, created users have no password.
+	Passwords GelatinPasswordStrategy
+
+	// Concurrency is the maximum number of library items processed in parallel, both when
+	// walking the from library and when updating user activity. Values below 2 disable
+	// concurrency. Interactive clients always apply operations one at a time.
+	Concurrency int
+
+	// Journal records each applied operation. Operations already recorded in the journal
+	// (by an earlier attempt of the same run) are skipped. May be nil.
+	Journal *GelatinJournal
+
+	// Matchers match library items across services, in order. If empty, DefaultMatchers
+	// is used.
+	Matchers []GelatinMatcher
+
+	// Report collects the items that could not be matched reliably while planning watch
+	// history and playlist migrations. May be nil.
+	Report *GelatinMatchReport
 }
 
 type GelatinClient struct {
@@ -34,360 +88,486 @@ func NewGelatinClient(from GelatinService, into GelatinService, opts *GelatinCli
 	}
 }
 
-func (c *GelatinClient) MigrateUsers(passwords map[string]string) error {
-	fromUsers, err := c.from.User().GetUsers(false)
+// SetJournal sets the journal that records the operations applied by the client.
+//
+// See GelatinClientOpts.Journal.
+func (c *GelatinClient) SetJournal(journal *GelatinJournal) {
+	c.opts.Journal = journal
+}
+
+// SetPasswords sets the password strategy for created users.
+//
+// See GelatinClientOpts.Passwords.
+func (c *GelatinClient) SetPasswords(passwords GelatinPasswordStrategy) {
+	c.opts.Passwords = passwords
+}
+
+// SetReport sets the report that collects unmatched and ambiguous items.
+//
+// See GelatinClientOpts.Report.
+func (c *GelatinClient) SetReport(report *GelatinMatchReport) {
+	c.opts.Report = report
+}
+
+// PlanMigrateUsers computes the operations needed to reconcile the users in the
+// "into" service with those in the "from" service.
+//
+// Users that exist in the from service but not in the into service are created,
+// along with their policy and configuration. Library folder IDs are translated
+// between the services by folder name.
+//
+// Users that only exist in the into service are deleted according to the client's
+// prune policy. Administrators and the user the into service is authenticated as
+// are never deleted. GelatinPruneAlways is refused if the into service is accessed
+// with an API key, since the key's user is not known.
+//
+// If the client has a journal, users that were created by an earlier attempt of the run
+// already exist in the into service, but their policy, configuration and password are
+// planned again; the parts that were applied are skipped.
+func (c *GelatinClient) PlanMigrateUsers(ctx context.Context) (*GelatinPlan, error) {
+	// API keys do not belong to a user, so the authenticated user could not be protected
+	if key := c.into.ApiKey(); c.opts.Prune == GelatinPruneAlways && (key == nil || key.UserId() == "") {
+		return nil, fmt.Errorf("prune policy %q requires logging in to the into service as a user instead of using an API key", GelatinPruneAlways)
+	}
+
+	fromUsers, err := c.from.User().GetUsers(ctx, false)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	intoUsers, err := c.into.User().GetUsers(false)
+	intoUsers, err := c.into.User().GetUsers(ctx, false)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	var createUsers []*GelatinUser
-	var deleteUsers []*GelatinUser
+	plan := &GelatinPlan{}
+
+	var folders *folderMapping
 
 	// Find users we need to create
-	for i, fromUser := range fromUsers {
-		exists := false
-		for _, intoUser := range intoUsers {
-			if fromUser.Name == intoUser.Name {
-				exists = true
-				break
-			}
+	for _, fromUser := range fromUsers {
+		create := GelatinOperation{
+			Type:     GelatinOperationCreateUser,
+			Username: fromUser.Name,
 		}
 
-		if !exists {
-			createUsers = append(createUsers, &fromUsers[i])
+		created := c.opts.Journal.Applied(&create)
+		if findUserByName(intoUsers, fromUser.Name) != nil && !created {
+			continue
 		}
-	}
 
-	// Find users we need to delete
-	for i, intoUser := range intoUsers {
-		exists := false
-		for _, fromUser := range fromUsers {
-			if intoUser.Name == fromUser.Name {
-				exists = true
-				break
+		if folders == nil {
+			folders, err = c.folderMapping(ctx)
+			if err != nil {
+				return nil, err
 			}
 		}
 
-		if !exists {
-			deleteUsers = append(deleteUsers, &intoUsers[i])
+		if !created {
+			plan.add(create)
 		}
-	}
 
-	for i, user := range createUsers {
-		if c.opts.Interactive {
-			promptUserYesNo("Create user: %s", user.Name)
-		}
-
-		newUser, err := c.into.User().CreateUser(user.Name)
-		if err != nil {
-			return err
+		policy, warnings := translateUserPolicy(&fromUser.Policy, folders)
+		plan.add(GelatinOperation{
+			Type:     GelatinOperationUpdateUserPolicy,
+			Username: fromUser.Name,
+			Policy:   policy,
+			Warnings: warnings,
+		})
+
+		config, warnings := translateUserConfig(&fromUser.Configuration, folders)
+		plan.add(GelatinOperation{
+			Type:     GelatinOperationUpdateUserConfig,
+			Username: fromUser.Name,
+			Config:   config,
+			Warnings: warnings,
+		})
+
+		if c.opts.Passwords != nil {
+			plan.add(GelatinOperation{
+				Type:             GelatinOperationSetUserPassword,
+				Username:         fromUser.Name,
+				PasswordStrategy: c.opts.Passwords.Name(),
+			})
+		} else if fromUser.HasPassword {
+			log.Printf("warning: %s: user will be created without a password", fromUser.Name)
 		}
+	}
 
-		log.Printf("created %s: %s", newUser.Name, newUser.Id)
-
-		createUsers[i] = newUser
+	if c.opts.Prune == GelatinPruneNever || c.opts.Prune == "" {
+		return plan, nil
 	}
 
-	// TODO: Migrate this to iterate over deleteUsers
-	for i, user := range createUsers {
-		if c.opts.Interactive {
-			promptUserYesNo("Delete user: %s", user.Name)
+	// Find users we need to delete
+	for i, intoUser := range intoUsers {
+		if findUserByName(fromUsers, intoUser.Name) != nil {
+			continue
 		}
 
-		err := c.into.User().DeleteUser(user.Id)
-		if err != nil {
-			return err
+		if reason := c.protectedUserReason(&intoUsers[i]); reason != "" {
+			log.Printf("not deleting %s: %s", intoUser.Name, reason)
+			continue
 		}
 
-		log.Printf("Deleted: %s", user.Name)
-
-		createUsers[i] = nil
+		plan.add(GelatinOperation{
+			Type:     GelatinOperationDeleteUser,
+			Username: intoUser.Name,
+			UserId:   intoUser.Id,
+		})
 	}
 
-	return nil
+	return plan, nil
 }
 
-// DiffUsers returns a diff of the list of users
-//
-// If full is true, the diff will include the full user struct.
-func (c *GelatinClient) DiffUsers(full bool) (string, error) {
-	fromUsers, err := c.from.User().GetUsers(false)
+// folderMapping builds a mapping of library folder IDs between the two services
+func (c *GelatinClient) folderMapping(ctx context.Context) (*folderMapping, error) {
+	fromFolders, err := c.from.Library().GetMediaFolders(ctx)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	intoUsers, err := c.into.User().GetUsers(false)
+	intoFolders, err := c.into.Library().GetMediaFolders(ctx)
 	if err != nil {
-		return "", err
-	}
-
-	if full {
-		return cmp.Diff(fromUsers, intoUsers), nil
+		return nil, err
 	}
 
-	var fromUsernames []string
-	var intoUsernames []string
+	return newFolderMapping(fromFolders, intoFolders), nil
+}
 
-	for _, user := range fromUsers {
-		fromUsernames = append(fromUsernames, user.Name)
+// protectedUserReason returns a non-empty reason if the given "into" user must never be deleted
+func (c *GelatinClient) protectedUserReason(user *GelatinUser) string {
+	if user.Policy.IsAdministrator {
+		return "user is an administrator"
 	}
 
-	for _, user := range intoUsers {
-		intoUsernames = append(intoUsernames, user.Name)
+	if key := c.into.ApiKey(); key != nil && key.UserId() != "" && key.UserId() == user.Id {
+		return "user is the currently authenticated user"
 	}
 
-	return cmp.Diff(fromUsernames, intoUsernames), nil
+	return ""
 }
 
-// getProviderIds returns a list of provider IDs for an item
-func getProviderIds(item *GelatinLibraryItem) []string {
-	var providerIds []string
-	if item.ImdbId != "" {
-		providerIds = append(providerIds, item.ImdbId)
-	}
-	if item.TmdbId != "" {
-		providerIds = append(providerIds, item.TmdbId)
-	}
-	if item.TvdbId != "" {
-		providerIds = append(providerIds, item.TvdbId)
+// MigrateUsers reconciles the users in the "into" service with those in the "from" service.
+//
+// If passwords is non-nil and the client has no password strategy, created users get their
+// password from the map. See PlanMigrateUsers for details.
+func (c *GelatinClient) MigrateUsers(ctx context.Context, passwords map[string]string) error {
+	if passwords != nil && c.opts.Passwords == nil {
+		c.opts.Passwords = NewMapPasswordStrategy(passwords)
+		defer func() { c.opts.Passwords = nil }()
 	}
 
-	// As a fallback, use the name of the item as a provider
-	if len(providerIds) == 0 {
-		providerIds = append(providerIds, item.Name)
+	plan, err := c.PlanMigrateUsers(ctx)
+	if err != nil {
+		return err
 	}
 
-	return providerIds
+	return c.Apply(ctx, plan)
 }
 
-// handleSeries recursively updates the playedItemData map for a given series.
+// Apply executes each operation in the plan against the "into" service, in order.
 //
-// It works like this:
+// If the client is interactive, the user is prompted before each operation.
+func (c *GelatinClient) Apply(ctx context.Context, plan *GelatinPlan) error {
+	_, err := c.apply(ctx, plan)
+	return err
+}
+
+// apply executes the plan and reports which operations were applied (i.e., not skipped
+// and successful)
 //
-// a. Get the series' provider ID
-// b. If the series is fully played, store an entry for the series itself and skip the remaining steps
-// c. Run a second query for the series' seasons (children)
-// d. For each season, check if it is fully played; if it is, store an entry and return
-// e. If the current season is not fully played, walk through each episode of the season and store an individual entry for the episode
-func handleSeries(svc GelatinLibraryService, item *GelatinLibraryItem, userId string, seriesProviderIds []string, playedItemData map[string]*GelatinLibraryItem) error {
-	switch item.Type {
-	case "Series":
-		if item.UserData.Played && item.UserData.PlayedPercentage == 100 {
-			// The series is fully played, so just add an entry for it
-			for _, id := range getProviderIds(item) {
-				playedItemData[id] = item
+// Consecutive user activity updates are independent of each other, so they are applied
+// in parallel (up to the client's concurrency). All other operations are applied in order.
+func (c *GelatinClient) apply(ctx context.Context, plan *GelatinPlan) ([]bool, error) {
+	applied := make([]bool, len(plan.Operations))
+
+	for start := 0; start < len(plan.Operations); {
+		end := start + 1
+		if plan.Operations[start].Type == GelatinOperationUpdateUserActivity {
+			for end < len(plan.Operations) && plan.Operations[end].Type == GelatinOperationUpdateUserActivity {
+				end++
 			}
-		} else {
-			seriesProviderIds := getProviderIds(item)
+		}
 
-			children, err := svc.GetItemsByUser(userId, map[string]string{
-				svc.GetItemFilterString(GelatinItemFilterParentId): item.Id,
-			})
-			if err != nil {
-				return err
-			}
+		if err := c.applyBatch(ctx, plan.Operations[start:end], applied[start:end]); err != nil {
+			return applied, err
+		}
 
-			// These could either be episodes or seasons
-			for i := range children {
-				handleSeries(svc, &children[i], userId, seriesProviderIds, playedItemData)
-			}
+		start = end
+	}
+
+	return applied, nil
+}
+
+// applyBatch applies a batch of independent operations, and marks those that were applied
+func (c *GelatinClient) applyBatch(ctx context.Context, ops []GelatinOperation, applied []bool) error {
+	concurrency := c.opts.Concurrency
+	if c.opts.Interactive {
+		concurrency = 1
+	}
+
+	skipped := make([]bool, len(ops))
+	errs := runPool(ctx, concurrency, len(ops), func(i int) error {
+		op := &ops[i]
+
+		if c.opts.Journal.Applied(op) {
+			// Applied by an earlier attempt of this run
+			skipped[i] = true
+			return nil
 		}
-	case "Season":
-		if item.UserData.Played && item.UserData.PlayedPercentage == 100 {
-			// The season is fully played, so just add an entry for it (for each provider ID)
-			for _, id := range seriesProviderIds {
-				seasonKey := fmt.Sprintf("%s-%d", id, item.IndexNumber)
-				playedItemData[seasonKey] = item
-			}
-		} else {
-			episodes, err := svc.GetItemsByUser(userId, map[string]string{
-				svc.GetItemFilterString(GelatinItemFilterParentId): item.Id,
-			})
-			if err != nil {
-				return err
-			}
 
-			for i := range episodes {
-				handleSeries(svc, &episodes[i], userId, seriesProviderIds, playedItemData)
-			}
+		prompt := c.opts.Interactive
+		if op.Type == GelatinOperationDeleteUser && c.opts.Prune == GelatinPrunePrompt {
+			prompt = true
 		}
-	case "Episode":
-		for _, id := range seriesProviderIds {
-			episodeKey := fmt.Sprintf("%s-%d-%d", id, item.ParentIndexNumber, item.IndexNumber)
-			playedItemData[episodeKey] = item
+
+		// Deleting a user cannot be undone, so it needs an explicit yes
+		if prompt && !promptUserYesNo(op.Type != GelatinOperationDeleteUser, "%s", op) {
+			// User skipped this operation
+			skipped[i] = true
+			return nil
+		}
+
+		for _, warning := range op.Warnings {
+			log.Printf("warning: %s: %s", op.Username, warning)
+		}
+
+		if err := c.applyOperation(ctx, op); err != nil {
+			return err
+		}
+
+		return c.opts.Journal.Record(op)
+	})
+
+	// Report in plan order
+	for i, err := range errs {
+		switch {
+		case err == errPoolStopped:
+		case err != nil:
+			log.Printf("failed: %s: %s", ops[i].describe(), err)
+		case !skipped[i]:
+			applied[i] = true
 		}
 	}
 
-	return nil
+	return firstError(errs)
 }
 
-func promptUserYesNo(message string, args ...interface{}) bool {
-	fmt.Printf("%s (y/n) [y]: ", fmt.Sprintf(message, args...))
-	var in string
-	fmt.Scanf("%s", &in)
-	return strings.ToLower(strings.TrimSpace(in)) != "n"
+// intoUserId returns the ID of the user an operation applies to in the "into" service
+func (c *GelatinClient) intoUserId(ctx context.Context, op *GelatinOperation) (string, error) {
+	if op.UserId != "" {
+		return op.UserId, nil
+	}
+
+	user, err := getUserByName(ctx, c.into, op.Username)
+	if err != nil {
+		return "", err
+	}
+
+	op.UserId = user.Id
+
+	return user.Id, nil
 }
 
-func promptUserForWatchItem(old, new *GelatinLibraryItem) bool {
-	var message string
+func (c *GelatinClient) applyOperation(ctx context.Context, op *GelatinOperation) error {
+	switch op.Type {
+	case GelatinOperationCreateUser:
+		newUser, err := c.into.User().CreateUser(ctx, op.Username)
+		if err != nil {
+			return err
+		}
 
-	oldPlayed, newPlayed := old.UserData.Played, new.UserData.Played
-	oldFavorite, newFavorite := old.UserData.IsFavorite, new.UserData.IsFavorite
-	oldTicks, newTicks := old.UserData.PlaybackPositionTicks, new.UserData.PlaybackPositionTicks
+		log.Printf("created %s: %s", newUser.Name, newUser.Id)
 
-	var played, favorite, ticks string
+		op.UserId = newUser.Id
+	case GelatinOperationDeleteUser:
+		// Plans can be loaded from disk, so double-check that we are not about to lock ourselves out
+		if key := c.into.ApiKey(); key != nil && key.UserId() != "" && key.UserId() == op.UserId {
+			return fmt.Errorf("refusing to delete the currently authenticated user %q", op.Username)
+		}
 
-	if oldPlayed != newPlayed {
-		played = fmt.Sprintf("%v->%v", oldPlayed, newPlayed)
-	} else {
-		played = fmt.Sprintf("%v", oldPlayed)
-	}
+		if err := c.into.User().DeleteUser(ctx, op.UserId); err != nil {
+			return err
+		}
 
-	if oldFavorite != newFavorite {
-		favorite = fmt.Sprintf("%v->%v", oldFavorite, newFavorite)
-	} else {
-		favorite = fmt.Sprintf("%v", oldFavorite)
-	}
+		log.Printf("deleted %s: %s", op.Username, op.UserId)
+	case GelatinOperationUpdateUserPolicy:
+		userId, err := c.intoUserId(ctx, op)
+		if err != nil {
+			return err
+		}
 
-	if oldTicks != newTicks {
-		ticks = fmt.Sprintf("%v->%v", oldTicks, newTicks)
-	} else {
-		ticks = fmt.Sprintf("%v", oldTicks)
-	}
+		user, err := c.into.User().GetUser(ctx, userId)
+		if err != nil {
+			return err
+		}
+
+		// Keep the into service's own auth providers
+		policy := *op.Policy
+		policy.AuthenticationProviderId = user.Policy.AuthenticationProviderId
+		policy.PasswordResetProviderId = user.Policy.PasswordResetProviderId
+
+		if err := c.into.User().UpdatePolicy(ctx, userId, &policy); err != nil {
+			return fmt.Errorf("failed to update policy for user %q: %w", op.Username, err)
+		}
+	case GelatinOperationUpdateUserConfig:
+		userId, err := c.intoUserId(ctx, op)
+		if err != nil {
+			return err
+		}
+
+		if err := c.into.User().UpdateConfiguration(ctx, userId, op.Config); err != nil {
+			return fmt.Errorf("failed to update configuration for user %q: %w", op.Username, err)
+		}
+	case GelatinOperationSetUserPassword:
+		if c.opts.Passwords == nil {
+			return fmt.Errorf("no password strategy configured for user %q", op.Username)
+		}
+
+		userId, err := c.intoUserId(ctx, op)
+		if err != nil {
+			return err
+		}
+
+		password, reset, err := c.opts.Passwords.Password(op.Username)
+		if err != nil {
+			return err
+		}
+
+		if err := c.into.User().UpdatePassword(ctx, userId, "", password, reset); err != nil {
+			return fmt.Errorf("failed to set password for user %q: %w", op.Username, err)
+		}
+
+		if r, ok := c.opts.Passwords.(passwordRecorder); ok && !reset {
+			if err := r.recordPassword(op.Username, password); err != nil {
+				return fmt.Errorf("password of user %q was set, but not recorded: %w", op.Username, err)
+			}
+		}
+	case GelatinOperationUpdateUserActivity:
+		svc := c.into
+		if op.Target == GelatinOperationTargetFrom {
+			svc = c.from
+		}
+
+		err := svc.Library().UpdateItemUserActivity(ctx, op.ItemId, op.UserId, op.Old, op.New)
+		if err != nil {
+			return fmt.Errorf("failed to set user data for item %q: %v", op.ItemName, err)
+		}
+	case GelatinOperationCreatePlaylist:
+		userId, err := c.intoUserId(ctx, op)
+		if err != nil {
+			return err
+		}
+
+		id, err := c.into.Playlist().CreatePlaylist(ctx, userId, op.PlaylistName, op.PlaylistMediaType, op.PlaylistItemIds)
+		if err != nil {
+			return fmt.Errorf("failed to create playlist %q: %w", op.PlaylistName, err)
+		}
+
+		log.Printf("created playlist %q for %s: %s", op.PlaylistName, op.Username, id)
+
+		op.PlaylistId = id
+	case GelatinOperationUpdatePlaylist:
+		userId, err := c.intoUserId(ctx, op)
+		if err != nil {
+			return err
+		}
+
+		if err := setPlaylistItems(ctx, c.into.Playlist(), op.PlaylistId, userId, op.PlaylistItemIds); err != nil {
+			return fmt.Errorf("failed to update playlist %q: %w", op.PlaylistName, err)
+		}
+	case GelatinOperationCreateCollection:
+		id, err := c.into.Collection().CreateCollection(ctx, op.CollectionName, op.CollectionItemIds)
+		if err != nil {
+			return fmt.Errorf("failed to create collection %q: %w", op.CollectionName, err)
+		}
 
-	switch old.Type {
-	case "Movie":
-		message = fmt.Sprintf("Movie: %q, Played: %s, Favorite: %s, Ticks: %s", old.Name, played, favorite, ticks)
-	case "Series":
-		message = fmt.Sprintf("Series: %q, Played: %s, Favorite: %s", old.Name, played, favorite)
-	case "Season":
-		message = fmt.Sprintf("Season: %q (%q), Played: %s, Favorite: %s", old.Name, old.SeriesName, played, favorite)
-	case "Episode":
-		message = fmt.Sprintf("Episode: %q (%q), Played: %s, Favorite: %s, Ticks: %s", old.Name, old.SeriesName, played, favorite, ticks)
+		log.Printf("created collection %q: %s", op.CollectionName, id)
+
+		op.CollectionId = id
+	case GelatinOperationUpdateCollection:
+		if err := updateCollectionItems(ctx, c.into.Collection(), op.CollectionId, op.OldCollectionItemIds, op.CollectionItemIds); err != nil {
+			return fmt.Errorf("failed to update collection %q: %w", op.CollectionName, err)
+		}
 	default:
-		panic(fmt.Sprintf("unexpected item type: %s for %v", old.Type, old))
+		return fmt.Errorf("unknown operation: %s", op.Type)
 	}
 
-	return promptUserYesNo(message)
+	return nil
 }
 
-// MigrateUserWatchHistory migrates a user's watch history from one service to another.
-//
-// If the user does not exist in either service, this method returns an error.
-//
-// Migration works like this:
+// DiffUsers returns a diff of the list of users
 //
-// 1. Fetch all movies and series from the "from" service.
-// 2. For each movie and series/episode, store an entry containing the user activity using the provider ID (IMDb, TMDB, TVDB)
-// 3. Fetch all items from the into service and compare the user activity state with that of the from service
-// 4. If there is a difference, update the into service with the latest state
-func (c *GelatinClient) MigrateUserWatchHistory(username string) error {
-	fromUser, err := getUserByName(c.from, username)
+// If full is true, the diff will include the full user struct.
+func (c *GelatinClient) DiffUsers(ctx context.Context, full bool) (string, error) {
+	fromUsers, err := c.from.User().GetUsers(ctx, false)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	intoUser, err := getUserByName(c.into, username)
+	intoUsers, err := c.into.User().GetUsers(ctx, false)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	// Get all items for the user in the from service
-	fromLibraryItems, err := c.from.Library().GetItemsByUser(fromUser.Id, nil)
-	if err != nil {
-		return err
+	if full {
+		return cmp.Diff(fromUsers, intoUsers), nil
 	}
 
-	// Build a map of user data for the items played in the from service
-	playedItemData := make(map[string]*GelatinLibraryItem)
-	for i, item := range fromLibraryItems {
-		switch item.Type {
-		case "Movie":
-			for _, id := range getProviderIds(&item) {
-				playedItemData[id] = &fromLibraryItems[i]
-			}
-		case "Series":
-			// Recursively handle this series
-			err := handleSeries(c.from.Library(), &fromLibraryItems[i], fromUser.Id, nil, playedItemData)
-			if err != nil {
-				return err
-			}
-		}
-	}
+	var fromUsernames []string
+	var intoUsernames []string
 
-	// Get all library items tracked by the into service
-	intoLibraryItems, err := c.into.Library().GetItemsByUser(intoUser.Id, nil)
-	if err != nil {
-		return err
+	for _, user := range fromUsers {
+		fromUsernames = append(fromUsernames, user.Name)
 	}
 
-	// Do a first pass to build a mapping from series ID to known provider IDs
-	seriesIdToProviderIds := make(map[string][]string)
-	for _, item := range intoLibraryItems {
-		if item.Type == "Series" {
-			seriesIdToProviderIds[item.Id] = getProviderIds(&item)
-		}
+	for _, user := range intoUsers {
+		intoUsernames = append(intoUsernames, user.Name)
 	}
 
-	// Finally, run through the list of items once more and update user watch state if it differs
-	for _, item := range intoLibraryItems {
-		var fromItem *GelatinLibraryItem
-
-		switch item.Type {
-		case "Movie", "Series":
-			keys := []string{item.ImdbId, item.TmdbId, item.TvdbId}
-			for _, key := range keys {
-				if data, ok := playedItemData[key]; ok {
-					fromItem = data
-					break
-				}
-			}
-		case "Season":
-			providerIds := seriesIdToProviderIds[item.SeriesId]
-			for _, id := range providerIds {
-				key := fmt.Sprintf("%s-%d", id, item.IndexNumber)
-				if data, ok := playedItemData[key]; ok {
-					fromItem = data
-					break
-				}
-			}
-		case "Episode":
-			providerIds := seriesIdToProviderIds[item.SeriesId]
-			for _, id := range providerIds {
-				key := fmt.Sprintf("%s-%d-%d", id, item.ParentIndexNumber, item.IndexNumber)
-				if data, ok := playedItemData[key]; ok {
-					fromItem = data
-					break
-				}
-			}
-		}
+	return cmp.Diff(fromUsernames, intoUsernames), nil
+}
 
-		if fromItem == nil {
-			// This item is not present in the from service, so we can skip it
-			continue
-		}
+// promptUserYesNo asks a yes/no question on stdin. An empty answer picks def; any other answer
+// than yes or no, or a failure to read one, is taken as a no.
+func promptUserYesNo(def bool, message string, args ...interface{}) bool {
+	choice := "y"
+	if !def {
+		choice = "n"
+	}
+	fmt.Printf("%s (y/n) [%s]: ", fmt.Sprintf(message, args...), choice)
 
-		needUpdate := !item.UserData.IsMatch(fromItem.UserData)
+	in, err := readLine(os.Stdin)
+	if err != nil && in == "" {
+		fmt.Println()
+		return false
+	}
 
-		if needUpdate {
-			if c.opts.Interactive {
-				if !promptUserForWatchItem(&item, fromItem) {
-					// User skipped this item
-					continue
-				}
-			}
+	switch strings.ToLower(strings.TrimSpace(in)) {
+	case "":
+		return def
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
 
-			err := c.into.Library().UpdateItemUserActivity(item.Id, intoUser.Id, item.UserData, fromItem.UserData)
-			if err != nil {
-				return fmt.Errorf("failed to set user data for item %q: %v", item.Name, err)
+// readLine reads a line from r, without the newline. r is read a byte at a time, so that the
+// answers to later prompts are not consumed.
+func readLine(r io.Reader) (string, error) {
+	var sb strings.Builder
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				return sb.String(), nil
 			}
+			sb.WriteByte(b[0])
+		}
+		if err != nil {
+			return sb.String(), err
 		}
 	}
-
-	return nil
 }
@@ -0,0 +1,237 @@
+package gelatin_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sort"
+	"testing"
+
+	gelatin "github.com/aksiksi/gelatin/lib"
+	"github.com/google/go-cmp/cmp"
+)
+
+// withStdin answers the prompts of a test with the given input
+func withStdin(t *testing.T, input string) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %s", err)
+	}
+	w.WriteString(input)
+	w.Close()
+
+	stdin := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		r.Close()
+	})
+}
+
+// usernames returns the sorted names of the users of a fake server
+func usernames(s *fakeServer) []string {
+	var names []string
+	for _, user := range s.users {
+		names = append(names, user.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestMigrateUsersPrune(t *testing.T) {
+	tests := []struct {
+		name  string
+		prune gelatin.GelatinPrunePolicy
+		input string
+		want  []string
+	}{
+		{
+			name:  "never",
+			prune: gelatin.GelatinPruneNever,
+			want:  []string{"alice", "bob", "carol", "gelatin", "root"},
+		},
+		{
+			name:  "prompt, confirmed",
+			prune: gelatin.GelatinPrunePrompt,
+			input: "y\n",
+			want:  []string{"alice", "bob", "gelatin", "root"},
+		},
+		{
+			name:  "prompt, confirmed with yes",
+			prune: gelatin.GelatinPrunePrompt,
+			input: "Yes\n",
+			want:  []string{"alice", "bob", "gelatin", "root"},
+		},
+		{
+			name:  "prompt, declined",
+			prune: gelatin.GelatinPrunePrompt,
+			input: "n\n",
+			want:  []string{"alice", "bob", "carol", "gelatin", "root"},
+		},
+		{
+			// Users are kept unless deleting them is confirmed
+			name:  "prompt, default",
+			prune: gelatin.GelatinPrunePrompt,
+			input: "\n",
+			want:  []string{"alice", "bob", "carol", "gelatin", "root"},
+		},
+		{
+			name:  "prompt, unknown answer",
+			prune: gelatin.GelatinPrunePrompt,
+			input: "sure\n",
+			want:  []string{"alice", "bob", "carol", "gelatin", "root"},
+		},
+		{
+			name:  "prompt, no answer",
+			prune: gelatin.GelatinPrunePrompt,
+			want:  []string{"alice", "bob", "carol", "gelatin", "root"},
+		},
+		{
+			// Users created by the run are not deleted again
+			name:  "always",
+			prune: gelatin.GelatinPruneAlways,
+			want:  []string{"alice", "bob", "gelatin", "root"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			from, fromClient := newFakeServer(t, nil)
+			from.users = append(from.users, gelatin.GelatinUser{Name: "bob", Id: "bob-id"})
+
+			// root and gelatin (the user the client is authenticated as) are protected
+			into, intoClient := newFakeServer(t, nil)
+			into.users = append(into.users,
+				gelatin.GelatinUser{Name: "carol", Id: "carol-id"},
+				gelatin.GelatinUser{Name: "root", Id: "root-id", Policy: gelatin.GelatinUserPolicy{IsAdministrator: true}},
+				gelatin.GelatinUser{Name: "gelatin", Id: "gelatin-id"},
+			)
+
+			ctx := context.Background()
+			key, err := intoClient.Authenticate(ctx, "gelatin", "")
+			if err != nil {
+				t.Fatalf("failed to authenticate: %s", err)
+			}
+			intoClient.SetApiKey(key)
+
+			withStdin(t, test.input)
+
+			client := gelatin.NewGelatinClient(fromClient, intoClient, &gelatin.GelatinClientOpts{Prune: test.prune})
+			if err := client.MigrateUsers(ctx, nil); err != nil {
+				t.Fatalf("failed to migrate users: %s", err)
+			}
+
+			if diff := cmp.Diff(test.want, usernames(into)); diff != "" {
+				t.Errorf("-want,+got: %s", diff)
+			}
+		})
+	}
+}
+
+func TestMigrateUsersPruneWithApiKey(t *testing.T) {
+	_, fromClient := newFakeServer(t, nil)
+	into, intoClient := newFakeServer(t, nil)
+	into.users = append(into.users, gelatin.GelatinUser{Name: "carol", Id: "carol-id"})
+
+	// The user of an API key is not known, so it could not be protected from deletion
+	client := gelatin.NewGelatinClient(fromClient, intoClient, &gelatin.GelatinClientOpts{Prune: gelatin.GelatinPruneAlways})
+	if err := client.MigrateUsers(context.Background(), nil); err == nil {
+		t.Errorf("expected pruning with an API key to be refused")
+	}
+	if len(into.users) != 2 {
+		t.Errorf("expected no user to be deleted, got: %+v", into.users)
+	}
+}
+
+func TestDeleteProtectedUser(t *testing.T) {
+	_, fromClient := newFakeServer(t, nil)
+	into, intoClient := newFakeServer(t, nil)
+	into.users = append(into.users, gelatin.GelatinUser{Name: "gelatin", Id: "gelatin-id"})
+
+	ctx := context.Background()
+	key, err := intoClient.Authenticate(ctx, "gelatin", "")
+	if err != nil {
+		t.Fatalf("failed to authenticate: %s", err)
+	}
+	intoClient.SetApiKey(key)
+
+	// A plan loaded from disk can name any user
+	plan := &gelatin.GelatinPlan{Operations: []gelatin.GelatinOperation{
+		{Type: gelatin.GelatinOperationDeleteUser, Username: "gelatin", UserId: "gelatin-id"},
+	}}
+
+	client := gelatin.NewGelatinClient(fromClient, intoClient, &gelatin.GelatinClientOpts{Prune: gelatin.GelatinPruneAlways})
+	if err := client.Apply(ctx, plan); err == nil {
+		t.Errorf("expected deleting the authenticated user to fail")
+	}
+	if into.user("gelatin") == nil {
+		t.Errorf("expected the authenticated user to be kept")
+	}
+}
+
+func TestMigrateUsersResume(t *testing.T) {
+	from, fromClient := newFakeServer(t, nil)
+	from.users = append(from.users, gelatin.GelatinUser{
+		Name:        "bob",
+		Id:          "bob-id",
+		HasPassword: true,
+		Policy:      gelatin.GelatinUserPolicy{IsHidden: true, EnableAllFolders: true},
+	})
+
+	into, intoClient := newFakeServer(t, nil)
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	passwords := gelatin.NewMapPasswordStrategy(map[string]string{"bob": "hunter2"})
+
+	journal, err := gelatin.CreateJournal(dir)
+	if err != nil {
+		t.Fatalf("failed to create journal: %s", err)
+	}
+
+	// The run is interrupted right after bob is created
+	into.fail["POST /Users/user-1/Policy"] = http.StatusInternalServerError
+	client := gelatin.NewGelatinClient(fromClient, intoClient, &gelatin.GelatinClientOpts{Passwords: passwords, Journal: journal})
+	if err := client.MigrateUsers(ctx, nil); err == nil {
+		t.Fatalf("expected the migration to fail")
+	}
+	journal.Close()
+
+	if bob := into.user("bob"); bob == nil || bob.Policy.IsHidden || bob.HasPassword {
+		t.Fatalf("expected bob to be created without a policy or password, got: %+v", bob)
+	}
+
+	delete(into.fail, "POST /Users/user-1/Policy")
+	if journal, err = gelatin.OpenJournal(dir, journal.RunId()); err != nil {
+		t.Fatalf("failed to open journal: %s", err)
+	}
+	defer journal.Close()
+
+	client = gelatin.NewGelatinClient(fromClient, intoClient, &gelatin.GelatinClientOpts{Passwords: passwords, Journal: journal})
+	plan, err := client.PlanMigrateUsers(ctx)
+	if err != nil {
+		t.Fatalf("failed to plan user migration: %s", err)
+	}
+	for _, op := range plan.Operations {
+		if op.Type == gelatin.GelatinOperationCreateUser {
+			t.Errorf("expected bob not to be created again, got: %v", plan)
+		}
+	}
+
+	if err := client.Apply(ctx, plan); err != nil {
+		t.Fatalf("failed to apply plan: %s", err)
+	}
+
+	bob := into.user("bob")
+	if bob == nil || !bob.Policy.IsHidden || !bob.Policy.EnableAllFolders {
+		t.Errorf("expected the policy of bob to be migrated, got: %+v", bob)
+	}
+	if into.passwords[bob.Id] != "hunter2" {
+		t.Errorf("expected the password of bob to be set")
+	}
+	if len(into.users) != 2 {
+		t.Errorf("expected 2 users, got: %+v", into.users)
+	}
+}
@@ -0,0 +1,184 @@
+package gelatin
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// MigrateCollections migrates the collections of one service to another.
+//
+// See PlanMigrateCollections for details.
+func (c *GelatinClient) MigrateCollections(ctx context.Context) error {
+	plan, err := c.PlanMigrateCollections(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Apply(ctx, plan)
+}
+
+// PlanMigrateCollections computes the operations needed to recreate the collections (box
+// sets) of the from service in the into service.
+//
+// Every member of a collection is matched against the library of the into service (see
+// GelatinMatcher). Members without a match are skipped, with a warning on the operation.
+//
+// Collections are identified by name, so that migrations can be re-run: members that are
+// missing from an existing collection are added. Members that only the into collection has
+// are kept, since servers also create collections from metadata.
+func (c *GelatinClient) PlanMigrateCollections(ctx context.Context) (*GelatinPlan, error) {
+	plan := &GelatinPlan{}
+
+	collections, err := c.from.Collection().GetCollections(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(collections) == 0 {
+		return plan, nil
+	}
+
+	// Only items of the types found in the collections need to be matched against
+	members := make([][]GelatinLibraryItem, len(collections))
+	types := make(map[string]bool)
+	for i := range collections {
+		if members[i], err = c.from.Collection().GetCollectionItems(ctx, collections[i].Id); err != nil {
+			return nil, fmt.Errorf("failed to get items of collection %q: %w", collections[i].Name, err)
+		}
+		for j := range members[i] {
+			types[members[i][j].Type] = true
+		}
+	}
+
+	fromIndex, intoIndex, err := c.libraryIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	intoItems := newMatchIndex(c.opts.Matchers, intoIndex)
+	if len(types) > 0 {
+		if err := addItems(ctx, c.into.Library(), "", types, intoItems); err != nil {
+			return nil, err
+		}
+	}
+
+	intoCollections, err := c.into.Collection().GetCollections(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]*GelatinLibraryItem)
+	for i := range intoCollections {
+		if _, ok := existing[intoCollections[i].Name]; !ok {
+			existing[intoCollections[i].Name] = &intoCollections[i]
+		}
+	}
+
+	for i := range collections {
+		collection := &collections[i]
+
+		op := GelatinOperation{CollectionName: collection.Name}
+
+		var itemIds []string
+		matched := make(map[string]bool)
+		for j := range members[i] {
+			member := &members[i][j]
+
+			match := intoItems.match(member, fromIndex)
+			if match == nil {
+				c.opts.Report.addUnmatched("", member)
+				op.Warnings = append(op.Warnings, fmt.Sprintf("skipped member: no match for %s %q", member.Type, member.Name))
+				continue
+			}
+			c.opts.Report.addMatch("", "from", member, match)
+			if match.Ambiguous() {
+				op.Warnings = append(op.Warnings, fmt.Sprintf("skipped member: ambiguous match for %s %q", member.Type, member.Name))
+				continue
+			}
+
+			if !matched[match.Item.Id] {
+				matched[match.Item.Id] = true
+				itemIds = append(itemIds, match.Item.Id)
+			}
+		}
+
+		intoCollection, ok := existing[collection.Name]
+		if !ok {
+			if len(itemIds) == 0 && len(members[i]) > 0 {
+				log.Printf("skipping collection %q: none of its members could be matched", collection.Name)
+				continue
+			}
+
+			op.Type = GelatinOperationCreateCollection
+			op.CollectionItemIds = itemIds
+			plan.add(op)
+			continue
+		}
+
+		current, err := c.into.Collection().GetCollectionItems(ctx, intoCollection.Id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get items of collection %q: %w", intoCollection.Name, err)
+		}
+
+		var currentIds []string
+		for j := range current {
+			currentIds = append(currentIds, current[j].Id)
+			delete(matched, current[j].Id)
+		}
+		if len(matched) == 0 {
+			continue
+		}
+
+		op.Type = GelatinOperationUpdateCollection
+		op.CollectionId = intoCollection.Id
+		op.OldCollectionItemIds = currentIds
+		op.CollectionItemIds = append([]string(nil), currentIds...)
+		for _, id := range itemIds {
+			if matched[id] {
+				op.CollectionItemIds = append(op.CollectionItemIds, id)
+			}
+		}
+		plan.add(op)
+	}
+
+	return plan, nil
+}
+
+// updateCollectionItems adds and removes the members of a collection that differ between
+// its old and new items
+func updateCollectionItems(ctx context.Context, svc GelatinCollectionService, collectionId string, oldIds, newIds []string) error {
+	old := make(map[string]bool)
+	for _, id := range oldIds {
+		old[id] = true
+	}
+
+	var add []string
+	for _, id := range newIds {
+		if old[id] {
+			delete(old, id)
+			continue
+		}
+		add = append(add, id)
+	}
+
+	var remove []string
+	for _, id := range oldIds {
+		if old[id] {
+			remove = append(remove, id)
+		}
+	}
+
+	if len(add) > 0 {
+		if err := svc.AddCollectionItems(ctx, collectionId, add); err != nil {
+			return err
+		}
+	}
+
+	if len(remove) > 0 {
+		if err := svc.RemoveCollectionItems(ctx, collectionId, remove); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
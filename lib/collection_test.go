@@ -0,0 +1,85 @@
+package gelatin_test
+
+import (
+	"context"
+	"testing"
+
+	gelatin "github.com/aksiksi/gelatin/lib"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMigrateCollections(t *testing.T) {
+	from, fromClient := newFakeServer(t, []gelatin.GelatinLibraryItem{
+		{Id: "iron-man", Name: "Iron Man", Type: "Movie", ProviderIds: map[string]string{"Tmdb": "1726"}},
+		{Id: "thor", Name: "Thor", Type: "Movie", ProviderIds: map[string]string{"Tmdb": "10195"}},
+		{Id: "loki", Name: "Loki", Type: "Series", ProviderIds: map[string]string{"Tvdb": "362472"}},
+		{Id: "howard", Name: "Howard the Duck", Type: "Movie", ProviderIds: map[string]string{"Tmdb": "10658"}},
+	})
+	from.collections["mcu"] = &fakeList{name: "Marvel Cinematic Universe"}
+	for _, id := range []string{"iron-man", "thor", "loki", "howard"} {
+		from.add(from.collections["mcu"], id)
+	}
+
+	into, intoClient := newFakeServer(t, []gelatin.GelatinLibraryItem{
+		{Id: "into-thor", Name: "Thor", Type: "Movie", ProviderIds: map[string]string{"Tmdb": "10195"}},
+		{Id: "into-loki", Name: "Loki", Type: "Series", ProviderIds: map[string]string{"Tvdb": "362472"}},
+		{Id: "into-iron-man", Name: "Iron Man", Type: "Movie", ProviderIds: map[string]string{"Tmdb": "1726"}},
+		{Id: "into-eternals", Name: "Eternals", Type: "Movie", ProviderIds: map[string]string{"Tmdb": "524434"}},
+	})
+
+	ctx := context.Background()
+	client := gelatin.NewGelatinClient(fromClient, intoClient, nil)
+
+	plan, err := client.PlanMigrateCollections(ctx)
+	if err != nil {
+		t.Fatalf("failed to plan collection migration: %s", err)
+	}
+
+	want := []gelatin.GelatinOperation{{
+		Type:              gelatin.GelatinOperationCreateCollection,
+		CollectionName:    "Marvel Cinematic Universe",
+		CollectionItemIds: []string{"into-iron-man", "into-thor", "into-loki"},
+		Warnings:          []string{`skipped member: no match for Movie "Howard the Duck"`},
+	}}
+	if diff := cmp.Diff(want, plan.Operations); diff != "" {
+		t.Fatalf("-want,+got: %s", diff)
+	}
+
+	if err := client.Apply(ctx, plan); err != nil {
+		t.Fatalf("failed to apply plan: %s", err)
+	}
+	if diff := cmp.Diff(want[0].CollectionItemIds, into.itemIds(into.collections, "Marvel Cinematic Universe")); diff != "" {
+		t.Errorf("-want,+got: %s", diff)
+	}
+
+	// Re-running is a no-op
+	if plan, err = client.PlanMigrateCollections(ctx); err != nil || !plan.Empty() {
+		t.Fatalf("expected an empty plan, got: %v, %v", plan, err)
+	}
+
+	// Missing members are added, and members only the into collection has are kept
+	for _, collection := range into.collections {
+		collection.entries = collection.entries[:0]
+		for _, id := range []string{"into-eternals", "into-thor"} {
+			into.add(collection, id)
+		}
+	}
+
+	if plan, err = client.PlanMigrateCollections(ctx); err != nil {
+		t.Fatalf("failed to plan collection migration: %s", err)
+	}
+	if len(plan.Operations) != 1 || plan.Operations[0].Type != gelatin.GelatinOperationUpdateCollection {
+		t.Fatalf("expected a collection update, got: %v", plan)
+	}
+	if err := client.Apply(ctx, plan); err != nil {
+		t.Fatalf("failed to apply plan: %s", err)
+	}
+
+	wantIds := []string{"into-eternals", "into-thor", "into-iron-man", "into-loki"}
+	if diff := cmp.Diff(wantIds, into.itemIds(into.collections, "Marvel Cinematic Universe")); diff != "" {
+		t.Errorf("-want,+got: %s", diff)
+	}
+	if len(into.collections) != 1 {
+		t.Errorf("expected a single collection, got %d", len(into.collections))
+	}
+}
@@ -0,0 +1,387 @@
+package gelatin_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aksiksi/gelatin/jellyfin"
+	gelatin "github.com/aksiksi/gelatin/lib"
+)
+
+// fakeServer is a minimal Jellyfin server with users, a library (with the user data of each
+// user), playlists and collections
+type fakeServer struct {
+	users       []gelatin.GelatinUser
+	items       []gelatin.GelatinLibraryItem
+	userData    map[string]map[string]*gelatin.GelatinLibraryItemUserActivity
+	playlists   map[string]*fakeList
+	collections map[string]*fakeList
+	passwords   map[string]string
+
+	// Requests ("METHOD /path") that fail with the given status code
+	fail map[string]int
+
+	nextId int
+	mu     sync.Mutex
+}
+
+// fakeList is a playlist or a collection
+type fakeList struct {
+	name    string
+	entries []gelatin.GelatinLibraryItem
+}
+
+func newFakeServer(t *testing.T, items []gelatin.GelatinLibraryItem) (*fakeServer, *jellyfin.JellyfinApiClient) {
+	t.Helper()
+
+	s := &fakeServer{
+		users:       []gelatin.GelatinUser{{Name: "alice", Id: "alice-id"}},
+		items:       items,
+		userData:    make(map[string]map[string]*gelatin.GelatinLibraryItemUserActivity),
+		playlists:   make(map[string]*fakeList),
+		collections: make(map[string]*fakeList),
+		passwords:   make(map[string]string),
+		fail:        make(map[string]int),
+	}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	return s, jellyfin.NewJellyfinApiClient(srv.URL, jellyfin.NewApiKey("test123"))
+}
+
+func (s *fakeServer) item(id string) gelatin.GelatinLibraryItem {
+	for _, item := range s.items {
+		if item.Id == id {
+			return item
+		}
+	}
+	panic("unknown item " + id)
+}
+
+// data returns the user data of an item for a user, which is created if needed
+func (s *fakeServer) data(userId, itemId string) *gelatin.GelatinLibraryItemUserActivity {
+	if s.userData[userId] == nil {
+		s.userData[userId] = make(map[string]*gelatin.GelatinLibraryItemUserActivity)
+	}
+	if s.userData[userId][itemId] == nil {
+		s.userData[userId][itemId] = &gelatin.GelatinLibraryItemUserActivity{}
+	}
+	return s.userData[userId][itemId]
+}
+
+// user returns the user with the given name, or nil
+func (s *fakeServer) user(name string) *gelatin.GelatinUser {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.users {
+		if s.users[i].Name == name {
+			user := s.users[i]
+			return &user
+		}
+	}
+	return nil
+}
+
+// userById returns a pointer to the stored user with the given ID, or nil
+func (s *fakeServer) userById(id string) *gelatin.GelatinUser {
+	for i := range s.users {
+		if s.users[i].Id == id {
+			return &s.users[i]
+		}
+	}
+	return nil
+}
+
+// setUserData sets the user data of an item for a user
+func (s *fakeServer) setUserData(userId, itemId string, data gelatin.GelatinLibraryItemUserActivity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	*s.data(userId, itemId) = data
+}
+
+// getUserData returns the user data of an item for a user
+func (s *fakeServer) getUserData(userId, itemId string) gelatin.GelatinLibraryItemUserActivity {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if data := s.userData[userId][itemId]; data != nil {
+		return *data
+	}
+	return gelatin.GelatinLibraryItemUserActivity{}
+}
+
+// withUserData returns copies of items with the user data of a user attached
+func (s *fakeServer) withUserData(items []gelatin.GelatinLibraryItem, userId string) []gelatin.GelatinLibraryItem {
+	result := make([]gelatin.GelatinLibraryItem, len(items))
+	for i, item := range items {
+		data := &gelatin.GelatinLibraryItemUserActivity{}
+		if d := s.userData[userId][item.Id]; d != nil {
+			*data = *d
+		}
+		item.UserData = data
+		result[i] = item
+	}
+	return result
+}
+
+// add adds an entry for an item
+func (s *fakeServer) add(list *fakeList, itemId string) {
+	s.nextId++
+	entry := s.item(itemId)
+	entry.PlaylistItemId = strconv.Itoa(s.nextId)
+	list.entries = append(list.entries, entry)
+}
+
+// itemIds returns the IDs of the items of the named playlist or collection, in order
+func (s *fakeServer) itemIds(lists map[string]*fakeList, name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []string
+	for _, list := range lists {
+		if list.name == name {
+			for _, entry := range list.entries {
+				ids = append(ids, entry.Id)
+			}
+		}
+	}
+	return ids
+}
+
+func (s *fakeServer) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := req.URL.Query()
+	path := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+
+	if status, ok := s.fail[req.Method+" "+req.URL.Path]; ok {
+		resp.WriteHeader(status)
+		return
+	}
+
+	var items []gelatin.GelatinLibraryItem
+	switch {
+	case req.URL.Path == "/users":
+		json.NewEncoder(resp).Encode(s.users)
+		return
+	case req.Method == http.MethodPost && req.URL.Path == "/users/new":
+		var create struct{ Name string }
+		json.NewDecoder(req.Body).Decode(&create)
+
+		s.nextId++
+		s.users = append(s.users, gelatin.GelatinUser{Name: create.Name, Id: fmt.Sprintf("user-%d", s.nextId)})
+		json.NewEncoder(resp).Encode(s.users[len(s.users)-1])
+		return
+	case req.Method == http.MethodPost && req.URL.Path == "/Users/AuthenticateByName":
+		var auth struct{ Username string }
+		json.NewDecoder(req.Body).Decode(&auth)
+
+		for _, user := range s.users {
+			if user.Name == auth.Username {
+				json.NewEncoder(resp).Encode(&jellyfin.JellyfinUserAuthResponse{AccessToken: "token-" + user.Id, User: user})
+				return
+			}
+		}
+		resp.WriteHeader(http.StatusUnauthorized)
+		return
+	case len(path) == 2 && path[0] == "users":
+		user := s.userById(path[1])
+		if user == nil {
+			http.NotFound(resp, req)
+			return
+		}
+
+		if req.Method == http.MethodDelete {
+			var kept []gelatin.GelatinUser
+			for _, u := range s.users {
+				if u.Id != path[1] {
+					kept = append(kept, u)
+				}
+			}
+			s.users = kept
+			resp.WriteHeader(http.StatusNoContent)
+			return
+		}
+		json.NewEncoder(resp).Encode(user)
+		return
+	case len(path) == 3 && path[0] == "Users" && (path[2] == "Policy" || path[2] == "Configuration" || path[2] == "Password"):
+		user := s.userById(path[1])
+		if user == nil {
+			http.NotFound(resp, req)
+			return
+		}
+
+		switch path[2] {
+		case "Policy":
+			json.NewDecoder(req.Body).Decode(&user.Policy)
+		case "Configuration":
+			json.NewDecoder(req.Body).Decode(&user.Configuration)
+		case "Password":
+			var password struct {
+				NewPw string
+				Reset bool
+			}
+			json.NewDecoder(req.Body).Decode(&password)
+			s.passwords[user.Id] = password.NewPw
+			user.HasPassword = password.NewPw != ""
+		}
+
+		resp.WriteHeader(http.StatusNoContent)
+		return
+	case req.URL.Path == "/Library/MediaFolders":
+		// No library folders
+	case req.Method == http.MethodPost && len(path) == 3 && path[0] == "UserItems" && path[2] == "UserData":
+		// Fields that are not sent are left alone
+		json.NewDecoder(req.Body).Decode(s.data(query.Get("userId"), path[1]))
+
+		resp.WriteHeader(http.StatusNoContent)
+		return
+	case len(path) == 4 && path[0] == "Users" && (path[2] == "PlayedItems" || path[2] == "FavoriteItems"):
+		data := s.data(path[1], path[3])
+		if path[2] == "PlayedItems" {
+			data.Played = req.Method == http.MethodPost
+		} else {
+			data.IsFavorite = req.Method == http.MethodPost
+		}
+
+		resp.WriteHeader(http.StatusNoContent)
+		return
+	case req.URL.Path == "/Items" && query.Get("includeItemTypes") == "Playlist":
+		for id, playlist := range s.playlists {
+			items = append(items, gelatin.GelatinLibraryItem{Id: id, Name: playlist.name, Type: "Playlist", MediaType: "Video"})
+		}
+	case req.URL.Path == "/Items" && query.Get("includeItemTypes") == "BoxSet":
+		for id, collection := range s.collections {
+			items = append(items, gelatin.GelatinLibraryItem{Id: id, Name: collection.name, Type: "BoxSet"})
+		}
+	case req.URL.Path == "/Items" && s.collections[query.Get("parentId")] != nil:
+		items = s.collections[query.Get("parentId")].entries
+	case req.URL.Path == "/Items" && query.Get("parentId") != "":
+		// Seasons of a series, or episodes of a season
+		parentId := query.Get("parentId")
+		for _, item := range s.items {
+			if item.SeasonId == parentId || (item.Type == "Season" && item.SeriesId == parentId) {
+				items = append(items, item)
+			}
+		}
+		items = s.withUserData(items, query.Get("userId"))
+	case req.URL.Path == "/Items":
+		types := strings.Split(query.Get("includeItemTypes"), ",")
+		for _, item := range s.items {
+			for _, t := range types {
+				if item.Type == t || t == "" {
+					items = append(items, item)
+				}
+			}
+		}
+		if query.Get("userId") != "" {
+			items = s.withUserData(items, query.Get("userId"))
+		}
+	case req.Method == http.MethodPost && req.URL.Path == "/Playlists":
+		var create struct {
+			Name string
+			Ids  []string
+		}
+		json.NewDecoder(req.Body).Decode(&create)
+
+		s.nextId++
+		id := fmt.Sprintf("playlist-%d", s.nextId)
+		playlist := &fakeList{name: create.Name}
+		for _, itemId := range create.Ids {
+			s.add(playlist, itemId)
+		}
+		s.playlists[id] = playlist
+
+		json.NewEncoder(resp).Encode(map[string]string{"Id": id})
+		return
+	case req.Method == http.MethodPost && req.URL.Path == "/Collections":
+		s.nextId++
+		id := fmt.Sprintf("collection-%d", s.nextId)
+		collection := &fakeList{name: query.Get("name")}
+		for _, itemId := range strings.Split(query.Get("ids"), ",") {
+			s.add(collection, itemId)
+		}
+		s.collections[id] = collection
+
+		json.NewEncoder(resp).Encode(map[string]string{"Id": id})
+		return
+	case len(path) == 3 && path[0] == "Collections":
+		collection := s.collections[path[1]]
+		ids := strings.Split(query.Get("ids"), ",")
+
+		if req.Method == http.MethodPost {
+			for _, itemId := range ids {
+				s.add(collection, itemId)
+			}
+		} else {
+			var kept []gelatin.GelatinLibraryItem
+			for _, entry := range collection.entries {
+				removed := false
+				for _, id := range ids {
+					removed = removed || entry.Id == id
+				}
+				if !removed {
+					kept = append(kept, entry)
+				}
+			}
+			collection.entries = kept
+		}
+
+		resp.WriteHeader(http.StatusNoContent)
+		return
+	case len(path) >= 3 && path[0] == "Playlists":
+		playlist := s.playlists[path[1]]
+
+		switch {
+		case req.Method == http.MethodGet:
+			items = playlist.entries
+		case req.Method == http.MethodPost && len(path) == 3:
+			for _, itemId := range strings.Split(query.Get("ids"), ",") {
+				s.add(playlist, itemId)
+			}
+		case req.Method == http.MethodDelete:
+			remove := make(map[string]bool)
+			for _, entryId := range strings.Split(query.Get("entryIds"), ",") {
+				remove[entryId] = true
+			}
+			var kept []gelatin.GelatinLibraryItem
+			for _, entry := range playlist.entries {
+				if !remove[entry.PlaylistItemId] {
+					kept = append(kept, entry)
+				}
+			}
+			playlist.entries = kept
+		case req.Method == http.MethodPost && len(path) == 6 && path[4] == "Move":
+			index, _ := strconv.Atoi(path[5])
+			var moved gelatin.GelatinLibraryItem
+			var rest []gelatin.GelatinLibraryItem
+			for _, entry := range playlist.entries {
+				if entry.PlaylistItemId == path[3] {
+					moved = entry
+				} else {
+					rest = append(rest, entry)
+				}
+			}
+			playlist.entries = append(rest[:index:index], append([]gelatin.GelatinLibraryItem{moved}, rest[index:]...)...)
+		}
+
+		if req.Method != http.MethodGet {
+			resp.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		http.NotFound(resp, req)
+		return
+	}
+
+	json.NewEncoder(resp).Encode(&jellyfin.JellyfinLibraryItemResponse{Items: items, TotalRecordCount: int32(len(items))})
+}
@@ -0,0 +1,293 @@
+package gelatin
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// GelatinJournalEntry is a single record in a GelatinJournal
+type GelatinJournalEntry struct {
+	Time time.Time
+
+	// Operation that was applied (unset for checkpoints)
+	Operation *GelatinOperation `json:",omitempty"`
+
+	// Checkpoint marks a larger unit of work as complete, e.g. the watch history of a user
+	Checkpoint string `json:",omitempty"`
+}
+
+// GelatinJournal is an append-only log of the operations applied by a migration run.
+//
+// Each entry is written as a line of JSON and synced to disk before the next operation is
+// applied, so that a run that is interrupted can be resumed without repeating work.
+//
+// A nil journal is valid and records nothing.
+type GelatinJournal struct {
+	runId string
+	path  string
+	file  *os.File
+
+	entries []GelatinJournalEntry
+	applied map[string]bool
+	reached map[string]bool
+	mu      sync.Mutex
+}
+
+// NewRunId returns a new, unique run ID. Run IDs sort by the time they were created.
+func NewRunId() (string, error) {
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102T150405Z"), hex.EncodeToString(suffix)), nil
+}
+
+// JournalPath returns the path of the journal for the given run in dir
+func JournalPath(dir, runId string) string {
+	return filepath.Join(dir, runId+".jsonl")
+}
+
+// CreateJournal creates the journal for a new run in dir
+func CreateJournal(dir string) (*GelatinJournal, error) {
+	runId, err := NewRunId()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	path := JournalPath(dir, runId)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return newJournal(runId, path, file, nil), nil
+}
+
+// OpenJournal opens the journal of an earlier run in dir so that the run can be resumed.
+//
+// New entries are appended to the existing journal.
+func OpenJournal(dir, runId string) (*GelatinJournal, error) {
+	path := JournalPath(dir, runId)
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, size, err := readJournal(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read journal %s: %w", path, err)
+	}
+
+	// Drop a partially written entry (e.g., if the run was killed) before appending
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return newJournal(runId, path, file, entries), nil
+}
+
+// ReadJournal returns the entries in the journal of the given run in dir
+func ReadJournal(dir, runId string) ([]GelatinJournalEntry, error) {
+	path := JournalPath(dir, runId)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries, _, err := readJournal(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal %s: %w", path, err)
+	}
+
+	return entries, nil
+}
+
+// readJournal reads all complete entries and returns them along with their size in bytes
+func readJournal(r io.Reader) ([]GelatinJournalEntry, int64, error) {
+	var (
+		entries []GelatinJournalEntry
+		size    int64
+	)
+
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			// Anything after the last newline is an incomplete entry
+			return entries, size, nil
+		} else if err != nil {
+			return nil, 0, err
+		}
+
+		size += int64(len(data))
+
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+
+		var entry GelatinJournalEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, 0, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		entries = append(entries, entry)
+	}
+}
+
+func newJournal(runId, path string, file *os.File, entries []GelatinJournalEntry) *GelatinJournal {
+	j := &GelatinJournal{
+		runId:   runId,
+		path:    path,
+		file:    file,
+		applied: make(map[string]bool),
+		reached: make(map[string]bool),
+	}
+
+	for _, entry := range entries {
+		j.add(entry)
+	}
+
+	return j
+}
+
+func (j *GelatinJournal) add(entry GelatinJournalEntry) {
+	j.entries = append(j.entries, entry)
+	if entry.Operation != nil {
+		j.applied[entry.Operation.journalKey()] = true
+	}
+	if entry.Checkpoint != "" {
+		j.reached[entry.Checkpoint] = true
+	}
+}
+
+// journalKey identifies an operation across attempts of the same run. Plans are
+// recomputed when a run is resumed, so this does not rely on the position in the plan.
+func (op *GelatinOperation) journalKey() string {
+	switch op.Type {
+	case GelatinOperationUpdateUserActivity:
+		return fmt.Sprintf("%s/%s/%s/%s", op.Type, op.Target, op.UserId, op.ItemId)
+	case GelatinOperationCreatePlaylist, GelatinOperationUpdatePlaylist:
+		return fmt.Sprintf("%s/%s/%s", op.Type, op.UserId, op.PlaylistName)
+	case GelatinOperationCreateCollection, GelatinOperationUpdateCollection:
+		return fmt.Sprintf("%s/%s", op.Type, op.CollectionName)
+	default:
+		return fmt.Sprintf("%s/%s", op.Type, op.Username)
+	}
+}
+
+// RunId returns the ID of the run this journal belongs to
+func (j *GelatinJournal) RunId() string {
+	return j.runId
+}
+
+// Path returns the path of the journal file
+func (j *GelatinJournal) Path() string {
+	return j.path
+}
+
+// Entries returns a copy of all entries in the journal
+func (j *GelatinJournal) Entries() []GelatinJournalEntry {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return append([]GelatinJournalEntry(nil), j.entries...)
+}
+
+// Applied returns true if the operation was applied by an earlier attempt of this run
+func (j *GelatinJournal) Applied(op *GelatinOperation) bool {
+	if j == nil {
+		return false
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.applied[op.journalKey()]
+}
+
+// Reached returns true if the checkpoint was recorded by an earlier attempt of this run
+func (j *GelatinJournal) Reached(checkpoint string) bool {
+	if j == nil {
+		return false
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.reached[checkpoint]
+}
+
+// Record appends an applied operation to the journal
+func (j *GelatinJournal) Record(op *GelatinOperation) error {
+	return j.write(GelatinJournalEntry{Operation: op})
+}
+
+// Checkpoint appends a checkpoint to the journal
+func (j *GelatinJournal) Checkpoint(checkpoint string) error {
+	return j.write(GelatinJournalEntry{Checkpoint: checkpoint})
+}
+
+func (j *GelatinJournal) write(entry GelatinJournalEntry) error {
+	if j == nil {
+		return nil
+	}
+
+	entry.Time = time.Now().UTC()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal %s: %w", j.path, err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to write journal %s: %w", j.path, err)
+	}
+
+	j.add(entry)
+
+	return nil
+}
+
+func (j *GelatinJournal) Close() error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.file.Close()
+}
@@ -0,0 +1,102 @@
+package gelatin
+
+import (
+	"os"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+
+	journal, err := CreateJournal(dir)
+	if err != nil {
+		t.Fatalf("failed to create journal: %s", err)
+	}
+
+	created := &GelatinOperation{Type: GelatinOperationCreateUser, Username: "bob", UserId: "bob-id"}
+	played := &GelatinOperation{
+		Type:     GelatinOperationUpdateUserActivity,
+		Username: "bob",
+		UserId:   "bob-id",
+		ItemId:   "item-1",
+		Old:      &GelatinLibraryItemUserActivity{},
+		New:      &GelatinLibraryItemUserActivity{Played: true},
+	}
+
+	if err := journal.Record(created); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Record(played); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Checkpoint(watchCheckpoint("bob")); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a run that was killed while writing an entry
+	path := JournalPath(dir, journal.RunId())
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"Time":"2022-01-01T00:00:00Z","Operation":{"Type":"Upd`)
+	f.Close()
+
+	resumed, err := OpenJournal(dir, journal.RunId())
+	if err != nil {
+		t.Fatalf("failed to open journal: %s", err)
+	}
+
+	if !resumed.Applied(created) || !resumed.Applied(played) {
+		t.Errorf("expected recorded operations to be applied")
+	}
+
+	// The same operation, recomputed by a new plan
+	other := *played
+	other.ItemId = "item-2"
+	if resumed.Applied(&other) {
+		t.Errorf("unexpected applied operation for item-2")
+	}
+
+	if !resumed.Reached(watchCheckpoint("bob")) || resumed.Reached(watchCheckpoint("alice")) {
+		t.Errorf("unexpected checkpoints")
+	}
+
+	if err := resumed.Record(&other); err != nil {
+		t.Fatal(err)
+	}
+	resumed.Close()
+
+	entries, err := ReadJournal(dir, journal.RunId())
+	if err != nil {
+		t.Fatalf("failed to read journal: %s", err)
+	}
+
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(entries))
+	}
+	if op := entries[3].Operation; op == nil || op.ItemId != "item-2" {
+		t.Errorf("unexpected last entry: %+v", entries[3])
+	}
+	if entries[0].Operation.UserId != "bob-id" || !entries[1].Operation.New.Played {
+		t.Errorf("operation details were not recorded: %+v", entries[:2])
+	}
+}
+
+func TestNilJournal(t *testing.T) {
+	var journal *GelatinJournal
+
+	op := &GelatinOperation{Type: GelatinOperationCreateUser, Username: "bob"}
+	if journal.Applied(op) || journal.Reached("watch:bob") {
+		t.Errorf("nil journal has no entries")
+	}
+	if err := journal.Record(op); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := journal.Close(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,417 @@
+package gelatin
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Names of the built-in matchers
+const (
+	GelatinMatchProviderIds    = "provider-ids"
+	GelatinMatchPath           = "path"
+	GelatinMatchNameYear       = "name-year"
+	GelatinMatchAbsoluteNumber = "absolute-number"
+	GelatinMatchRuntime        = "runtime"
+)
+
+// GelatinMatch is a match for an item among the items of another service
+type GelatinMatch struct {
+	Item *GelatinLibraryItem
+
+	// Confidence in the match, between 0 and 1
+	Confidence float64
+
+	// Strategy is the name of the matcher that produced the match
+	Strategy string
+
+	// Alternatives are other candidates with the same confidence. If there are any, the
+	// match is ambiguous and Item is simply the first candidate found.
+	Alternatives []*GelatinLibraryItem
+}
+
+// Ambiguous returns true if several candidates match equally well. Ambiguous matches are
+// reported, but never acted on.
+func (m *GelatinMatch) Ambiguous() bool {
+	return len(m.Alternatives) > 0
+}
+
+// GelatinMatchInfo holds what is known about an item from the rest of its library
+type GelatinMatchInfo struct {
+	// Series of a season or episode, if known
+	Series *GelatinLibraryItem
+
+	// AbsoluteNumber of an episode across all regular seasons of its series (0 if unknown)
+	AbsoluteNumber int
+}
+
+// GelatinMatcher is a strategy for matching library items across services.
+//
+// Matchers are tried in order, and the first one that finds a candidate decides the match.
+// See DefaultMatchers.
+type GelatinMatcher interface {
+	// Name identifies the strategy in match results
+	Name() string
+
+	// Keys returns the keys of an item. Items of two services that share a key are candidates
+	// for a match. Items without keys cannot be matched by this strategy.
+	Keys(item *GelatinLibraryItem, info *GelatinMatchInfo) []string
+
+	// Confidence returns the confidence (between 0 and 1) in a match between an item and a
+	// candidate that shares a key with it. A confidence of 0 rejects the candidate.
+	Confidence(item, candidate *GelatinLibraryItem) float64
+}
+
+// DefaultMatchers returns the built-in matchers, from most to least reliable
+func DefaultMatchers() []GelatinMatcher {
+	return []GelatinMatcher{
+		providerIdsMatcher{},
+		pathMatcher{},
+		nameYearMatcher{},
+		absoluteNumberMatcher{},
+		runtimeMatcher{},
+	}
+}
+
+// NewMatcher returns the built-in matcher with the given name
+func NewMatcher(name string) (GelatinMatcher, error) {
+	for _, m := range DefaultMatchers() {
+		if m.Name() == name {
+			return m, nil
+		}
+	}
+
+	return nil, fmt.Errorf("invalid matcher: %q", name)
+}
+
+// normalizeName lowercases a name and strips everything but letters and digits, so that
+// e.g. "Marvel's Daredevil" and "Marvels Daredevil" are equal
+func normalizeName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// identityProviders are the (lowercased) providers whose IDs identify a single movie or series.
+// Other provider IDs can be shared by several items, e.g. TmdbCollection is the same for every
+// movie of a collection.
+var identityProviders = map[string]bool{
+	"imdb":    true,
+	"tmdb":    true,
+	"tvdb":    true,
+	"anidb":   true,
+	"anilist": true,
+	"tvmaze":  true,
+}
+
+// providerKeys returns a "provider=id" key for every identity provider ID of an item, in a
+// stable order
+func providerKeys(item *GelatinLibraryItem) []string {
+	seen := make(map[string]bool)
+	add := func(provider, id string) {
+		provider = strings.ToLower(provider)
+		if id = strings.TrimSpace(id); id != "" && identityProviders[provider] {
+			seen[provider+"="+id] = true
+		}
+	}
+
+	for provider, id := range item.ProviderIds {
+		add(provider, id)
+	}
+	add("imdb", item.ImdbId)
+	add("tmdb", item.TmdbId)
+	add("tvdb", item.TvdbId)
+
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// seriesKeys returns the keys that identify the series of a season or episode. Series without
+// provider IDs are identified by name.
+func seriesKeys(info *GelatinMatchInfo) []string {
+	if info == nil || info.Series == nil {
+		return nil
+	}
+
+	if keys := providerKeys(info.Series); len(keys) > 0 {
+		return keys
+	}
+
+	return []string{"name=" + normalizeName(info.Series.Name)}
+}
+
+// providerIdsMatcher matches items that share any identity provider ID (IMDb, TMDB, TVDB,
+// AniDB, AniList or TvMaze). Seasons and episodes are matched by the provider IDs of their series and
+// their season and episode numbers.
+type providerIdsMatcher struct{}
+
+func (providerIdsMatcher) Name() string {
+	return GelatinMatchProviderIds
+}
+
+func (providerIdsMatcher) Keys(item *GelatinLibraryItem, info *GelatinMatchInfo) []string {
+	var keys []string
+
+	switch item.Type {
+	case "Movie", "Series":
+		for _, key := range providerKeys(item) {
+			keys = append(keys, item.Type+"/"+key)
+		}
+	case "Season":
+		for _, key := range seriesKeys(info) {
+			keys = append(keys, fmt.Sprintf("Season/%s/%d", key, item.IndexNumber))
+		}
+	case "Episode":
+		for _, key := range seriesKeys(info) {
+			keys = append(keys, fmt.Sprintf("Episode/%s/%d/%d", key, item.ParentIndexNumber, item.IndexNumber))
+		}
+	}
+
+	return keys
+}
+
+func (providerIdsMatcher) Confidence(item, candidate *GelatinLibraryItem) float64 {
+	return 1
+}
+
+// pathMatcher matches movies and episodes by the name of their media file. Only the file name
+// is compared, since libraries are usually mounted at different paths on each server.
+// Episode files are often named after their number alone (e.g., S01E01.mkv), so episodes
+// are only matched within their series.
+type pathMatcher struct{}
+
+func (pathMatcher) Name() string {
+	return GelatinMatchPath
+}
+
+func (pathMatcher) Keys(item *GelatinLibraryItem, info *GelatinMatchInfo) []string {
+	if item.Path == "" || (item.Type != "Movie" && item.Type != "Episode") {
+		return nil
+	}
+
+	// Paths can come from Windows servers
+	name := item.Path[strings.LastIndexAny(item.Path, `/\`)+1:]
+	if name == "" {
+		return nil
+	}
+
+	name = strings.ToLower(name)
+	if item.Type == "Movie" {
+		return []string{"Movie/" + name}
+	}
+
+	var keys []string
+	for _, key := range seriesKeys(info) {
+		keys = append(keys, fmt.Sprintf("Episode/%s/%s", key, name))
+	}
+
+	return keys
+}
+
+func (pathMatcher) Confidence(item, candidate *GelatinLibraryItem) float64 {
+	return 0.9
+}
+
+// nameYearMatcher matches movies and series by name and production year, which tells
+// remakes apart
+type nameYearMatcher struct{}
+
+func (nameYearMatcher) Name() string {
+	return GelatinMatchNameYear
+}
+
+func (nameYearMatcher) Keys(item *GelatinLibraryItem, info *GelatinMatchInfo) []string {
+	if item.ProductionYear == 0 || (item.Type != "Movie" && item.Type != "Series") {
+		return nil
+	}
+
+	name := normalizeName(item.Name)
+	if name == "" {
+		return nil
+	}
+
+	return []string{fmt.Sprintf("%s/%s/%d", item.Type, name, item.ProductionYear)}
+}
+
+func (nameYearMatcher) Confidence(item, candidate *GelatinLibraryItem) float64 {
+	return 0.8
+}
+
+// absoluteNumberMatcher matches episodes by their absolute number in the series, for series
+// that are split into seasons differently on each server (common for anime)
+type absoluteNumberMatcher struct{}
+
+func (absoluteNumberMatcher) Name() string {
+	return GelatinMatchAbsoluteNumber
+}
+
+func (absoluteNumberMatcher) Keys(item *GelatinLibraryItem, info *GelatinMatchInfo) []string {
+	if item.Type != "Episode" || info == nil || info.AbsoluteNumber == 0 {
+		return nil
+	}
+
+	var keys []string
+	for _, key := range seriesKeys(info) {
+		keys = append(keys, fmt.Sprintf("Episode/%s/#%d", key, info.AbsoluteNumber))
+	}
+
+	return keys
+}
+
+func (absoluteNumberMatcher) Confidence(item, candidate *GelatinLibraryItem) float64 {
+	return 0.7
+}
+
+// runtimeTolerance is the largest difference in runtime accepted by runtimeMatcher: 2% of the
+// runtime, but at least a minute (in ticks of 100ns)
+func runtimeTolerance(ticks int64) int64 {
+	const minute = 60 * 10000000
+
+	if tolerance := ticks / 50; tolerance > minute {
+		return tolerance
+	}
+	return minute
+}
+
+// runtimeMatcher matches movies by name, and episodes by series and name, as long as their
+// runtimes are close. This catches items without provider IDs or with different titles for
+// the same year.
+type runtimeMatcher struct{}
+
+func (runtimeMatcher) Name() string {
+	return GelatinMatchRuntime
+}
+
+func (runtimeMatcher) Keys(item *GelatinLibraryItem, info *GelatinMatchInfo) []string {
+	name := normalizeName(item.Name)
+	if item.RunTimeTicks == 0 || name == "" {
+		return nil
+	}
+
+	switch item.Type {
+	case "Movie":
+		return []string{"Movie/" + name}
+	case "Episode":
+		var keys []string
+		for _, key := range seriesKeys(info) {
+			keys = append(keys, fmt.Sprintf("Episode/%s/%s", key, name))
+		}
+		return keys
+	default:
+		return nil
+	}
+}
+
+func (runtimeMatcher) Confidence(item, candidate *GelatinLibraryItem) float64 {
+	diff := item.RunTimeTicks - candidate.RunTimeTicks
+	if diff < 0 {
+		diff = -diff
+	}
+
+	longest := item.RunTimeTicks
+	if candidate.RunTimeTicks > longest {
+		longest = candidate.RunTimeTicks
+	}
+
+	if candidate.RunTimeTicks == 0 || diff > runtimeTolerance(longest) {
+		return 0
+	}
+	return 0.6
+}
+
+// matchIndex holds the items of one service, so that items of another service can be matched
+// against them
+type matchIndex struct {
+	matchers []GelatinMatcher
+
+	// Library the indexed items belong to
+	library *libraryIndex
+
+	// Indexed items, in the order they were added
+	items []*GelatinLibraryItem
+	ids   map[string]bool
+
+	// Items by key, for each matcher
+	keys []map[string][]*GelatinLibraryItem
+}
+
+func newMatchIndex(matchers []GelatinMatcher, library *libraryIndex) *matchIndex {
+	if len(matchers) == 0 {
+		matchers = DefaultMatchers()
+	}
+
+	m := &matchIndex{
+		matchers: matchers,
+		library:  library,
+		ids:      make(map[string]bool),
+		keys:     make([]map[string][]*GelatinLibraryItem, len(matchers)),
+	}
+	for i := range m.keys {
+		m.keys[i] = make(map[string][]*GelatinLibraryItem)
+	}
+
+	return m
+}
+
+// add indexes an item. Items that were already added (by ID) are ignored.
+func (m *matchIndex) add(item *GelatinLibraryItem) {
+	if m.ids[item.Id] {
+		return
+	}
+	m.ids[item.Id] = true
+	m.items = append(m.items, item)
+
+	info := m.library.info(item)
+	for i, matcher := range m.matchers {
+		for _, key := range matcher.Keys(item, info) {
+			m.keys[i][key] = append(m.keys[i][key], item)
+		}
+	}
+}
+
+// match finds the indexed item that matches an item of another library. Matchers are tried in
+// order, and the candidate with the highest confidence of the first matcher that finds one
+// wins. Returns nil if there is no match.
+func (m *matchIndex) match(item *GelatinLibraryItem, library *libraryIndex) *GelatinMatch {
+	info := library.info(item)
+
+	for i, matcher := range m.matchers {
+		var best *GelatinMatch
+		seen := make(map[*GelatinLibraryItem]bool)
+
+		for _, key := range matcher.Keys(item, info) {
+			for _, candidate := range m.keys[i][key] {
+				// Candidates often share several keys
+				if seen[candidate] {
+					continue
+				}
+				seen[candidate] = true
+
+				confidence := matcher.Confidence(item, candidate)
+				switch {
+				case confidence <= 0:
+				case best == nil || confidence > best.Confidence:
+					best = &GelatinMatch{Item: candidate, Confidence: confidence, Strategy: matcher.Name()}
+				case confidence == best.Confidence:
+					best.Alternatives = append(best.Alternatives, candidate)
+				}
+			}
+		}
+
+		if best != nil {
+			return best
+		}
+	}
+
+	return nil
+}
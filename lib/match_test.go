@@ -0,0 +1,139 @@
+package gelatin
+
+import (
+	"testing"
+)
+
+func TestMatchIndex(t *testing.T) {
+	const minute = 60 * 10000000
+
+	fromLibrary := &libraryIndex{
+		series: map[string]*GelatinLibraryItem{
+			"fma": {Id: "fma", Type: "Series", Name: "Fullmetal Alchemist", ProviderIds: map[string]string{"AniDB": "4160"}},
+		},
+		seasons: map[string]map[int32]*GelatinLibraryItem{
+			"fma": {1: {Id: "fma-1", Type: "Season", SeriesId: "fma", IndexNumber: 1, ChildCount: 64}},
+		},
+	}
+	intoLibrary := &libraryIndex{
+		series: map[string]*GelatinLibraryItem{
+			"fma-into": {Id: "fma-into", Type: "Series", Name: "Fullmetal Alchemist", ProviderIds: map[string]string{"anidb": "4160", "Tvdb": "85249"}},
+			"bebop":    {Id: "bebop", Type: "Series", Name: "Cowboy Bebop", ProviderIds: map[string]string{"Tvdb": "76885"}},
+		},
+		seasons: map[string]map[int32]*GelatinLibraryItem{
+			"fma-into": {1: {Id: "fma-into-1", Type: "Season", SeriesId: "fma-into", IndexNumber: 1, ChildCount: 26}},
+		},
+	}
+
+	fromItems := []GelatinLibraryItem{
+		{Id: "heat", Type: "Movie", Name: "Heat", ProviderIds: map[string]string{"TvMaze": "123", "Imdb": "tt0113277"}, Path: "/mnt/movies/Heat (1995).mkv"},
+		{Id: "heat-copy", Type: "Movie", Name: "Heat (copy)", Path: "/mnt/movies/Heat (1995).mkv"},
+		{Id: "dune-1984", Type: "Movie", Name: "Dune", ProductionYear: 1984},
+		{Id: "dune-2021", Type: "Movie", Name: "Dune", ProductionYear: 2021},
+		{Id: "arrival", Type: "Movie", Name: "Arrival", RunTimeTicks: 116 * minute},
+		{Id: "fma-1-27", Type: "Episode", Name: "Episode 27", SeriesId: "fma", ParentIndexNumber: 1, IndexNumber: 27},
+		{Id: "fma-1-1", Type: "Episode", Name: "Episode 1", SeriesId: "fma", ParentIndexNumber: 1, IndexNumber: 1, Path: "/mnt/anime/FMA/S01E01.mkv"},
+		{Id: "iron-man", Type: "Movie", Name: "Iron Man", ProviderIds: map[string]string{"TmdbCollection": "131292"}},
+	}
+
+	index := newMatchIndex(nil, fromLibrary)
+	for i := range fromItems {
+		index.add(&fromItems[i])
+	}
+
+	testCases := []struct {
+		name     string
+		item     GelatinLibraryItem
+		want     string
+		strategy string
+	}{
+		{
+			name:     "provider IDs win over the file name",
+			item:     GelatinLibraryItem{Type: "Movie", Name: "Heat", ProviderIds: map[string]string{"tvmaze": "123"}, Path: `D:\Movies\Heat (1995).mkv`},
+			want:     "heat",
+			strategy: GelatinMatchProviderIds,
+		},
+		{
+			name:     "file name",
+			item:     GelatinLibraryItem{Type: "Movie", Name: "Heat", Path: `D:\Movies\heat (1995).MKV`},
+			want:     "heat",
+			strategy: GelatinMatchPath,
+		},
+		{
+			name:     "episode file name within the series",
+			item:     GelatinLibraryItem{Type: "Episode", Name: "The First Day", SeriesId: "fma-into", Path: "/tv/Fullmetal Alchemist/S01E01.mkv"},
+			want:     "fma-1-1",
+			strategy: GelatinMatchPath,
+		},
+		{
+			name: "episode file name of another series",
+			item: GelatinLibraryItem{Type: "Episode", Name: "Asteroid Blues", SeriesId: "bebop", Path: "/tv/Cowboy Bebop/S01E01.mkv"},
+		},
+		{
+			name: "provider IDs shared by a set of items",
+			item: GelatinLibraryItem{Type: "Movie", Name: "Thor", ProviderIds: map[string]string{"TmdbCollection": "131292"}},
+		},
+		{
+			name:     "name and year tell remakes apart",
+			item:     GelatinLibraryItem{Type: "Movie", Name: "DUNE", ProductionYear: 2021},
+			want:     "dune-2021",
+			strategy: GelatinMatchNameYear,
+		},
+		{
+			name:     "absolute episode number",
+			item:     GelatinLibraryItem{Type: "Episode", Name: "Fallen", SeriesId: "fma-into", ParentIndexNumber: 2, IndexNumber: 1},
+			want:     "fma-1-27",
+			strategy: GelatinMatchAbsoluteNumber,
+		},
+		{
+			name:     "runtime within tolerance",
+			item:     GelatinLibraryItem{Type: "Movie", Name: "Arrival", RunTimeTicks: 118 * minute},
+			want:     "arrival",
+			strategy: GelatinMatchRuntime,
+		},
+		{
+			name: "runtime out of tolerance",
+			item: GelatinLibraryItem{Type: "Movie", Name: "Arrival", RunTimeTicks: 90 * minute},
+		},
+		{
+			name: "no match",
+			item: GelatinLibraryItem{Type: "Movie", Name: "Ronin", ProviderIds: map[string]string{"Imdb": "tt0122690"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			match := index.match(&tc.item, intoLibrary)
+
+			if tc.want == "" {
+				if match != nil {
+					t.Errorf("expected no match, got %q (%s)", match.Item.Id, match.Strategy)
+				}
+				return
+			}
+
+			if match == nil {
+				t.Fatalf("expected a match with %q, got none", tc.want)
+			}
+			if match.Item.Id != tc.want || match.Strategy != tc.strategy {
+				t.Errorf("expected %q (%s), got %q (%s)", tc.want, tc.strategy, match.Item.Id, match.Strategy)
+			}
+			if match.Confidence <= 0 || match.Confidence > 1 {
+				t.Errorf("invalid confidence: %f", match.Confidence)
+			}
+		})
+	}
+}
+
+func TestNewMatcher(t *testing.T) {
+	for _, m := range DefaultMatchers() {
+		got, err := NewMatcher(m.Name())
+		if err != nil || got.Name() != m.Name() {
+			t.Errorf("failed to look up matcher %q: %v", m.Name(), err)
+		}
+	}
+
+	if _, err := NewMatcher("fuzzy"); err == nil {
+		t.Errorf("expected an error for an unknown matcher")
+	}
+}
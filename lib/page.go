@@ -0,0 +1,67 @@
+package gelatin
+
+import (
+	"context"
+)
+
+// DefaultPageSize is the number of library items requested at a time when none is set
+const DefaultPageSize = 1000
+
+// GelatinItemPageFunc is called for each page of library items returned by a walk.
+//
+// Returning an error stops the walk, and the error is returned to the caller of the walk.
+type GelatinItemPageFunc func(items []GelatinLibraryItem) error
+
+// GelatinItemPageFetcher fetches a single page of library items starting at startIndex.
+//
+// It returns the items in the page along with the total number of items in the query.
+type GelatinItemPageFetcher func(ctx context.Context, startIndex, limit int) (items []GelatinLibraryItem, total int, err error)
+
+// WalkPages fetches consecutive pages of pageSize items and passes each one to fn.
+//
+// The walk ends once the total number of items reported by the server has been fetched,
+// or the server returns an empty page. A pageSize of 0 or less uses DefaultPageSize.
+func WalkPages(ctx context.Context, pageSize int, fetch GelatinItemPageFetcher, fn GelatinItemPageFunc) error {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+
+	for start := 0; ; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		items, total, err := fetch(ctx, start, pageSize)
+		if err != nil {
+			return err
+		}
+
+		if len(items) == 0 {
+			return nil
+		}
+
+		if err := fn(items); err != nil {
+			return err
+		}
+
+		start += len(items)
+		if start >= total {
+			return nil
+		}
+	}
+}
+
+// CollectPages runs a walk and returns all of the items it yields
+func CollectPages(walk func(fn GelatinItemPageFunc) error) ([]GelatinLibraryItem, error) {
+	var all []GelatinLibraryItem
+
+	err := walk(func(items []GelatinLibraryItem) error {
+		all = append(all, items...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return all, nil
+}
@@ -0,0 +1,94 @@
+package gelatin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func testItems(n int) []GelatinLibraryItem {
+	items := make([]GelatinLibraryItem, n)
+	for i := range items {
+		items[i].Id = fmt.Sprintf("item-%d", i)
+	}
+	return items
+}
+
+func TestWalkPages(t *testing.T) {
+	ctx := context.Background()
+	library := testItems(25)
+
+	fetch := func(ctx context.Context, startIndex, limit int) ([]GelatinLibraryItem, int, error) {
+		end := startIndex + limit
+		if end > len(library) {
+			end = len(library)
+		}
+		return library[startIndex:end], len(library), nil
+	}
+
+	t.Run("Pages", func(t *testing.T) {
+		var sizes []int
+		got, err := CollectPages(func(fn GelatinItemPageFunc) error {
+			return WalkPages(ctx, 10, fetch, func(items []GelatinLibraryItem) error {
+				sizes = append(sizes, len(items))
+				return fn(items)
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fmt.Sprint(sizes) != "[10 10 5]" {
+			t.Errorf("unexpected page sizes: %v", sizes)
+		}
+		if len(got) != len(library) || got[24].Id != "item-24" {
+			t.Errorf("unexpected items: %d", len(got))
+		}
+	})
+
+	t.Run("EmptyPage", func(t *testing.T) {
+		// A server that over-reports the total must not cause an endless walk
+		calls := 0
+		err := WalkPages(ctx, 10, func(ctx context.Context, startIndex, limit int) ([]GelatinLibraryItem, int, error) {
+			calls++
+			if startIndex > 0 {
+				return nil, 100, nil
+			}
+			return library[:10], 100, nil
+		}, func(items []GelatinLibraryItem) error {
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if calls != 2 {
+			t.Errorf("expected 2 fetches, got %d", calls)
+		}
+	})
+
+	t.Run("Stop", func(t *testing.T) {
+		errStop := errors.New("stop")
+		pages := 0
+		err := WalkPages(ctx, 10, fetch, func(items []GelatinLibraryItem) error {
+			pages++
+			return errStop
+		})
+		if err != errStop || pages != 1 {
+			t.Errorf("expected walk to stop after 1 page, got %d pages: %v", pages, err)
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		err := WalkPages(ctx, 10, fetch, func(items []GelatinLibraryItem) error {
+			t.Errorf("unexpected page")
+			return nil
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got: %v", err)
+		}
+	})
+}
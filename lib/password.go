@@ -0,0 +1,218 @@
+package gelatin
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sync"
+)
+
+const (
+	passwordAlphabet      = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	defaultPasswordLength = 16
+)
+
+// GelatinPasswordStrategy decides the initial password of users created by a migration
+type GelatinPasswordStrategy interface {
+	// Name returns a short description of the strategy (shown in plans)
+	Name() string
+
+	// Password returns the password to set for the given user
+	//
+	// If reset is true, the user's password is reset instead and password is ignored.
+	Password(username string) (password string, reset bool, err error)
+}
+
+// passwordRecorder is implemented by strategies that keep a record of the passwords they
+// generate. Passwords are recorded once they are set, so that the record only holds
+// passwords that work.
+type passwordRecorder interface {
+	recordPassword(username, password string) error
+}
+
+type mapPasswordStrategy struct {
+	passwords map[string]string
+}
+
+// NewMapPasswordStrategy sets passwords from the given username -> password map
+//
+// Applying the plan fails for any created user that is missing from the map.
+func NewMapPasswordStrategy(passwords map[string]string) GelatinPasswordStrategy {
+	return &mapPasswordStrategy{passwords: passwords}
+}
+
+func (s *mapPasswordStrategy) Name() string {
+	return "from map"
+}
+
+func (s *mapPasswordStrategy) Password(username string) (string, bool, error) {
+	password, ok := s.passwords[username]
+	if !ok {
+		return "", false, fmt.Errorf("no password provided for user %q", username)
+	}
+
+	return password, false, nil
+}
+
+// ReadPasswordFile reads a CSV file of username,password records
+func ReadPasswordFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	r.Comment = '#'
+
+	passwords := make(map[string]string)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse password file %s: %w", path, err)
+		}
+
+		passwords[record[0]] = record[1]
+	}
+
+	return passwords, nil
+}
+
+type randomPasswordStrategy struct {
+	path   string
+	length int
+	resume bool
+	file   *os.File
+	w      *csv.Writer
+	mu     sync.Mutex
+}
+
+// NewRandomPasswordStrategy generates a random password for each user and records it in a
+// CSV file at the given path so that the passwords can be handed off.
+//
+// The file is created with owner-only permissions when the first password is recorded, and
+// must not already exist, unless resume is true: a resumed run appends to the file of its
+// earlier attempts. The returned strategy implements io.Closer.
+func NewRandomPasswordStrategy(path string, length int, resume bool) GelatinPasswordStrategy {
+	if length <= 0 {
+		length = defaultPasswordLength
+	}
+
+	return &randomPasswordStrategy{
+		path:   path,
+		length: length,
+		resume: resume,
+	}
+}
+
+func (s *randomPasswordStrategy) Name() string {
+	return fmt.Sprintf("random, written to %s", s.path)
+}
+
+// write writes a single record and syncs it to disk, so that no password is lost if the
+// migration fails part way through
+func (s *randomPasswordStrategy) write(record []string) error {
+	if s.file == nil {
+		flag := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+		if s.resume {
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+
+		f, err := os.OpenFile(s.path, flag, 0600)
+		if err != nil {
+			return err
+		}
+
+		s.file = f
+		s.w = csv.NewWriter(f)
+
+		// Earlier attempts of a resumed run already wrote the header
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if info.Size() == 0 {
+			if err := s.write([]string{"username", "password"}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := s.w.Write(record); err != nil {
+		return err
+	}
+
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		return err
+	}
+
+	return s.file.Sync()
+}
+
+func (s *randomPasswordStrategy) Password(username string) (string, bool, error) {
+	password, err := generatePassword(s.length)
+	if err != nil {
+		return "", false, err
+	}
+
+	return password, false, nil
+}
+
+func (s *randomPasswordStrategy) recordPassword(username, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.write([]string{username, password}); err != nil {
+		return fmt.Errorf("failed to record password for user %q: %w", username, err)
+	}
+
+	return nil
+}
+
+func (s *randomPasswordStrategy) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	return s.file.Close()
+}
+
+type resetPasswordStrategy struct{}
+
+// NewResetPasswordStrategy resets the password of each created user
+func NewResetPasswordStrategy() GelatinPasswordStrategy {
+	return resetPasswordStrategy{}
+}
+
+func (resetPasswordStrategy) Name() string {
+	return "reset"
+}
+
+func (resetPasswordStrategy) Password(username string) (string, bool, error) {
+	return "", true, nil
+}
+
+func generatePassword(length int) (string, error) {
+	max := big.NewInt(int64(len(passwordAlphabet)))
+	password := make([]byte, length)
+
+	for i := range password {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		password[i] = passwordAlphabet[n.Int64()]
+	}
+
+	return string(password), nil
+}
@@ -0,0 +1,70 @@
+package gelatin_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	gelatin "github.com/aksiksi/gelatin/lib"
+)
+
+func TestRandomPasswordStrategy(t *testing.T) {
+	from, fromClient := newFakeServer(t, nil)
+	from.users = append(from.users, gelatin.GelatinUser{Name: "bob", Id: "bob-id"})
+
+	into, intoClient := newFakeServer(t, nil)
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "passwords.csv")
+
+	journal, err := gelatin.CreateJournal(dir)
+	if err != nil {
+		t.Fatalf("failed to create journal: %s", err)
+	}
+
+	// Passwords that could not be set are not recorded
+	into.fail["POST /Users/user-1/Password"] = http.StatusInternalServerError
+	passwords := gelatin.NewRandomPasswordStrategy(path, 0, false)
+	client := gelatin.NewGelatinClient(fromClient, intoClient, &gelatin.GelatinClientOpts{Passwords: passwords, Journal: journal})
+	if err := client.MigrateUsers(ctx, nil); err == nil {
+		t.Fatalf("expected the migration to fail")
+	}
+	passwords.(io.Closer).Close()
+	journal.Close()
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected no password to be recorded, got: %v", err)
+	}
+
+	// The resumed run appends to the output of the interrupted run
+	if err := os.WriteFile(path, []byte("username,password\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	delete(into.fail, "POST /Users/user-1/Password")
+
+	if journal, err = gelatin.OpenJournal(dir, journal.RunId()); err != nil {
+		t.Fatalf("failed to open journal: %s", err)
+	}
+	defer journal.Close()
+
+	passwords = gelatin.NewRandomPasswordStrategy(path, 0, true)
+	client = gelatin.NewGelatinClient(fromClient, intoClient, &gelatin.GelatinClientOpts{Passwords: passwords, Journal: journal})
+	if err := client.MigrateUsers(ctx, nil); err != nil {
+		t.Fatalf("failed to resume the migration: %s", err)
+	}
+	if err := passwords.(io.Closer).Close(); err != nil {
+		t.Fatalf("failed to close password output: %s", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read passwords: %s", err)
+	}
+	if want := "username,password\nbob," + into.passwords["user-1"] + "\n"; string(data) != want || into.passwords["user-1"] == "" {
+		t.Errorf("expected the password of bob to be recorded once, got: %q", data)
+	}
+}
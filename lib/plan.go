@@ -0,0 +1,247 @@
+package gelatin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+type GelatinOperationType string
+
+const (
+	GelatinOperationCreateUser         GelatinOperationType = "CreateUser"
+	GelatinOperationDeleteUser         GelatinOperationType = "DeleteUser"
+	GelatinOperationUpdateUserPolicy   GelatinOperationType = "UpdateUserPolicy"
+	GelatinOperationUpdateUserConfig   GelatinOperationType = "UpdateUserConfig"
+	GelatinOperationSetUserPassword    GelatinOperationType = "SetUserPassword"
+	GelatinOperationUpdateUserActivity GelatinOperationType = "UpdateUserActivity"
+	GelatinOperationCreatePlaylist     GelatinOperationType = "CreatePlaylist"
+	GelatinOperationUpdatePlaylist     GelatinOperationType = "UpdatePlaylist"
+	GelatinOperationCreateCollection   GelatinOperationType = "CreateCollection"
+	GelatinOperationUpdateCollection   GelatinOperationType = "UpdateCollection"
+)
+
+// GelatinOperationTarget is the service an operation applies to
+type GelatinOperationTarget string
+
+const (
+	GelatinOperationTargetInto GelatinOperationTarget = "into"
+	GelatinOperationTargetFrom GelatinOperationTarget = "from"
+)
+
+// GelatinOperation is a single change to be made to the "into" service (or, for two-way
+// syncs, the "from" service)
+//
+// Operations are plain data so that a plan can be printed, serialized to disk
+// and reviewed before it is applied.
+type GelatinOperation struct {
+	Type GelatinOperationType
+
+	// Target is the service the operation applies to. Empty means the into service.
+	// Only user activity updates can target the from service.
+	Target GelatinOperationTarget `json:",omitempty"`
+
+	// User the operation applies to
+	//
+	// UserId may be empty for users that are created by an earlier operation in
+	// the same plan. In that case, the user is looked up by name when applied.
+	Username string
+	UserId   string `json:",omitempty"`
+
+	// User profile to set (UpdateUserPolicy and UpdateUserConfig only)
+	Policy *GelatinUserPolicy `json:",omitempty"`
+	Config *GelatinUserConfig `json:",omitempty"`
+
+	// Name of the password strategy to use (SetUserPassword only). The password itself
+	// is never stored in the plan.
+	PasswordStrategy string `json:",omitempty"`
+
+	// Library item the operation applies to (UpdateUserActivity only)
+	ItemId     string `json:",omitempty"`
+	ItemName   string `json:",omitempty"`
+	ItemType   string `json:",omitempty"`
+	SeriesName string `json:",omitempty"`
+
+	// Item user activity before and after the operation (UpdateUserActivity only)
+	Old *GelatinLibraryItemUserActivity `json:",omitempty"`
+	New *GelatinLibraryItemUserActivity `json:",omitempty"`
+
+	// Strategy and confidence of the match between the items of both services
+	// (UpdateUserActivity only)
+	MatchStrategy   string  `json:",omitempty"`
+	MatchConfidence float64 `json:",omitempty"`
+
+	// Playlist the operation applies to (CreatePlaylist and UpdatePlaylist only). PlaylistId
+	// is set once the playlist is created.
+	PlaylistId        string `json:",omitempty"`
+	PlaylistName      string `json:",omitempty"`
+	PlaylistMediaType string `json:",omitempty"`
+
+	// Items of the playlist before and after the operation, in order (CreatePlaylist and
+	// UpdatePlaylist only)
+	OldPlaylistItemIds []string `json:",omitempty"`
+	PlaylistItemIds    []string `json:",omitempty"`
+
+	// Collection the operation applies to (CreateCollection and UpdateCollection only).
+	// Collections are shared by all users, so these operations have no user.
+	CollectionId   string `json:",omitempty"`
+	CollectionName string `json:",omitempty"`
+
+	// Members of the collection before and after the operation (CreateCollection and
+	// UpdateCollection only)
+	OldCollectionItemIds []string `json:",omitempty"`
+	CollectionItemIds    []string `json:",omitempty"`
+
+	// Warnings holds anything that could not be migrated faithfully
+	Warnings []string `json:",omitempty"`
+}
+
+func formatChange(old, new interface{}) string {
+	if old != new {
+		return fmt.Sprintf("%v->%v", old, new)
+	}
+	return fmt.Sprintf("%v", old)
+}
+
+// formatDate formats the last played date of an activity, or "-" if it was never played
+func formatDate(data *GelatinLibraryItemUserActivity) string {
+	t := lastPlayed(data)
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func (op *GelatinOperation) String() string {
+	s := op.describe()
+	for _, warning := range op.Warnings {
+		s += fmt.Sprintf("\n    warning: %s", warning)
+	}
+	return s
+}
+
+func (op *GelatinOperation) describe() string {
+	switch op.Type {
+	case GelatinOperationCreateUser:
+		return fmt.Sprintf("Create user: %s", op.Username)
+	case GelatinOperationDeleteUser:
+		return fmt.Sprintf("Delete user: %s (%s)", op.Username, op.UserId)
+	case GelatinOperationUpdateUserPolicy:
+		return fmt.Sprintf("Update policy for user: %s (admin: %v, all folders: %v, folders: %d)",
+			op.Username, op.Policy.IsAdministrator, op.Policy.EnableAllFolders, len(op.Policy.EnabledFolders))
+	case GelatinOperationUpdateUserConfig:
+		return fmt.Sprintf("Update configuration for user: %s (audio: %q, subtitles: %q, subtitle mode: %q)",
+			op.Username, op.Config.AudioLanguagePreference, op.Config.SubtitleLanguagePreference, op.Config.SubtitleMode)
+	case GelatinOperationSetUserPassword:
+		return fmt.Sprintf("Set password for user: %s (%s)", op.Username, op.PasswordStrategy)
+	case GelatinOperationUpdateUserActivity:
+		username := op.Username
+		if op.Target == GelatinOperationTargetFrom {
+			username += "@from"
+		}
+
+		played := formatChange(op.Old.Played, op.New.Played)
+		favorite := formatChange(op.Old.IsFavorite, op.New.IsFavorite)
+		ticks := formatChange(op.Old.PlaybackPositionTicks, op.New.PlaybackPositionTicks)
+
+		var desc string
+		switch op.ItemType {
+		case "Movie":
+			desc = fmt.Sprintf("[%s] Movie: %q, Played: %s, Favorite: %s, Ticks: %s", username, op.ItemName, played, favorite, ticks)
+		case "Series":
+			desc = fmt.Sprintf("[%s] Series: %q, Played: %s, Favorite: %s", username, op.ItemName, played, favorite)
+		case "Season":
+			desc = fmt.Sprintf("[%s] Season: %q (%q), Played: %s, Favorite: %s", username, op.ItemName, op.SeriesName, played, favorite)
+		case "Episode":
+			desc = fmt.Sprintf("[%s] Episode: %q (%q), Played: %s, Favorite: %s, Ticks: %s", username, op.ItemName, op.SeriesName, played, favorite, ticks)
+		default:
+			desc = fmt.Sprintf("[%s] %s: %q, Played: %s, Favorite: %s, Ticks: %s", username, op.ItemType, op.ItemName, played, favorite, ticks)
+		}
+
+		// Other user data is rarely set, so it is only shown when it changes
+		if op.Old.Rating != op.New.Rating {
+			desc += fmt.Sprintf(", Rating: %s", formatChange(op.Old.Rating, op.New.Rating))
+		}
+		if op.Old.likes() != op.New.likes() {
+			desc += fmt.Sprintf(", Likes: %s", formatChange(op.Old.likes(), op.New.likes()))
+		}
+		if op.Old.PlayCount != op.New.PlayCount {
+			desc += fmt.Sprintf(", Plays: %s", formatChange(op.Old.PlayCount, op.New.PlayCount))
+		}
+		if !lastPlayed(op.Old).Truncate(time.Second).Equal(lastPlayed(op.New).Truncate(time.Second)) {
+			desc += fmt.Sprintf(", Last played: %s", formatChange(formatDate(op.Old), formatDate(op.New)))
+		}
+
+		// Matches by provider ID are the norm, so only other strategies are shown
+		if op.MatchStrategy != "" && op.MatchStrategy != GelatinMatchProviderIds {
+			desc += fmt.Sprintf(" (matched by %s, %.0f%%)", op.MatchStrategy, op.MatchConfidence*100)
+		}
+
+		return desc
+	case GelatinOperationCreatePlaylist:
+		return fmt.Sprintf("[%s] Create playlist: %q (%d items)", op.Username, op.PlaylistName, len(op.PlaylistItemIds))
+	case GelatinOperationUpdatePlaylist:
+		return fmt.Sprintf("[%s] Update playlist: %q (%s items)", op.Username, op.PlaylistName, formatChange(len(op.OldPlaylistItemIds), len(op.PlaylistItemIds)))
+	case GelatinOperationCreateCollection:
+		return fmt.Sprintf("Create collection: %q (%d items)", op.CollectionName, len(op.CollectionItemIds))
+	case GelatinOperationUpdateCollection:
+		return fmt.Sprintf("Update collection: %q (%s items)", op.CollectionName, formatChange(len(op.OldCollectionItemIds), len(op.CollectionItemIds)))
+	default:
+		return fmt.Sprintf("Unknown operation: %s", op.Type)
+	}
+}
+
+// GelatinPlan is an ordered list of operations computed by a GelatinClient
+//
+// A plan does not modify any service until it is passed to GelatinClient.Apply().
+type GelatinPlan struct {
+	Operations []GelatinOperation
+}
+
+func (p *GelatinPlan) add(op GelatinOperation) {
+	p.Operations = append(p.Operations, op)
+}
+
+// Merge appends the operations of other to this plan
+func (p *GelatinPlan) Merge(other *GelatinPlan) {
+	p.Operations = append(p.Operations, other.Operations...)
+}
+
+// Empty returns true if the plan has no operations
+func (p *GelatinPlan) Empty() bool {
+	return len(p.Operations) == 0
+}
+
+func (p *GelatinPlan) String() string {
+	if p.Empty() {
+		return "No changes"
+	}
+
+	var sb strings.Builder
+	for i := range p.Operations {
+		fmt.Fprintf(&sb, "%s\n", p.Operations[i].String())
+	}
+	fmt.Fprintf(&sb, "%d operation(s)", len(p.Operations))
+
+	return sb.String()
+}
+
+// WriteJSON writes the plan as indented JSON
+func (p *GelatinPlan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+// ReadPlan reads a plan previously written with WriteJSON
+func ReadPlan(r io.Reader) (*GelatinPlan, error) {
+	plan := &GelatinPlan{}
+	dec := json.NewDecoder(r)
+	if err := dec.Decode(plan); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,84 @@
+package gelatin_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	gelatin "github.com/aksiksi/gelatin/lib"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPlanApplyFromFile(t *testing.T) {
+	library := []gelatin.GelatinLibraryItem{
+		{Id: "heat", Name: "Heat", Type: "Movie", ProviderIds: map[string]string{"Imdb": "tt0113277"}},
+		{Id: "ronin", Name: "Ronin", Type: "Movie", ProviderIds: map[string]string{"Imdb": "tt0122690"}},
+	}
+
+	from, fromClient := newFakeServer(t, library)
+	from.users = append(from.users, gelatin.GelatinUser{
+		Name:   "bob",
+		Id:     "bob-id",
+		Policy: gelatin.GelatinUserPolicy{IsHidden: true, EnableAllFolders: true},
+	})
+	from.setUserData("alice-id", "heat", gelatin.GelatinLibraryItemUserActivity{Played: true, PlayCount: 2})
+	from.setUserData("alice-id", "ronin", gelatin.GelatinLibraryItemUserActivity{IsFavorite: true, Rating: 8, PlaybackPositionTicks: 100})
+
+	// The plan is applied directly to one server, and from a file to an identical one
+	newInto := func() (*fakeServer, *gelatin.GelatinClient) {
+		into, intoClient := newFakeServer(t, library)
+		return into, gelatin.NewGelatinClient(fromClient, intoClient, nil)
+	}
+	into, client := newInto()
+	intoFile, fileClient := newInto()
+
+	ctx := context.Background()
+	plan, err := client.PlanMigrateUsers(ctx)
+	if err != nil {
+		t.Fatalf("failed to plan user migration: %s", err)
+	}
+	watch, err := client.PlanMigrateUserWatchHistory(ctx, "alice")
+	if err != nil {
+		t.Fatalf("failed to plan watch history migration: %s", err)
+	}
+	plan.Merge(watch)
+
+	// A dry run only plans
+	if len(into.users) != 1 || len(into.userData) != 0 {
+		t.Fatalf("expected planning to leave the into server alone, got: %+v, %+v", into.users, into.userData)
+	}
+
+	var buf bytes.Buffer
+	if err := plan.WriteJSON(&buf); err != nil {
+		t.Fatalf("failed to write plan: %s", err)
+	}
+	read, err := gelatin.ReadPlan(&buf)
+	if err != nil {
+		t.Fatalf("failed to read plan: %s", err)
+	}
+	if diff := cmp.Diff(plan, read); diff != "" {
+		t.Fatalf("-written,+read: %s", diff)
+	}
+
+	if err := client.Apply(ctx, plan); err != nil {
+		t.Fatalf("failed to apply plan: %s", err)
+	}
+	if err := fileClient.Apply(ctx, read); err != nil {
+		t.Fatalf("failed to apply plan from file: %s", err)
+	}
+
+	if bob := intoFile.user("bob"); bob == nil || !bob.Policy.IsHidden || !bob.Policy.EnableAllFolders {
+		t.Errorf("expected bob to be created with the from policy, got: %+v", bob)
+	}
+	if diff := cmp.Diff(into.users, intoFile.users); diff != "" {
+		t.Errorf("-applied,+applied from file: %s", diff)
+	}
+	for _, id := range []string{"heat", "ronin"} {
+		if diff := cmp.Diff(from.getUserData("alice-id", id), intoFile.getUserData("alice-id", id)); diff != "" {
+			t.Errorf("%s: -from,+applied from file: %s", id, diff)
+		}
+		if diff := cmp.Diff(into.getUserData("alice-id", id), intoFile.getUserData("alice-id", id)); diff != "" {
+			t.Errorf("%s: -applied,+applied from file: %s", id, diff)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package gelatin
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// MigratePlaylists migrates a user's playlists from one service to another.
+//
+// See PlanMigratePlaylists for details.
+func (c *GelatinClient) MigratePlaylists(ctx context.Context, username string) error {
+	plan, err := c.PlanMigratePlaylists(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	return c.Apply(ctx, plan)
+}
+
+// PlanMigratePlaylists computes the operations needed to recreate a user's playlists in the
+// into service.
+//
+// Every entry of a playlist is matched against the library of the into service (see
+// GelatinMatcher), and the playlist is recreated with the matched items in the same order.
+// Entries without a match are skipped, with a warning on the operation.
+//
+// Playlists are identified by name, so that migrations can be re-run: a playlist that already
+// exists in the into service is updated to match, and left alone if it already does. Playlists
+// that only exist in the into service are never modified.
+//
+// If the user does not exist in either service, this method returns an error.
+func (c *GelatinClient) PlanMigratePlaylists(ctx context.Context, username string) (*GelatinPlan, error) {
+	fromUser, err := getUserByName(ctx, c.from, username)
+	if err != nil {
+		return nil, err
+	}
+
+	intoUser, err := getUserByName(ctx, c.into, username)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.planUserPlaylists(ctx, fromUser, intoUser)
+}
+
+func (c *GelatinClient) planUserPlaylists(ctx context.Context, fromUser, intoUser *GelatinUser) (*GelatinPlan, error) {
+	plan := &GelatinPlan{}
+
+	playlists, err := c.from.Playlist().GetPlaylists(ctx, fromUser.Id)
+	if err != nil {
+		return nil, err
+	}
+	if len(playlists) == 0 {
+		return plan, nil
+	}
+
+	// Only items of the types found in the playlists need to be matched against
+	entries := make([][]GelatinLibraryItem, len(playlists))
+	types := make(map[string]bool)
+	for i := range playlists {
+		if entries[i], err = c.from.Playlist().GetPlaylistItems(ctx, playlists[i].Id, fromUser.Id); err != nil {
+			return nil, fmt.Errorf("failed to get items of playlist %q: %w", playlists[i].Name, err)
+		}
+		for j := range entries[i] {
+			types[entries[i][j].Type] = true
+		}
+	}
+
+	fromIndex, intoIndex, err := c.libraryIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	intoItems := newMatchIndex(c.opts.Matchers, intoIndex)
+	if len(types) > 0 {
+		if err := addItems(ctx, c.into.Library(), intoUser.Id, types, intoItems); err != nil {
+			return nil, err
+		}
+	}
+
+	intoPlaylists, err := c.into.Playlist().GetPlaylists(ctx, intoUser.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]*GelatinLibraryItem)
+	for i := range intoPlaylists {
+		if _, ok := existing[intoPlaylists[i].Name]; !ok {
+			existing[intoPlaylists[i].Name] = &intoPlaylists[i]
+		}
+	}
+
+	for i := range playlists {
+		playlist := &playlists[i]
+
+		op := GelatinOperation{
+			Username:          intoUser.Name,
+			UserId:            intoUser.Id,
+			PlaylistName:      playlist.Name,
+			PlaylistMediaType: playlist.MediaType,
+		}
+
+		for j := range entries[i] {
+			entry := &entries[i][j]
+
+			match := intoItems.match(entry, fromIndex)
+			if match == nil {
+				c.opts.Report.addUnmatched(fromUser.Name, entry)
+				op.Warnings = append(op.Warnings, fmt.Sprintf("skipped entry %d: no match for %s %q", j+1, entry.Type, entry.Name))
+				continue
+			}
+			c.opts.Report.addMatch(fromUser.Name, "from", entry, match)
+			if match.Ambiguous() {
+				op.Warnings = append(op.Warnings, fmt.Sprintf("skipped entry %d: ambiguous match for %s %q", j+1, entry.Type, entry.Name))
+				continue
+			}
+
+			op.PlaylistItemIds = append(op.PlaylistItemIds, match.Item.Id)
+		}
+
+		intoPlaylist, ok := existing[playlist.Name]
+		if !ok {
+			if len(op.PlaylistItemIds) == 0 && len(entries[i]) > 0 {
+				log.Printf("skipping playlist %q of %s: none of its entries could be matched", playlist.Name, fromUser.Name)
+				continue
+			}
+
+			op.Type = GelatinOperationCreatePlaylist
+			plan.add(op)
+			continue
+		}
+
+		current, err := c.into.Playlist().GetPlaylistItems(ctx, intoPlaylist.Id, intoUser.Id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get items of playlist %q: %w", intoPlaylist.Name, err)
+		}
+
+		var currentIds []string
+		for j := range current {
+			currentIds = append(currentIds, current[j].Id)
+		}
+		if equalIds(currentIds, op.PlaylistItemIds) {
+			continue
+		}
+
+		op.Type = GelatinOperationUpdatePlaylist
+		op.PlaylistId = intoPlaylist.Id
+		op.OldPlaylistItemIds = currentIds
+		plan.add(op)
+	}
+
+	return plan, nil
+}
+
+// addItems adds all items of the given types to the match index. If userId is empty, the
+// items are fetched without user data.
+func addItems(ctx context.Context, svc GelatinLibraryService, userId string, types map[string]bool, index *matchIndex) error {
+	var names []string
+	for t := range types {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+
+	filters := map[string]string{
+		svc.GetItemFilterString(GelatinItemFilterIncludeItemTypes): strings.Join(names, ","),
+	}
+	add := func(items []GelatinLibraryItem) error {
+		for i := range items {
+			index.add(&items[i])
+		}
+		return nil
+	}
+
+	if userId == "" {
+		return svc.WalkItems(ctx, filters, true, add)
+	}
+	return svc.WalkItemsByUser(ctx, userId, filters, add)
+}
+
+func equalIds(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// setPlaylistItems makes the entries of a playlist match the given items, in order.
+//
+// Entries of items that are already in the playlist are kept and moved into place, so that
+// only the differences are sent to the server.
+func setPlaylistItems(ctx context.Context, svc GelatinPlaylistService, playlistId, userId string, itemIds []string) error {
+	current, err := svc.GetPlaylistItems(ctx, playlistId, userId)
+	if err != nil {
+		return err
+	}
+
+	// Items can appear in a playlist more than once, so entries are counted
+	wanted := make(map[string]int)
+	for _, id := range itemIds {
+		wanted[id]++
+	}
+
+	var remove []string
+	for i := range current {
+		if wanted[current[i].Id] > 0 {
+			wanted[current[i].Id]--
+			continue
+		}
+		remove = append(remove, current[i].PlaylistItemId)
+	}
+
+	var add []string
+	for _, id := range itemIds {
+		if wanted[id] > 0 {
+			wanted[id]--
+			add = append(add, id)
+		}
+	}
+
+	if len(remove) > 0 {
+		if err := svc.RemovePlaylistItems(ctx, playlistId, remove); err != nil {
+			return err
+		}
+	}
+
+	if len(add) > 0 {
+		if err := svc.AddPlaylistItems(ctx, playlistId, userId, add); err != nil {
+			return err
+		}
+	}
+
+	// Fetch the entries again to get the IDs of the added entries
+	if len(remove) > 0 || len(add) > 0 {
+		if current, err = svc.GetPlaylistItems(ctx, playlistId, userId); err != nil {
+			return err
+		}
+	}
+
+	for i, id := range itemIds {
+		if i < len(current) && current[i].Id == id {
+			continue
+		}
+
+		j := i + 1
+		for j < len(current) && current[j].Id != id {
+			j++
+		}
+		if j >= len(current) {
+			return fmt.Errorf("item %s is missing from playlist %s", id, playlistId)
+		}
+
+		if err := svc.MovePlaylistItem(ctx, playlistId, current[j].PlaylistItemId, i); err != nil {
+			return err
+		}
+
+		// Mirror the move locally
+		entry := current[j]
+		copy(current[i+1:j+1], current[i:j])
+		current[i] = entry
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package gelatin_test
+
+import (
+	"context"
+	"testing"
+
+	gelatin "github.com/aksiksi/gelatin/lib"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMigratePlaylists(t *testing.T) {
+	from, fromClient := newFakeServer(t, []gelatin.GelatinLibraryItem{
+		{Id: "heat", Name: "Heat", Type: "Movie", ProviderIds: map[string]string{"Imdb": "tt0113277"}},
+		{Id: "ronin", Name: "Ronin", Type: "Movie", ProviderIds: map[string]string{"Imdb": "tt0122690"}},
+		{Id: "thief", Name: "Thief", Type: "Movie", ProviderIds: map[string]string{"Imdb": "tt0083190"}},
+	})
+	from.playlists["heists"] = &fakeList{name: "Heists"}
+	for _, id := range []string{"heat", "thief", "ronin"} {
+		from.add(from.playlists["heists"], id)
+	}
+
+	into, intoClient := newFakeServer(t, []gelatin.GelatinLibraryItem{
+		{Id: "into-ronin", Name: "Ronin", Type: "Movie", ProviderIds: map[string]string{"Imdb": "tt0122690"}},
+		{Id: "into-heat", Name: "Heat", Type: "Movie", ProviderIds: map[string]string{"Imdb": "tt0113277"}},
+		{Id: "into-arrival", Name: "Arrival", Type: "Movie", ProviderIds: map[string]string{"Imdb": "tt2543164"}},
+	})
+
+	ctx := context.Background()
+	report := gelatin.NewMatchReport()
+	client := gelatin.NewGelatinClient(fromClient, intoClient, &gelatin.GelatinClientOpts{Report: report})
+
+	plan, err := client.PlanMigratePlaylists(ctx, "alice")
+	if err != nil {
+		t.Fatalf("failed to plan playlist migration: %s", err)
+	}
+
+	want := []gelatin.GelatinOperation{{
+		Type:              gelatin.GelatinOperationCreatePlaylist,
+		Username:          "alice",
+		UserId:            "alice-id",
+		PlaylistName:      "Heists",
+		PlaylistMediaType: "Video",
+		PlaylistItemIds:   []string{"into-heat", "into-ronin"},
+		Warnings:          []string{`skipped entry 2: no match for Movie "Thief"`},
+	}}
+	if diff := cmp.Diff(want, plan.Operations); diff != "" {
+		t.Fatalf("-want,+got: %s", diff)
+	}
+	if len(report.Unmatched) != 1 || report.Unmatched[0].Item.Id != "thief" {
+		t.Errorf("expected the unmatched entry to be reported, got: %+v", report.Unmatched)
+	}
+
+	if err := client.Apply(ctx, plan); err != nil {
+		t.Fatalf("failed to apply plan: %s", err)
+	}
+	if diff := cmp.Diff([]string{"into-heat", "into-ronin"}, into.itemIds(into.playlists, "Heists")); diff != "" {
+		t.Errorf("-want,+got: %s", diff)
+	}
+
+	// Re-running is a no-op
+	if plan, err = client.PlanMigratePlaylists(ctx, "alice"); err != nil || !plan.Empty() {
+		t.Fatalf("expected an empty plan, got: %v, %v", plan, err)
+	}
+
+	// Playlists that diverged are updated in place
+	for _, playlist := range into.playlists {
+		playlist.entries = playlist.entries[:0]
+		for _, id := range []string{"into-ronin", "into-arrival", "into-heat", "into-ronin"} {
+			into.add(playlist, id)
+		}
+	}
+
+	if plan, err = client.PlanMigratePlaylists(ctx, "alice"); err != nil {
+		t.Fatalf("failed to plan playlist migration: %s", err)
+	}
+	if len(plan.Operations) != 1 || plan.Operations[0].Type != gelatin.GelatinOperationUpdatePlaylist {
+		t.Fatalf("expected a playlist update, got: %v", plan)
+	}
+	if err := client.Apply(ctx, plan); err != nil {
+		t.Fatalf("failed to apply plan: %s", err)
+	}
+	if diff := cmp.Diff([]string{"into-heat", "into-ronin"}, into.itemIds(into.playlists, "Heists")); diff != "" {
+		t.Errorf("-want,+got: %s", diff)
+	}
+	if len(into.playlists) != 1 {
+		t.Errorf("expected a single playlist, got %d", len(into.playlists))
+	}
+}
@@ -0,0 +1,96 @@
+package gelatin
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// errPoolStopped is returned for tasks that were never started because an earlier task failed
+var errPoolStopped = errors.New("not started: an earlier task failed")
+
+// runPool runs fn for each index in [0, n) using at most concurrency goroutines.
+//
+// Tasks are started in index order. Once a task fails or the context is cancelled, no new
+// tasks are started. The returned slice holds the error of each task at its index, so that
+// callers can report results in a deterministic order regardless of scheduling.
+func runPool(ctx context.Context, concurrency, n int, fn func(i int) error) []error {
+	errs := make([]error, n)
+
+	// stop marks tasks [i, n) as not started. If the context is done, task i gets its error.
+	stop := func(i int, cancelled bool) {
+		for j := i; j < n; j++ {
+			errs[j] = errPoolStopped
+		}
+		if cancelled && i < n {
+			errs[i] = ctx.Err()
+		}
+	}
+
+	if concurrency <= 1 {
+		for i := 0; i < n; i++ {
+			if ctx.Err() != nil {
+				stop(i, true)
+				break
+			}
+
+			if errs[i] = fn(i); errs[i] != nil {
+				stop(i+1, false)
+				break
+			}
+		}
+		return errs
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed bool
+	)
+
+	tasks := make(chan int)
+
+	for w := 0; w < concurrency && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range tasks {
+				err := fn(i)
+				errs[i] = err
+				if err != nil {
+					mu.Lock()
+					failed = true
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		mu.Lock()
+		stopped := failed
+		mu.Unlock()
+
+		if stopped || ctx.Err() != nil {
+			stop(i, !stopped)
+			break
+		}
+
+		tasks <- i
+	}
+
+	close(tasks)
+	wg.Wait()
+
+	return errs
+}
+
+// firstError returns the first error in errs that is not errPoolStopped
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil && err != errPoolStopped {
+			return err
+		}
+	}
+	return nil
+}
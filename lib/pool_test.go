@@ -0,0 +1,81 @@
+package gelatin
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRunPool(t *testing.T) {
+	for _, concurrency := range []int{1, 4} {
+		var running, maxRunning int32
+
+		errs := runPool(context.Background(), concurrency, 100, func(i int) error {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+					break
+				}
+			}
+
+			return nil
+		})
+
+		if err := firstError(errs); err != nil {
+			t.Errorf("concurrency %d: unexpected error: %s", concurrency, err)
+		}
+
+		if maxRunning > int32(concurrency) {
+			t.Errorf("concurrency %d: %d tasks ran in parallel", concurrency, maxRunning)
+		}
+	}
+
+	t.Run("Error", func(t *testing.T) {
+		wantErr := errors.New("task failed")
+
+		errs := runPool(context.Background(), 1, 5, func(i int) error {
+			if i == 2 {
+				return wantErr
+			}
+			return nil
+		})
+
+		want := []error{nil, nil, wantErr, errPoolStopped, errPoolStopped}
+		for i := range want {
+			if errs[i] != want[i] {
+				t.Errorf("task %d: want %v, got %v", i, want[i], errs[i])
+			}
+		}
+
+		if err := firstError(errs); err != wantErr {
+			t.Errorf("want %v, got %v", wantErr, err)
+		}
+	})
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		errs := runPool(ctx, 1, 10, func(i int) error {
+			if i == 3 {
+				cancel()
+			}
+			return nil
+		})
+
+		if err := firstError(errs); err != context.Canceled {
+			t.Errorf("want %v, got %v", context.Canceled, err)
+		}
+
+		errs = runPool(ctx, 4, 10, func(i int) error {
+			t.Errorf("task %d started after cancellation", i)
+			return nil
+		})
+
+		if err := firstError(errs); err != context.Canceled {
+			t.Errorf("want %v, got %v", context.Canceled, err)
+		}
+	})
+}
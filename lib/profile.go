@@ -0,0 +1,112 @@
+package gelatin
+
+import (
+	"fmt"
+)
+
+// folderMapping maps library folder IDs in the "from" service to IDs in the "into" service.
+//
+// Library folders are matched by name, since folder IDs are server-specific.
+type folderMapping struct {
+	ids   map[string]string
+	names map[string]string // from ID -> name, for warnings
+}
+
+func newFolderMapping(fromFolders, intoFolders []GelatinLibraryItem) *folderMapping {
+	m := &folderMapping{
+		ids:   make(map[string]string),
+		names: make(map[string]string),
+	}
+
+	intoIds := make(map[string]string)
+	for _, folder := range intoFolders {
+		intoIds[folder.Name] = folder.Id
+	}
+
+	for _, folder := range fromFolders {
+		m.names[folder.Id] = folder.Name
+		if id, ok := intoIds[folder.Name]; ok {
+			m.ids[folder.Id] = id
+		}
+	}
+
+	return m
+}
+
+// translate maps a list of folder IDs, dropping (and warning about) any that have no match
+func (m *folderMapping) translate(field string, ids []string, warnings *[]string) []string {
+	if ids == nil {
+		return nil
+	}
+
+	translated := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if intoId, ok := m.ids[id]; ok {
+			translated = append(translated, intoId)
+			continue
+		}
+
+		name := m.names[id]
+		if name == "" {
+			name = id
+		}
+		*warnings = append(*warnings, fmt.Sprintf("%s: no matching library folder for %q", field, name))
+	}
+
+	return translated
+}
+
+// translateUserPolicy converts a policy from the "from" service into one that is valid for
+// the "into" service.
+//
+// Fields that cannot be mapped are reset and reported in the returned warnings.
+func translateUserPolicy(policy *GelatinUserPolicy, folders *folderMapping) (*GelatinUserPolicy, []string) {
+	var warnings []string
+
+	p := *policy
+	p.EnabledFolders = folders.translate("EnabledFolders", policy.EnabledFolders, &warnings)
+	p.BlockedMediaFolders = folders.translate("BlockedMediaFolders", policy.BlockedMediaFolders, &warnings)
+	p.EnableContentDeletionFromFolders = folders.translate("EnableContentDeletionFromFolders", policy.EnableContentDeletionFromFolders, &warnings)
+
+	// Providers are server-specific, so the into service's defaults are kept
+	if policy.AuthenticationProviderId != "" {
+		warnings = append(warnings, fmt.Sprintf("AuthenticationProviderId: %q is not migrated", policy.AuthenticationProviderId))
+	}
+	if policy.PasswordResetProviderId != "" {
+		warnings = append(warnings, fmt.Sprintf("PasswordResetProviderId: %q is not migrated", policy.PasswordResetProviderId))
+	}
+	p.AuthenticationProviderId = ""
+	p.PasswordResetProviderId = ""
+
+	// Channel, sub-folder and device IDs are also server-specific and cannot be matched
+	if len(policy.EnabledChannels) > 0 || len(policy.BlockedChannels) > 0 {
+		warnings = append(warnings, "EnabledChannels/BlockedChannels: channel access is not migrated")
+	}
+	if len(policy.ExcludedSubFolders) > 0 {
+		warnings = append(warnings, "ExcludedSubFolders: excluded sub-folders are not migrated")
+	}
+	if len(policy.EnabledDevices) > 0 {
+		warnings = append(warnings, "EnabledDevices: device access is not migrated")
+	}
+	p.EnabledChannels = nil
+	p.BlockedChannels = nil
+	p.ExcludedSubFolders = nil
+	p.EnabledDevices = nil
+
+	p.InvalidLoginAttemptCount = 0
+
+	return &p, warnings
+}
+
+// translateUserConfig converts a user configuration from the "from" service into one that
+// is valid for the "into" service.
+func translateUserConfig(config *GelatinUserConfig, folders *folderMapping) (*GelatinUserConfig, []string) {
+	var warnings []string
+
+	c := *config
+	c.OrderedViews = folders.translate("OrderedViews", config.OrderedViews, &warnings)
+	c.LatestItemsExcludes = folders.translate("LatestItemsExcludes", config.LatestItemsExcludes, &warnings)
+	c.MyMediaExcludes = folders.translate("MyMediaExcludes", config.MyMediaExcludes, &warnings)
+
+	return &c, warnings
+}
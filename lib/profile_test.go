@@ -0,0 +1,66 @@
+package gelatin
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTranslateUserProfile(t *testing.T) {
+	fromFolders := []GelatinLibraryItem{
+		{Name: "Movies", Id: "from-movies"},
+		{Name: "Shows", Id: "from-shows"},
+		{Name: "Music", Id: "from-music"},
+	}
+	intoFolders := []GelatinLibraryItem{
+		{Name: "Movies", Id: "into-movies"},
+		{Name: "Shows", Id: "into-shows"},
+	}
+	folders := newFolderMapping(fromFolders, intoFolders)
+
+	t.Run("Policy", func(t *testing.T) {
+		policy := &GelatinUserPolicy{
+			IsAdministrator:          true,
+			EnabledFolders:           []string{"from-movies", "from-music"},
+			BlockedMediaFolders:      []string{"from-shows"},
+			PasswordResetProviderId:  "Jellyfin.Server.Implementations.Users.DefaultPasswordResetProvider",
+			InvalidLoginAttemptCount: 3,
+		}
+
+		want := &GelatinUserPolicy{
+			IsAdministrator:     true,
+			EnabledFolders:      []string{"into-movies"},
+			BlockedMediaFolders: []string{"into-shows"},
+		}
+
+		got, warnings := translateUserPolicy(policy, folders)
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("-want,+got: %s", diff)
+		}
+
+		if len(warnings) != 2 {
+			t.Errorf("expected 2 warnings, got: %q", warnings)
+		}
+	})
+
+	t.Run("Config", func(t *testing.T) {
+		config := &GelatinUserConfig{
+			SubtitleMode: "Always",
+			OrderedViews: []string{"from-shows", "from-movies"},
+		}
+
+		want := &GelatinUserConfig{
+			SubtitleMode: "Always",
+			OrderedViews: []string{"into-shows", "into-movies"},
+		}
+
+		got, warnings := translateUserConfig(config, folders)
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("-want,+got: %s", diff)
+		}
+
+		if len(warnings) != 0 {
+			t.Errorf("unexpected warnings: %q", warnings)
+		}
+	})
+}
@@ -0,0 +1,287 @@
+package gelatin
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// GelatinReportItem identifies a library item in a GelatinMatchReport
+type GelatinReportItem struct {
+	// Service the item belongs to ("from" or "into")
+	Service string
+
+	Id          string
+	Name        string
+	Type        string
+	SeriesName  string            `json:",omitempty"`
+	Path        string            `json:",omitempty"`
+	ProviderIds map[string]string `json:",omitempty"`
+}
+
+func newReportItem(service string, item *GelatinLibraryItem) GelatinReportItem {
+	r := GelatinReportItem{
+		Service:    service,
+		Id:         item.Id,
+		Name:       item.Name,
+		Type:       item.Type,
+		SeriesName: item.SeriesName,
+		Path:       item.Path,
+	}
+
+	// Include the provider IDs that are only set as fields (e.g., in archives)
+	for _, key := range providerKeys(item) {
+		if r.ProviderIds == nil {
+			r.ProviderIds = make(map[string]string)
+		}
+		i := strings.Index(key, "=")
+		r.ProviderIds[key[:i]] = key[i+1:]
+	}
+
+	return r
+}
+
+// providerIds formats the provider IDs of the item as a stable, comma-separated list
+func (r *GelatinReportItem) providerIds() string {
+	ids := make([]string, 0, len(r.ProviderIds))
+	for provider, id := range r.ProviderIds {
+		ids = append(ids, provider+"="+id)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+// GelatinUnmatchedItem is an item with user activity that has no match in the other service
+type GelatinUnmatchedItem struct {
+	Username string
+	Item     GelatinReportItem
+}
+
+// GelatinAmbiguousMatch is an item that matched several items of the other service equally
+// well. The first candidate was used.
+type GelatinAmbiguousMatch struct {
+	Username   string
+	Item       GelatinReportItem
+	Strategy   string
+	Candidates []GelatinReportItem
+}
+
+// GelatinProviderIdConflict is a provider ID shared by several items of the same service
+type GelatinProviderIdConflict struct {
+	// ProviderId is the conflicting ID, as "provider=id"
+	ProviderId string
+	Items      []GelatinReportItem
+}
+
+// GelatinMatchReport collects the library items that could not be matched reliably while
+// planning watch history migrations, so that their metadata can be fixed before re-running.
+//
+// A nil report collects nothing.
+type GelatinMatchReport struct {
+	Unmatched []GelatinUnmatchedItem
+	Ambiguous []GelatinAmbiguousMatch
+	Conflicts []GelatinProviderIdConflict
+
+	// Items of the into service by provider ID, to find conflicts
+	providerIds map[string][]GelatinReportItem
+	seen        map[string]bool
+
+	mu sync.Mutex
+}
+
+// NewMatchReport returns an empty report
+func NewMatchReport() *GelatinMatchReport {
+	return &GelatinMatchReport{
+		providerIds: make(map[string][]GelatinReportItem),
+		seen:        make(map[string]bool),
+	}
+}
+
+func (r *GelatinMatchReport) addUnmatched(username string, item *GelatinLibraryItem) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Unmatched = append(r.Unmatched, GelatinUnmatchedItem{
+		Username: username,
+		Item:     newReportItem("from", item),
+	})
+}
+
+// addMatch records a match if it is ambiguous. service is the service of the matched item;
+// the candidates belong to the other one.
+func (r *GelatinMatchReport) addMatch(username, service string, item *GelatinLibraryItem, match *GelatinMatch) {
+	if r == nil || !match.Ambiguous() {
+		return
+	}
+
+	other := "from"
+	if service == "from" {
+		other = "into"
+	}
+
+	ambiguous := GelatinAmbiguousMatch{
+		Username:   username,
+		Item:       newReportItem(service, item),
+		Strategy:   match.Strategy,
+		Candidates: []GelatinReportItem{newReportItem(other, match.Item)},
+	}
+	for _, candidate := range match.Alternatives {
+		ambiguous.Candidates = append(ambiguous.Candidates, newReportItem(other, candidate))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Ambiguous = append(r.Ambiguous, ambiguous)
+}
+
+// addInto records the provider IDs of an item of the into service. Items are usually seen
+// once for every user, but are only recorded once.
+func (r *GelatinMatchReport) addInto(item *GelatinLibraryItem) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.seen[item.Id] {
+		return
+	}
+	r.seen[item.Id] = true
+
+	for _, key := range providerKeys(item) {
+		key = item.Type + "/" + key
+		r.providerIds[key] = append(r.providerIds[key], newReportItem("into", item))
+	}
+}
+
+// finish computes the provider ID conflicts of the report
+func (r *GelatinMatchReport) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var keys []string
+	for key, items := range r.providerIds {
+		if len(items) > 1 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	r.Conflicts = nil
+	for _, key := range keys {
+		// Keys are prefixed by the item type, which is already in each item
+		providerId := key[strings.Index(key, "/")+1:]
+		r.Conflicts = append(r.Conflicts, GelatinProviderIdConflict{ProviderId: providerId, Items: r.providerIds[key]})
+	}
+}
+
+// Empty returns true if the report has nothing to report
+func (r *GelatinMatchReport) Empty() bool {
+	if r == nil {
+		return true
+	}
+
+	r.finish()
+	return len(r.Unmatched) == 0 && len(r.Ambiguous) == 0 && len(r.Conflicts) == 0
+}
+
+// WriteJSON writes the report as a JSON document
+func (r *GelatinMatchReport) WriteJSON(w io.Writer) error {
+	r.finish()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// reportRow is a single row of a CSV or table report. Related rows (an ambiguous item and its
+// candidates, or items that share a provider ID) have the same group.
+type reportRow struct {
+	kind     string
+	group    int
+	username string
+	detail   string
+	item     *GelatinReportItem
+}
+
+func (r *GelatinMatchReport) rows() []reportRow {
+	r.finish()
+
+	var rows []reportRow
+	group := 0
+
+	for i := range r.Unmatched {
+		u := &r.Unmatched[i]
+		group++
+		rows = append(rows, reportRow{kind: "unmatched", group: group, username: u.Username, item: &u.Item})
+	}
+
+	for i := range r.Ambiguous {
+		a := &r.Ambiguous[i]
+		group++
+		rows = append(rows, reportRow{kind: "ambiguous", group: group, username: a.Username, detail: a.Strategy, item: &a.Item})
+		for j := range a.Candidates {
+			rows = append(rows, reportRow{kind: "candidate", group: group, username: a.Username, detail: a.Strategy, item: &a.Candidates[j]})
+		}
+	}
+
+	for i := range r.Conflicts {
+		c := &r.Conflicts[i]
+		group++
+		for j := range c.Items {
+			rows = append(rows, reportRow{kind: "conflict", group: group, detail: c.ProviderId, item: &c.Items[j]})
+		}
+	}
+
+	return rows
+}
+
+var reportColumns = []string{"kind", "group", "user", "detail", "service", "id", "type", "name", "series", "path", "provider_ids"}
+
+func (row *reportRow) columns() []string {
+	return []string{
+		row.kind, strconv.Itoa(row.group), row.username, row.detail, row.item.Service, row.item.Id,
+		row.item.Type, row.item.Name, row.item.SeriesName, row.item.Path, row.item.providerIds(),
+	}
+}
+
+// WriteCSV writes the report as CSV, with one item per row
+func (r *GelatinMatchReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(reportColumns); err != nil {
+		return err
+	}
+
+	for _, row := range r.rows() {
+		if err := cw.Write(row.columns()); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteTable writes the report as a human-readable table
+func (r *GelatinMatchReport) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(reportColumns, "\t")))
+
+	for _, row := range r.rows() {
+		fmt.Fprintln(tw, strings.Join(row.columns(), "\t"))
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,91 @@
+package gelatin
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMatchReport(t *testing.T) {
+	var nilReport *GelatinMatchReport
+	nilReport.addUnmatched("alice", &GelatinLibraryItem{Id: "ronin"})
+	if !nilReport.Empty() {
+		t.Fatalf("expected a nil report to be empty")
+	}
+
+	into := []GelatinLibraryItem{
+		{Id: "heat", Type: "Movie", Name: "Heat", ProviderIds: map[string]string{"Imdb": "tt0113277"}},
+		{Id: "heat-4k", Type: "Movie", Name: "Heat", ImdbId: "tt0113277"},
+		{Id: "arrival", Type: "Movie", Name: "Arrival", ProviderIds: map[string]string{"Imdb": "tt2543164"}},
+	}
+
+	index := newMatchIndex(nil, nil)
+	report := NewMatchReport()
+	for i := range into {
+		index.add(&into[i])
+		// Items are seen once per user
+		report.addInto(&into[i])
+		report.addInto(&into[i])
+	}
+
+	heat := &GelatinLibraryItem{Id: "from-heat", Type: "Movie", Name: "Heat", ProviderIds: map[string]string{"Imdb": "tt0113277"}}
+	arrival := &GelatinLibraryItem{Id: "from-arrival", Type: "Movie", Name: "Arrival", ProviderIds: map[string]string{"Imdb": "tt2543164"}}
+	for _, item := range []*GelatinLibraryItem{heat, arrival} {
+		match := index.match(item, nil)
+		if match == nil {
+			t.Fatalf("expected a match for %q", item.Id)
+		}
+		report.addMatch("alice", "from", item, match)
+	}
+	report.addUnmatched("alice", &GelatinLibraryItem{Id: "ronin", Type: "Movie", Name: "Ronin"})
+
+	if report.Empty() {
+		t.Fatalf("expected a non-empty report")
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteCSV(&buf); err != nil {
+		t.Fatalf("failed to write CSV: %s", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read CSV: %s", err)
+	}
+
+	// Only keep the kind, group, service and ID of each row
+	var got [][]string
+	for _, record := range records {
+		got = append(got, []string{record[0], record[1], record[4], record[5]})
+	}
+	want := [][]string{
+		{"kind", "group", "service", "id"},
+		{"unmatched", "1", "from", "ronin"},
+		{"ambiguous", "2", "from", "from-heat"},
+		{"candidate", "2", "into", "heat"},
+		{"candidate", "2", "into", "heat-4k"},
+		{"conflict", "3", "into", "heat"},
+		{"conflict", "3", "into", "heat-4k"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("CSV report mismatch (-want +got):\n%s", diff)
+	}
+
+	buf.Reset()
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatalf("failed to write JSON: %s", err)
+	}
+
+	var decoded GelatinMatchReport
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("failed to read JSON: %s", err)
+	}
+	if len(decoded.Conflicts) != 1 || decoded.Conflicts[0].ProviderId != "imdb=tt0113277" {
+		t.Errorf("unexpected conflicts: %+v", decoded.Conflicts)
+	}
+	if len(decoded.Ambiguous) != 1 || decoded.Ambiguous[0].Strategy != GelatinMatchProviderIds {
+		t.Errorf("unexpected ambiguous matches: %+v", decoded.Ambiguous)
+	}
+}
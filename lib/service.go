@@ -1,8 +1,10 @@
 package gelatin
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"time"
 )
 
 type GelatinItemFilterName int
@@ -13,6 +15,13 @@ const (
 	GelatinItemFilterFiltersIsFolder
 	GelatinItemFilterFiltersIsNotFolder
 	GelatinItemFilterParentId
+	GelatinItemFilterIncludeItemTypes
+
+	// Only items whose user data was saved at or after the given RFC 3339 time
+	GelatinItemFilterMinDateLastSavedForUser
+
+	// Comma-separated optional fields to include in each item (e.g., ChildCount)
+	GelatinItemFilterFields
 )
 
 type GelatinSystemLog struct {
@@ -121,13 +130,24 @@ type GelatinLibraryItemUserActivity struct {
 	PlaybackPositionTicks int64
 	PlayCount             int32
 	IsFavorite            bool
-	LastPlayedDate        string
+	LastPlayedDate        string `json:",omitempty"`
 	Played                bool
-	Rating                float64
+	Rating                float64 // Star rating given by the user, out of 10
+	Likes                 *bool   `json:",omitempty"` // Thumbs up or down (nil if not rated)
 	UnplayedItemCount     int32   // For series
 	PlayedPercentage      float64 // For series
 }
 
+// likes formats the Likes field, which is nil if the user did not rate the item
+func (i *GelatinLibraryItemUserActivity) likes() string {
+	if i.Likes == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%v", *i.Likes)
+}
+
+// IsMatch returns true if both activities are the same. Last played dates are compared to
+// the second, since servers store them with different precisions.
 func (i *GelatinLibraryItemUserActivity) IsMatch(other *GelatinLibraryItemUserActivity) bool {
 	if i.IsFavorite != other.IsFavorite {
 		return false
@@ -144,6 +164,15 @@ func (i *GelatinLibraryItemUserActivity) IsMatch(other *GelatinLibraryItemUserAc
 	if i.PlayedPercentage != other.PlayedPercentage {
 		return false
 	}
+	if i.PlayCount != other.PlayCount {
+		return false
+	}
+	if i.likes() != other.likes() {
+		return false
+	}
+	if !lastPlayed(i).Truncate(time.Second).Equal(lastPlayed(other).Truncate(time.Second)) {
+		return false
+	}
 
 	return true
 }
@@ -154,14 +183,18 @@ func (i *GelatinLibraryItemUserActivity) IsMatch(other *GelatinLibraryItemUserAc
 // This is by design: we are only interested in getting and updating a few fields for
 // a given library item.
 type GelatinLibraryItem struct {
-	Name         string
-	ServerId     string
-	Id           string
-	RunTimeTicks int64
-	IsFolder     bool
-	Type         string // Movie, Series, Season, Episode, etc.
-	UserData     *GelatinLibraryItemUserActivity
-	MediaType    string // Video, Photo, etc.
+	Name           string
+	ServerId       string
+	Id             string
+	ParentId       string // ID of the folder, series or season that contains the item
+	RunTimeTicks   int64
+	Path           string // Path of the media file on the server (if requested)
+	ProductionYear int32
+	IsFolder       bool
+	Type           string // Movie, Series, Season, Episode, etc.
+	UserData       *GelatinLibraryItemUserActivity
+	MediaType      string // Video, Photo, etc.
+	PlaylistItemId string // ID of the playlist entry (only for items of a playlist)
 
 	ProviderIds map[string]string
 	ImdbId      string
@@ -175,63 +208,70 @@ type GelatinLibraryItem struct {
 	EpisodeNumber     int32
 	IndexNumber       int32
 	ParentIndexNumber int32
+	ChildCount        int32 // Number of episodes in a season (if requested)
 }
 
 type GelatinSystemService interface {
 	// Version returns the version string
-	Version() (string, error)
+	Version(ctx context.Context) (string, error)
 
 	// Ping pings the server (i.e., health check)
-	Ping() error
+	Ping(ctx context.Context) error
 
 	// GetLogs returns all logs exposed by the server
-	GetLogs() ([]GelatinSystemLog, error)
+	GetLogs(ctx context.Context) ([]GelatinSystemLog, error)
 
 	// GetLogFile downloads the content of a single log file
-	GetLogFile(name string) (io.ReadCloser, error)
+	GetLogFile(ctx context.Context, name string) (io.ReadCloser, error)
 
 	// Info returns information about the server
 	//
 	// If "public" is true, this returns only publicly visible system info.
-	Info(public bool) (*GelatinSystemInfo, error)
+	Info(ctx context.Context, public bool) (*GelatinSystemInfo, error)
 }
 
 type GelatinUserService interface {
 	// GetUser returns the user with the specified ID
-	GetUser(id string) (*GelatinUser, error)
+	GetUser(ctx context.Context, id string) (*GelatinUser, error)
 
 	// GetUsers returns all configured users
 	//
 	// If "public" is true, returns only the publicly visible users.
-	GetUsers(public bool) ([]GelatinUser, error)
+	GetUsers(ctx context.Context, public bool) ([]GelatinUser, error)
 
 	// UpdateUser updates a single user
 	//
 	// Note that user state is _overwritten_. Use this in conjunction with
 	// GetUser().
-	UpdateUser(id string, data *GelatinUser) error
+	UpdateUser(ctx context.Context, id string, data *GelatinUser) error
 
 	// CreateUser creates a new user with the given username
-	CreateUser(name string) (*GelatinUser, error)
+	CreateUser(ctx context.Context, name string) (*GelatinUser, error)
 
 	// DeleteUser deletes the user with the specified ID
-	DeleteUser(id string) error
+	DeleteUser(ctx context.Context, id string) error
 
 	// UpdatePassword updates the given user's password
 	//
 	// If "reset" is true, the password will be reset first.
-	UpdatePassword(id, currentPassword, newPassword string, reset bool) error
+	UpdatePassword(ctx context.Context, id, currentPassword, newPassword string, reset bool) error
 
 	// Authenticate as a specific user
 	//
 	// Use this method with an admin account to create an AdminKey.
-	Authenticate(username, password string) (key ApiKey, err error)
+	Authenticate(ctx context.Context, username, password string) (key ApiKey, err error)
 
 	// UpdatePolicy updates the policy for the specified user.
 	//
 	// Note that user state is _overwritten_. Use this in conjunction with
 	// GetUser().
-	UpdatePolicy(id string, policy *GelatinUserPolicy) error
+	UpdatePolicy(ctx context.Context, id string, policy *GelatinUserPolicy) error
+
+	// UpdateConfiguration updates the configuration (i.e., preferences) for the specified user.
+	//
+	// Note that user state is _overwritten_. Use this in conjunction with
+	// GetUser().
+	UpdateConfiguration(ctx context.Context, id string, config *GelatinUserConfig) error
 }
 
 type GelatinLibraryService interface {
@@ -239,29 +279,91 @@ type GelatinLibraryService interface {
 	//
 	// If `recursive` is true, the search will recurse through library folders.
 	//
+	// Items are fetched a page at a time to keep each request short, but all pages are
+	// returned at once.
+	//
 	// Refer to Emby or Jellyfin docs for available item filters.
-	GetItems(filters map[string]string, recursive bool) ([]GelatinLibraryItem, error)
+	GetItems(ctx context.Context, filters map[string]string, recursive bool) ([]GelatinLibraryItem, error)
 
 	// GetItemsByUser returns library items for a _specific_ user (i.e., with user activity attached)
 	//
 	// Refer to Emby or Jellyfin docs for available item filters.
-	GetItemsByUser(id string, filters map[string]string) ([]GelatinLibraryItem, error)
+	GetItemsByUser(ctx context.Context, id string, filters map[string]string) ([]GelatinLibraryItem, error)
+
+	// WalkItems is like GetItems, but fetches items a page at a time and calls fn with each
+	// page, so that large libraries do not have to be held in memory.
+	WalkItems(ctx context.Context, filters map[string]string, recursive bool, fn GelatinItemPageFunc) error
+
+	// WalkItemsByUser is like GetItemsByUser, but calls fn with each page of items
+	WalkItemsByUser(ctx context.Context, id string, filters map[string]string, fn GelatinItemPageFunc) error
 
 	// UpdateItem updates the given item
 	//
 	// Note that this does not modify item user data!
-	UpdateItem(itemId string, item *GelatinLibraryItem) error
+	UpdateItem(ctx context.Context, itemId string, item *GelatinLibraryItem) error
 
 	// UpdateItemUserData updates the user data for the given item
-	UpdateItemUserActivity(itemId string, userId string, old, new *GelatinLibraryItemUserActivity) error
+	UpdateItemUserActivity(ctx context.Context, itemId string, userId string, old, new *GelatinLibraryItemUserActivity) error
 
 	// GetItemFilterString returns the string representation of the given filter
 	GetItemFilterString(filter GelatinItemFilterName) string
+
+	// GetMediaFolders returns the top-level library folders (e.g., Movies, TV Shows)
+	GetMediaFolders(ctx context.Context) ([]GelatinLibraryItem, error)
 }
 
+// GelatinPlaylistService manages user playlists.
+//
+// Playlists are returned as library items of type "Playlist". Items of a playlist carry a
+// PlaylistItemId, which identifies their entry in the playlist: an item can be added to a
+// playlist more than once, so entries (and not items) are removed or moved.
 type GelatinPlaylistService interface {
+	// GetPlaylists returns the playlists of the specified user
+	GetPlaylists(ctx context.Context, userId string) ([]GelatinLibraryItem, error)
+
+	// GetPlaylistItems returns the items of a playlist, in playlist order
+	GetPlaylistItems(ctx context.Context, playlistId, userId string) ([]GelatinLibraryItem, error)
+
+	// CreatePlaylist creates a playlist owned by the specified user and returns its ID
+	//
+	// The media type (e.g., Video or Audio) can be left empty if items are given.
+	CreatePlaylist(ctx context.Context, userId, name, mediaType string, itemIds []string) (string, error)
+
+	// AddPlaylistItems appends items to a playlist
+	AddPlaylistItems(ctx context.Context, playlistId, userId string, itemIds []string) error
+
+	// RemovePlaylistItems removes entries (see PlaylistItemId) from a playlist
+	RemovePlaylistItems(ctx context.Context, playlistId string, entryIds []string) error
+
+	// MovePlaylistItem moves an entry (see PlaylistItemId) of a playlist to a new 0-based index
+	MovePlaylistItem(ctx context.Context, playlistId, entryId string, index int) error
+}
+
+// GelatinCollectionService manages collections (box sets) of library items.
+//
+// Collections are shared by all users, and are returned as library items of type "BoxSet".
+type GelatinCollectionService interface {
+	// GetCollections returns all collections
+	GetCollections(ctx context.Context) ([]GelatinLibraryItem, error)
+
+	// GetCollectionItems returns the items of a collection
+	GetCollectionItems(ctx context.Context, collectionId string) ([]GelatinLibraryItem, error)
+
+	// CreateCollection creates a collection with the given items and returns its ID
+	CreateCollection(ctx context.Context, name string, itemIds []string) (string, error)
+
+	// AddCollectionItems adds items to a collection
+	AddCollectionItems(ctx context.Context, collectionId string, itemIds []string) error
+
+	// RemoveCollectionItems removes items from a collection
+	RemoveCollectionItems(ctx context.Context, collectionId string, itemIds []string) error
 }
 
+// GelatinService is a media server that gelatin can import data from or export data into.
+//
+// Every method that talks to the server takes a context, which bounds the underlying
+// HTTP requests (e.g., for cancellation or deadlines) and carries request-scoped values
+// such as the trace ID set by WithTraceId.
 type GelatinService interface {
 	// ApiKey returns the current API key used by the client
 	ApiKey() ApiKey
@@ -273,19 +375,47 @@ type GelatinService interface {
 	User() GelatinUserService
 	Library() GelatinLibraryService
 	Playlist() GelatinPlaylistService
+	Collection() GelatinCollectionService
+}
+
+// GelatinHttpService is a GelatinService that talks to a server over HTTP (e.g., Emby or
+// Jellyfin), whose requests can be tuned.
+type GelatinHttpService interface {
+	GelatinService
+
+	// SetRateLimit limits the number of requests per second sent to the server. A rate of 0
+	// or less removes the limit.
+	SetRateLimit(requestsPerSecond float64)
+
+	// SetPageSize sets the number of library items requested at a time. A size of 0 or less
+	// uses DefaultPageSize.
+	SetPageSize(size int)
+
+	// SetRetryPolicy sets how requests that fail with a transient error are retried. A nil
+	// policy disables retries.
+	SetRetryPolicy(policy *RetryPolicy)
+}
+
+// Finds a user by name in the given list. Returns nil if the user is not found.
+func findUserByName(users []GelatinUser, username string) *GelatinUser {
+	for i := range users {
+		if users[i].Name == username {
+			return &users[i]
+		}
+	}
+
+	return nil
 }
 
 // Gets a user by name from the given service
-func getUserByName(s GelatinService, username string) (*GelatinUser, error) {
-	users, err := s.User().GetUsers(false)
+func getUserByName(ctx context.Context, s GelatinService, username string) (*GelatinUser, error) {
+	users, err := s.User().GetUsers(ctx, false)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, user := range users {
-		if user.Name == username {
-			return &user, nil
-		}
+	if user := findUserByName(users, username); user != nil {
+		return user, nil
 	}
 
 	return nil, fmt.Errorf("user %q not found", username)
@@ -0,0 +1,121 @@
+package gelatin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// GelatinSyncState is the state of a continuous sync (or of incremental watch history
+// migrations), persisted between passes (and restarts) so that progress can be reported
+// across runs
+type GelatinSyncState struct {
+	// LastRun is the time the last sync pass started
+	LastRun time.Time
+
+	// LastFullRun is the time the last successful full (non-incremental) sync pass started
+	LastFullRun time.Time `json:",omitempty"`
+
+	// Users maps "from" usernames to their sync state
+	Users map[string]*GelatinSyncUserState
+}
+
+// GelatinSyncUserState is the sync state of a single user
+type GelatinSyncUserState struct {
+	// LastSync is the time the last successful sync of the user started. It is the high-water
+	// mark for the next incremental sync of the user.
+	LastSync time.Time `json:",omitempty"`
+
+	// LastFullSync is the time the last successful full (non-incremental) migration of the
+	// user started (incremental watch history migrations only)
+	LastFullSync time.Time `json:",omitempty"`
+
+	// Total number of items updated in each service across all sync passes
+	Updated     int
+	UpdatedFrom int
+
+	// LastError is the error of the last sync pass, if it failed
+	LastError string `json:",omitempty"`
+}
+
+// LoadSyncState reads the sync state at path. If the file does not exist, an empty state
+// is returned.
+func LoadSyncState(path string) (*GelatinSyncState, error) {
+	state := &GelatinSyncState{Users: make(map[string]*GelatinSyncUserState)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to read sync state %s: %w", path, err)
+	}
+	if state.Users == nil {
+		state.Users = make(map[string]*GelatinSyncUserState)
+	}
+
+	return state, nil
+}
+
+// Save writes the sync state to path. The state is written to a temporary file first, so
+// that an interrupted write never leaves a corrupt state behind.
+func (s *GelatinSyncState) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Since returns the high-water marks of all users, for an incremental sync
+// (see GelatinWatchHistoryOpts.Since)
+func (s *GelatinSyncState) Since() map[string]time.Time {
+	since := make(map[string]time.Time)
+	for username, user := range s.Users {
+		if !user.LastSync.IsZero() {
+			since[username] = user.LastSync
+		}
+	}
+	return since
+}
+
+// Update records the result of a sync pass that started at the given time
+func (s *GelatinSyncState) Update(start time.Time, summaries []GelatinWatchHistorySummary) {
+	s.LastRun = start
+
+	for _, summary := range summaries {
+		user, ok := s.Users[summary.Username]
+		if !ok {
+			user = &GelatinSyncUserState{}
+			s.Users[summary.Username] = user
+		}
+
+		user.Updated += summary.Updated
+		user.UpdatedFrom += summary.UpdatedFrom
+
+		if summary.Err != nil {
+			user.LastError = summary.Err.Error()
+			continue
+		}
+
+		user.LastSync = start
+		user.LastError = ""
+	}
+}
@@ -0,0 +1,61 @@
+package gelatin
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSyncState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "sync-state.json")
+
+	state, err := LoadSyncState(path)
+	if err != nil {
+		t.Fatalf("failed to load missing state: %s", err)
+	}
+	if len(state.Users) != 0 {
+		t.Fatalf("expected an empty state, got: %+v", state)
+	}
+
+	first := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	state.Update(first, []GelatinWatchHistorySummary{
+		{Username: "alice", Updated: 2, UpdatedFrom: 1},
+		{Username: "bob", Updated: 1},
+	})
+
+	second := first.Add(15 * time.Minute)
+	state.Update(second, []GelatinWatchHistorySummary{
+		{Username: "alice", Updated: 1},
+		{Username: "bob", Err: errors.New("connection reset")},
+	})
+
+	if err := state.Save(path); err != nil {
+		t.Fatalf("failed to save state: %s", err)
+	}
+
+	got, err := LoadSyncState(path)
+	if err != nil {
+		t.Fatalf("failed to load state: %s", err)
+	}
+
+	want := &GelatinSyncState{
+		LastRun: second,
+		Users: map[string]*GelatinSyncUserState{
+			"alice": {LastSync: second, Updated: 3, UpdatedFrom: 1},
+			"bob":   {LastSync: first, Updated: 1, LastError: "connection reset"},
+		},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("-want,+got: %s", diff)
+	}
+
+	// A failed sync does not move the high-water mark
+	wantSince := map[string]time.Time{"alice": second, "bob": first}
+	if diff := cmp.Diff(wantSince, got.Since()); diff != "" {
+		t.Errorf("-want,+got: %s", diff)
+	}
+}
@@ -0,0 +1,173 @@
+package gelatin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GelatinConflictPolicy decides which side wins when the user activity of an item differs
+// between the two services during a two-way sync
+type GelatinConflictPolicy string
+
+const (
+	// The side that was played most recently wins (default)
+	GelatinConflictNewest GelatinConflictPolicy = "newest"
+
+	// The side with the furthest playback progress wins; a played item is fully watched
+	GelatinConflictProgress GelatinConflictPolicy = "progress"
+
+	// A played side wins over an unplayed one
+	GelatinConflictPlayed GelatinConflictPolicy = "played"
+
+	// The "from" service always wins, as in a one-way migration
+	GelatinConflictSource GelatinConflictPolicy = "source"
+)
+
+// ParseConflictPolicy parses a conflict policy string. An empty string maps to
+// GelatinConflictNewest.
+func ParseConflictPolicy(s string) (GelatinConflictPolicy, error) {
+	switch p := GelatinConflictPolicy(strings.ToLower(s)); p {
+	case "":
+		return GelatinConflictNewest, nil
+	case GelatinConflictNewest, GelatinConflictProgress, GelatinConflictPlayed, GelatinConflictSource:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid conflict policy: %q", s)
+	}
+}
+
+// lastPlayed parses the LastPlayedDate of an item. Items that were never played (or have
+// a date that cannot be parsed) return the zero time.
+func lastPlayed(data *GelatinLibraryItemUserActivity) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, data.LastPlayedDate)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// progress returns how far into an item the user is. A played item is fully watched.
+func progress(data *GelatinLibraryItemUserActivity, runTimeTicks int64) int64 {
+	if data.Played {
+		if runTimeTicks > data.PlaybackPositionTicks {
+			return runTimeTicks
+		}
+		// Items without a known runtime: anything played is ahead of any position
+		return 1<<63 - 1
+	}
+	return data.PlaybackPositionTicks
+}
+
+// fromWins resolves a conflict between the user activity of an item in the "from" and
+// "into" services, and returns true if the from side wins.
+//
+// Ties fall back to the next rule, in order: newest, then higher play count, then the
+// from service.
+func (p GelatinConflictPolicy) fromWins(from, into *GelatinLibraryItem) bool {
+	f, i := from.UserData, into.UserData
+
+	// Marking a series or season unplayed would mark all of its episodes unplayed, so
+	// containers only ever move towards played
+	if from.Type == "Series" || from.Type == "Season" {
+		p = GelatinConflictPlayed
+	}
+
+	switch p {
+	case GelatinConflictSource:
+		return true
+	case GelatinConflictPlayed:
+		if f.Played != i.Played {
+			return f.Played
+		}
+	case GelatinConflictProgress:
+		fp, ip := progress(f, from.RunTimeTicks), progress(i, into.RunTimeTicks)
+		if fp != ip {
+			return fp > ip
+		}
+	}
+
+	if ft, it := lastPlayed(f), lastPlayed(i); !ft.Equal(it) {
+		return ft.After(it)
+	}
+
+	if f.PlayCount != i.PlayCount {
+		return f.PlayCount > i.PlayCount
+	}
+
+	return true
+}
+
+// SyncUserWatchHistory syncs a user's watch history in both directions.
+//
+// See PlanSyncUserWatchHistory for details.
+func (c *GelatinClient) SyncUserWatchHistory(ctx context.Context, username string, policy GelatinConflictPolicy) error {
+	plan, err := c.PlanSyncUserWatchHistory(ctx, username, policy)
+	if err != nil {
+		return err
+	}
+
+	return c.Apply(ctx, plan)
+}
+
+// PlanSyncUserWatchHistory computes the operations needed to sync a user's watch history in
+// both directions.
+//
+// Items are matched as in PlanMigrateUserWatchHistory. When the user activity of a matched
+// item differs, the conflict policy picks a winner and the other service is updated to match
+// it. Series and seasons are only ever marked played, never unplayed.
+//
+// If the user does not exist in either service, this method returns an error.
+func (c *GelatinClient) PlanSyncUserWatchHistory(ctx context.Context, username string, policy GelatinConflictPolicy) (*GelatinPlan, error) {
+	if policy == "" {
+		policy = GelatinConflictNewest
+	}
+
+	fromUser, err := getUserByName(ctx, c.from, username)
+	if err != nil {
+		return nil, err
+	}
+
+	intoUser, err := getUserByName(ctx, c.into, username)
+	if err != nil {
+		return nil, err
+	}
+
+	fromIndex, intoIndex, err := c.libraryIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, _, err := c.planUserWatchHistory(ctx, fromUser, intoUser, fromIndex, intoIndex, policy)
+
+	return plan, err
+}
+
+// SyncAllWatchHistory syncs the watch history of every user present in both services in both
+// directions. See MigrateAllWatchHistory and PlanSyncUserWatchHistory.
+func (c *GelatinClient) SyncAllWatchHistory(ctx context.Context, opts *GelatinWatchHistoryOpts, policy GelatinConflictPolicy) ([]GelatinWatchHistorySummary, error) {
+	if policy == "" {
+		policy = GelatinConflictNewest
+	}
+
+	return c.eachUserWatchHistory(ctx, opts, policy, func(plan *GelatinPlan, summary *GelatinWatchHistorySummary) error {
+		return c.applyUserWatchHistory(ctx, plan, summary)
+	})
+}
+
+// PlanSyncAllWatchHistory computes the operations needed to sync the watch history of every
+// user present in both services in both directions. See SyncAllWatchHistory.
+func (c *GelatinClient) PlanSyncAllWatchHistory(ctx context.Context, opts *GelatinWatchHistoryOpts, policy GelatinConflictPolicy) (*GelatinPlan, []GelatinWatchHistorySummary, error) {
+	if policy == "" {
+		policy = GelatinConflictNewest
+	}
+
+	plan := &GelatinPlan{}
+	summaries, err := c.eachUserWatchHistory(ctx, opts, policy, func(userPlan *GelatinPlan, _ *GelatinWatchHistorySummary) error {
+		plan.Merge(userPlan)
+		return nil
+	})
+
+	return plan, summaries, err
+}
@@ -0,0 +1,141 @@
+package gelatin
+
+import "testing"
+
+func TestConflictPolicy(t *testing.T) {
+	movie := func(data GelatinLibraryItemUserActivity) *GelatinLibraryItem {
+		return &GelatinLibraryItem{Type: "Movie", RunTimeTicks: 1000, UserData: &data}
+	}
+
+	older := "2023-01-01T10:00:00.0000000Z"
+	newer := "2023-06-01T10:00:00.0000000Z"
+
+	testCases := []struct {
+		name     string
+		policy   GelatinConflictPolicy
+		from     *GelatinLibraryItem
+		into     *GelatinLibraryItem
+		fromWins bool
+	}{
+		{
+			name:     "newest",
+			policy:   GelatinConflictNewest,
+			from:     movie(GelatinLibraryItemUserActivity{PlaybackPositionTicks: 100, LastPlayedDate: older}),
+			into:     movie(GelatinLibraryItemUserActivity{PlaybackPositionTicks: 50, LastPlayedDate: newer}),
+			fromWins: false,
+		},
+		{
+			name:     "progress",
+			policy:   GelatinConflictProgress,
+			from:     movie(GelatinLibraryItemUserActivity{PlaybackPositionTicks: 100, LastPlayedDate: older}),
+			into:     movie(GelatinLibraryItemUserActivity{PlaybackPositionTicks: 50, LastPlayedDate: newer}),
+			fromWins: true,
+		},
+		{
+			name:     "progress of a played item",
+			policy:   GelatinConflictProgress,
+			from:     movie(GelatinLibraryItemUserActivity{PlaybackPositionTicks: 900}),
+			into:     movie(GelatinLibraryItemUserActivity{Played: true}),
+			fromWins: false,
+		},
+		{
+			name:     "played",
+			policy:   GelatinConflictPlayed,
+			from:     movie(GelatinLibraryItemUserActivity{LastPlayedDate: newer}),
+			into:     movie(GelatinLibraryItemUserActivity{Played: true, LastPlayedDate: older}),
+			fromWins: false,
+		},
+		{
+			name:     "source",
+			policy:   GelatinConflictSource,
+			from:     movie(GelatinLibraryItemUserActivity{LastPlayedDate: older}),
+			into:     movie(GelatinLibraryItemUserActivity{Played: true, LastPlayedDate: newer}),
+			fromWins: true,
+		},
+		{
+			name:     "tie broken by play count",
+			policy:   GelatinConflictNewest,
+			from:     movie(GelatinLibraryItemUserActivity{PlayCount: 1}),
+			into:     movie(GelatinLibraryItemUserActivity{PlayCount: 2}),
+			fromWins: false,
+		},
+		{
+			name:     "containers are never marked unplayed",
+			policy:   GelatinConflictNewest,
+			from:     &GelatinLibraryItem{Type: "Series", UserData: &GelatinLibraryItemUserActivity{Played: true, LastPlayedDate: older}},
+			into:     &GelatinLibraryItem{Type: "Series", UserData: &GelatinLibraryItemUserActivity{LastPlayedDate: newer}},
+			fromWins: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.policy.fromWins(tc.from, tc.into); got != tc.fromWins {
+				t.Errorf("expected fromWins = %t, got %t", tc.fromWins, got)
+			}
+		})
+	}
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	if p, err := ParseConflictPolicy(""); err != nil || p != GelatinConflictNewest {
+		t.Errorf("expected the default policy, got %q, %v", p, err)
+	}
+	if p, err := ParseConflictPolicy("Progress"); err != nil || p != GelatinConflictProgress {
+		t.Errorf("expected %q, got %q, %v", GelatinConflictProgress, p, err)
+	}
+	if _, err := ParseConflictPolicy("oldest"); err == nil {
+		t.Errorf("expected an error for an invalid policy")
+	}
+}
+
+func TestUserActivityIsMatch(t *testing.T) {
+	yes, no := true, false
+
+	testCases := []struct {
+		name  string
+		a, b  GelatinLibraryItemUserActivity
+		match bool
+	}{
+		{
+			name:  "last played dates at different precisions",
+			a:     GelatinLibraryItemUserActivity{LastPlayedDate: "2023-06-01T10:00:00.1234567Z"},
+			b:     GelatinLibraryItemUserActivity{LastPlayedDate: "2023-06-01T10:00:00Z"},
+			match: true,
+		},
+		{
+			name: "last played dates",
+			a:    GelatinLibraryItemUserActivity{LastPlayedDate: "2023-06-01T10:00:00Z"},
+			b:    GelatinLibraryItemUserActivity{LastPlayedDate: "2023-06-02T10:00:00Z"},
+		},
+		{
+			name: "play counts",
+			a:    GelatinLibraryItemUserActivity{PlayCount: 1},
+			b:    GelatinLibraryItemUserActivity{PlayCount: 2},
+		},
+		{
+			name: "likes",
+			a:    GelatinLibraryItemUserActivity{Likes: &yes},
+			b:    GelatinLibraryItemUserActivity{Likes: &no},
+		},
+		{
+			name: "unrated",
+			a:    GelatinLibraryItemUserActivity{Likes: &no},
+			b:    GelatinLibraryItemUserActivity{},
+		},
+		{
+			name:  "same likes",
+			a:     GelatinLibraryItemUserActivity{Likes: &yes},
+			b:     GelatinLibraryItemUserActivity{Likes: &yes},
+			match: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.a.IsMatch(&tc.b); got != tc.match {
+				t.Errorf("expected IsMatch = %t, got %t", tc.match, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package gelatin
+
+import (
+	"context"
+	"log"
+)
+
+// PlanUndo computes the operations needed to revert the changes recorded in the journal
+// of a migration run.
+//
+// Operations are reverted in reverse order:
+//
+// - User activity updates are reverted to the activity the item had before the run.
+// - Playlist and collection updates are reverted to the items they had before the run.
+// - Users created by the run are deleted, which also discards any other change made to them.
+//
+// Other changes (e.g., to the policy of an existing user or a deleted user) cannot be
+// reverted, since the journal does not hold the prior state. They are reported as warnings.
+func (c *GelatinClient) PlanUndo(ctx context.Context, entries []GelatinJournalEntry) (*GelatinPlan, error) {
+	intoUsers, err := c.into.User().GetUsers(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Users created by the run, by ID
+	created := make(map[string]bool)
+	for _, entry := range entries {
+		if op := entry.Operation; op != nil && op.Type == GelatinOperationCreateUser && op.UserId != "" {
+			created[op.UserId] = true
+		}
+	}
+
+	plan := &GelatinPlan{}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		op := entries[i].Operation
+		if op == nil {
+			continue
+		}
+
+		switch op.Type {
+		case GelatinOperationCreateUser:
+			// Only delete the user if it was not since deleted or replaced
+			user := findUserByName(intoUsers, op.Username)
+			if user == nil || user.Id != op.UserId {
+				log.Printf("warning: %s: user created by the run no longer exists", op.Username)
+				continue
+			}
+
+			plan.add(GelatinOperation{
+				Type:     GelatinOperationDeleteUser,
+				Username: op.Username,
+				UserId:   op.UserId,
+			})
+		case GelatinOperationUpdateUserActivity:
+			if created[op.UserId] {
+				// Reverted by deleting the user
+				continue
+			}
+
+			if op.Old == nil || op.New == nil {
+				log.Printf("warning: %s: cannot revert activity for %q without its prior state", op.Username, op.ItemName)
+				continue
+			}
+
+			undo := *op
+			undo.Old, undo.New = op.New, op.Old
+			undo.Warnings = nil
+			plan.add(undo)
+		case GelatinOperationUpdatePlaylist:
+			if created[op.UserId] {
+				// Reverted by deleting the user
+				continue
+			}
+
+			undo := *op
+			undo.OldPlaylistItemIds, undo.PlaylistItemIds = op.PlaylistItemIds, op.OldPlaylistItemIds
+			undo.Warnings = nil
+			plan.add(undo)
+		case GelatinOperationUpdateCollection:
+			undo := *op
+			undo.OldCollectionItemIds, undo.CollectionItemIds = op.CollectionItemIds, op.OldCollectionItemIds
+			undo.Warnings = nil
+			plan.add(undo)
+		case GelatinOperationCreateCollection:
+			log.Printf("warning: cannot undo %s %q", op.Type, op.CollectionName)
+		case GelatinOperationUpdateUserPolicy, GelatinOperationUpdateUserConfig, GelatinOperationSetUserPassword:
+			if user := findUserByName(intoUsers, op.Username); user != nil && created[user.Id] {
+				// Reverted by deleting the user
+				continue
+			}
+
+			log.Printf("warning: %s: cannot undo %s", op.Username, op.Type)
+		default:
+			log.Printf("warning: %s: cannot undo %s", op.Username, op.Type)
+		}
+	}
+
+	return plan, nil
+}
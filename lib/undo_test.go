@@ -0,0 +1,91 @@
+package gelatin_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aksiksi/gelatin/jellyfin"
+	gelatin "github.com/aksiksi/gelatin/lib"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPlanUndo(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Write([]byte(`[
+			{"Name": "admin", "Id": "admin-id"},
+			{"Name": "alice", "Id": "alice-id"},
+			{"Name": "bob", "Id": "bob-id"}
+		]`))
+	}))
+	defer srv.Close()
+
+	into := jellyfin.NewJellyfinApiClient(srv.URL, jellyfin.NewApiKey("test123"))
+	client := gelatin.NewGelatinClient(into, into, nil)
+
+	unplayed := &gelatin.GelatinLibraryItemUserActivity{PlaybackPositionTicks: 100}
+	played := &gelatin.GelatinLibraryItemUserActivity{Played: true}
+
+	entries := []gelatin.GelatinJournalEntry{
+		{Operation: &gelatin.GelatinOperation{Type: gelatin.GelatinOperationCreateUser, Username: "bob", UserId: "bob-id"}},
+		{Operation: &gelatin.GelatinOperation{Type: gelatin.GelatinOperationUpdateUserPolicy, Username: "bob", Policy: &gelatin.GelatinUserPolicy{}}},
+		{Operation: &gelatin.GelatinOperation{Type: gelatin.GelatinOperationDeleteUser, Username: "carol", UserId: "carol-id"}},
+		{Operation: &gelatin.GelatinOperation{
+			Type:     gelatin.GelatinOperationUpdateUserActivity,
+			Username: "alice",
+			UserId:   "alice-id",
+			ItemId:   "item-1",
+			Old:      unplayed,
+			New:      played,
+		}},
+		{Operation: &gelatin.GelatinOperation{
+			Type:     gelatin.GelatinOperationUpdateUserActivity,
+			Username: "bob",
+			UserId:   "bob-id",
+			ItemId:   "item-1",
+			Old:      unplayed,
+			New:      played,
+		}},
+		{Checkpoint: "watch:alice"},
+		{Operation: &gelatin.GelatinOperation{
+			Type:               gelatin.GelatinOperationUpdatePlaylist,
+			Username:           "alice",
+			UserId:             "alice-id",
+			PlaylistId:         "playlist-1",
+			PlaylistName:       "Heists",
+			OldPlaylistItemIds: []string{"item-2"},
+			PlaylistItemIds:    []string{"item-1", "item-2"},
+		}},
+	}
+
+	plan, err := client.PlanUndo(context.Background(), entries)
+	if err != nil {
+		t.Fatalf("failed to plan undo: %s", err)
+	}
+
+	want := []gelatin.GelatinOperation{
+		{
+			Type:               gelatin.GelatinOperationUpdatePlaylist,
+			Username:           "alice",
+			UserId:             "alice-id",
+			PlaylistId:         "playlist-1",
+			PlaylistName:       "Heists",
+			OldPlaylistItemIds: []string{"item-1", "item-2"},
+			PlaylistItemIds:    []string{"item-2"},
+		},
+		{
+			Type:     gelatin.GelatinOperationUpdateUserActivity,
+			Username: "alice",
+			UserId:   "alice-id",
+			ItemId:   "item-1",
+			Old:      played,
+			New:      unplayed,
+		},
+		{Type: gelatin.GelatinOperationDeleteUser, Username: "bob", UserId: "bob-id"},
+	}
+
+	if diff := cmp.Diff(want, plan.Operations); diff != "" {
+		t.Errorf("-want,+got: %s", diff)
+	}
+}
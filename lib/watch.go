@@ -0,0 +1,579 @@
+package gelatin
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// handleSeries recursively collects the items of a series that need to be matched.
+//
+// It works like this:
+//
+// a. If the series is fully played, collect the series itself and skip the remaining steps
+// b. Run a second query for the series' seasons (children)
+// c. For each season, check if it is fully played; if it is, collect the season and return
+// d. If the current season is not fully played, walk through each episode of the season and collect it
+func handleSeries(ctx context.Context, svc GelatinLibraryService, item *GelatinLibraryItem, userId string, items *[]*GelatinLibraryItem) error {
+	switch item.Type {
+	case "Series", "Season":
+		if item.UserData.Played && item.UserData.PlayedPercentage == 100 {
+			// The series or season is fully played, so just add an entry for it
+			*items = append(*items, item)
+			return nil
+		}
+
+		// These could either be episodes or seasons
+		children, err := svc.GetItemsByUser(ctx, userId, map[string]string{
+			svc.GetItemFilterString(GelatinItemFilterParentId): item.Id,
+		})
+		if err != nil {
+			return err
+		}
+
+		for i := range children {
+			if err := handleSeries(ctx, svc, &children[i], userId, items); err != nil {
+				return err
+			}
+		}
+	case "Episode":
+		*items = append(*items, item)
+	}
+
+	return nil
+}
+
+// libraryIndex holds state of a library that is shared across users
+type libraryIndex struct {
+	// series maps a series ID to the series
+	series map[string]*GelatinLibraryItem
+
+	// seasons maps a series ID to its seasons by season number
+	seasons map[string]map[int32]*GelatinLibraryItem
+}
+
+func newLibraryIndex(ctx context.Context, svc GelatinLibraryService) (*libraryIndex, error) {
+	items, err := svc.GetItems(ctx, map[string]string{
+		svc.GetItemFilterString(GelatinItemFilterIncludeItemTypes): "Series,Season",
+		svc.GetItemFilterString(GelatinItemFilterFields):           "ChildCount",
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	index := &libraryIndex{
+		series:  make(map[string]*GelatinLibraryItem),
+		seasons: make(map[string]map[int32]*GelatinLibraryItem),
+	}
+
+	for i := range items {
+		item := &items[i]
+
+		switch item.Type {
+		case "Series":
+			index.series[item.Id] = item
+		case "Season":
+			seasons, ok := index.seasons[item.SeriesId]
+			if !ok {
+				seasons = make(map[int32]*GelatinLibraryItem)
+				index.seasons[item.SeriesId] = seasons
+			}
+			seasons[item.IndexNumber] = item
+		}
+	}
+
+	return index, nil
+}
+
+// libraryIndexes builds the library indexes of both services
+func (c *GelatinClient) libraryIndexes(ctx context.Context) (*libraryIndex, *libraryIndex, error) {
+	fromIndex, err := newLibraryIndex(ctx, c.from.Library())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	intoIndex, err := newLibraryIndex(ctx, c.into.Library())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return fromIndex, intoIndex, nil
+}
+
+// info returns what the library knows about an item, for matching
+func (x *libraryIndex) info(item *GelatinLibraryItem) *GelatinMatchInfo {
+	info := &GelatinMatchInfo{}
+	if x == nil || (item.Type != "Season" && item.Type != "Episode") {
+		return info
+	}
+
+	info.Series = x.series[item.SeriesId]
+
+	if item.Type == "Episode" {
+		info.AbsoluteNumber = x.absoluteNumber(item)
+	}
+
+	return info
+}
+
+// absoluteNumber returns the number of an episode counted across all regular seasons of its
+// series, or 0 if the episode counts of the earlier seasons are not known
+func (x *libraryIndex) absoluteNumber(item *GelatinLibraryItem) int {
+	if item.ParentIndexNumber < 1 || item.IndexNumber < 1 {
+		return 0
+	}
+
+	n := int(item.IndexNumber)
+	for season := int32(1); season < item.ParentIndexNumber; season++ {
+		s, ok := x.seasons[item.SeriesId][season]
+		if !ok || s.ChildCount == 0 {
+			return 0
+		}
+		n += int(s.ChildCount)
+	}
+
+	return n
+}
+
+// HasUserActivity returns true if the user has interacted with the item at all
+func HasUserActivity(item *GelatinLibraryItem) bool {
+	data := item.UserData
+	if data == nil {
+		return false
+	}
+
+	return data.Played || data.IsFavorite || data.PlaybackPositionTicks > 0 || data.PlayCount > 0 ||
+		data.Rating > 0 || data.Likes != nil
+}
+
+// GelatinWatchHistorySummary describes the result of migrating a single user's watch history
+type GelatinWatchHistorySummary struct {
+	// Username in the "from" service
+	Username string
+
+	// Username in the "into" service (differs from Username for renamed accounts)
+	IntoUsername string
+
+	// Number of items whose user activity was (or would be) updated in the into service
+	Updated int
+
+	// Number of items whose user activity was (or would be) updated in the from service
+	// (two-way sync only)
+	UpdatedFrom int
+
+	// Number of matched items that were already in sync or were skipped interactively
+	Skipped int
+
+	// Number of items with user activity in the from service that have no match in the into service
+	Unmatched int
+
+	// Number of items that match several items of the other service equally well, and were
+	// left alone
+	Ambiguous int
+
+	// Err is set if the migration failed for this user
+	Err error `json:"-"`
+}
+
+// GelatinWatchHistoryOpts selects the users migrated by MigrateAllWatchHistory
+type GelatinWatchHistoryOpts struct {
+	// Include limits the migration to these users (by "from" username). If empty, every user
+	// present in both services is migrated.
+	Include []string
+
+	// Exclude skips these users (by "from" username)
+	Exclude []string
+
+	// UsernameMap maps "from" usernames to "into" usernames for renamed accounts
+	UsernameMap map[string]string
+
+	// Since holds the high-water mark of users (by "from" username) whose watch history was
+	// migrated before. Only items whose user data changed since then are compared (see
+	// planUserWatchHistorySince). Users without a high-water mark are migrated in full.
+	Since map[string]time.Time
+}
+
+func (o *GelatinWatchHistoryOpts) since(username string) time.Time {
+	if o == nil {
+		return time.Time{}
+	}
+	return o.Since[username]
+}
+
+func (o *GelatinWatchHistoryOpts) intoUsername(username string) string {
+	if o != nil {
+		if name, ok := o.UsernameMap[username]; ok {
+			return name
+		}
+	}
+	return username
+}
+
+func (o *GelatinWatchHistoryOpts) selected(username string) bool {
+	if o == nil {
+		return true
+	}
+
+	for _, name := range o.Exclude {
+		if name == username {
+			return false
+		}
+	}
+
+	if len(o.Include) == 0 {
+		return true
+	}
+
+	for _, name := range o.Include {
+		if name == username {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MigrateUserWatchHistory migrates a user's watch history from one service to another.
+//
+// See PlanMigrateUserWatchHistory for details.
+func (c *GelatinClient) MigrateUserWatchHistory(ctx context.Context, username string) error {
+	plan, err := c.PlanMigrateUserWatchHistory(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	return c.Apply(ctx, plan)
+}
+
+// PlanMigrateUserWatchHistory computes the operations needed to migrate a user's
+// watch history from one service to another.
+//
+// If the user does not exist in either service, this method returns an error.
+func (c *GelatinClient) PlanMigrateUserWatchHistory(ctx context.Context, username string) (*GelatinPlan, error) {
+	fromUser, err := getUserByName(ctx, c.from, username)
+	if err != nil {
+		return nil, err
+	}
+
+	intoUser, err := getUserByName(ctx, c.into, username)
+	if err != nil {
+		return nil, err
+	}
+
+	fromIndex, intoIndex, err := c.libraryIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, _, err := c.planUserWatchHistory(ctx, fromUser, intoUser, fromIndex, intoIndex, "")
+
+	return plan, err
+}
+
+// MigrateAllWatchHistory migrates the watch history of every user present in both services.
+//
+// Users are migrated one at a time. A failure for one user does not stop the migration of
+// the remaining users; it is recorded in that user's summary and reflected in the returned error.
+//
+// If the client has a journal, users that were fully migrated by an earlier attempt of the
+// run are skipped.
+func (c *GelatinClient) MigrateAllWatchHistory(ctx context.Context, opts *GelatinWatchHistoryOpts) ([]GelatinWatchHistorySummary, error) {
+	return c.eachUserWatchHistory(ctx, opts, "", func(plan *GelatinPlan, summary *GelatinWatchHistorySummary) error {
+		return c.applyUserWatchHistory(ctx, plan, summary)
+	})
+}
+
+// applyUserWatchHistory applies the watch history plan of a single user, and updates the
+// summary with the operations that were actually applied
+func (c *GelatinClient) applyUserWatchHistory(ctx context.Context, plan *GelatinPlan, summary *GelatinWatchHistorySummary) error {
+	applied, err := c.apply(ctx, plan)
+
+	summary.Skipped += summary.Updated + summary.UpdatedFrom
+	summary.Updated, summary.UpdatedFrom = 0, 0
+	for i := range applied {
+		if !applied[i] {
+			continue
+		}
+
+		summary.Skipped--
+		if plan.Operations[i].Target == GelatinOperationTargetFrom {
+			summary.UpdatedFrom++
+		} else {
+			summary.Updated++
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return c.opts.Journal.Checkpoint(watchCheckpoint(summary.Username))
+}
+
+// PlanAllWatchHistory computes the operations needed to migrate the watch history of every
+// user present in both services. See MigrateAllWatchHistory.
+func (c *GelatinClient) PlanAllWatchHistory(ctx context.Context, opts *GelatinWatchHistoryOpts) (*GelatinPlan, []GelatinWatchHistorySummary, error) {
+	plan := &GelatinPlan{}
+	summaries, err := c.eachUserWatchHistory(ctx, opts, "", func(userPlan *GelatinPlan, _ *GelatinWatchHistorySummary) error {
+		plan.Merge(userPlan)
+		return nil
+	})
+
+	return plan, summaries, err
+}
+
+// watchCheckpoint is the journal checkpoint recorded once a user's watch history is migrated
+func watchCheckpoint(username string) string {
+	return "watch:" + username
+}
+
+// eachUserWatchHistory plans the watch history migration of each selected user and passes
+// the plan to fn. If policy is set, watch history is synced in both directions.
+func (c *GelatinClient) eachUserWatchHistory(ctx context.Context, opts *GelatinWatchHistoryOpts, policy GelatinConflictPolicy, fn func(*GelatinPlan, *GelatinWatchHistorySummary) error) ([]GelatinWatchHistorySummary, error) {
+	fromUsers, err := c.from.User().GetUsers(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	intoUsers, err := c.into.User().GetUsers(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// The library indexes are built once and shared by all users
+	fromIndex, intoIndex, err := c.libraryIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var summaries []GelatinWatchHistorySummary
+	failed := 0
+
+	for i := range fromUsers {
+		if err := ctx.Err(); err != nil {
+			return summaries, err
+		}
+
+		fromUser := &fromUsers[i]
+		if !opts.selected(fromUser.Name) {
+			continue
+		}
+
+		if c.opts.Journal.Reached(watchCheckpoint(fromUser.Name)) {
+			log.Printf("skipping %s: already migrated in run %s", fromUser.Name, c.opts.Journal.RunId())
+			continue
+		}
+
+		intoUsername := opts.intoUsername(fromUser.Name)
+		intoUser := findUserByName(intoUsers, intoUsername)
+		if intoUser == nil {
+			log.Printf("skipping %s: user %q not found in into service", fromUser.Name, intoUsername)
+			continue
+		}
+
+		var (
+			plan    *GelatinPlan
+			summary *GelatinWatchHistorySummary
+		)
+		if since := opts.since(fromUser.Name); !since.IsZero() {
+			plan, summary, err = c.planUserWatchHistorySince(ctx, fromUser, intoUser, fromIndex, intoIndex, policy, since)
+		} else {
+			plan, summary, err = c.planUserWatchHistory(ctx, fromUser, intoUser, fromIndex, intoIndex, policy)
+		}
+		if err == nil {
+			err = fn(plan, summary)
+		}
+
+		if err != nil {
+			log.Printf("failed to migrate watch history for %s: %s", fromUser.Name, err)
+			summary.Err = err
+			failed++
+		}
+
+		summaries = append(summaries, *summary)
+	}
+
+	if failed > 0 {
+		return summaries, fmt.Errorf("watch history migration failed for %d user(s)", failed)
+	}
+
+	return summaries, nil
+}
+
+// planUserWatchHistory computes the operations needed to migrate the watch history of a
+// single user.
+//
+// Migration works like this:
+//
+// 1. Fetch all movies and series from the "from" service.
+// 2. For each movie and series/episode, store an entry containing the user activity using the provider ID (IMDb, TMDB, TVDB)
+// 3. Fetch all items from the into service and compare the user activity state with that of the from service
+// 4. If there is a difference, plan an update of the into service with the latest state
+//
+// If policy is set, the watch history is synced instead: the policy picks the side that
+// wins, and the other side is updated (see PlanSyncUserWatchHistory).
+func (c *GelatinClient) planUserWatchHistory(ctx context.Context, fromUser, intoUser *GelatinUser, fromIndex, intoIndex *libraryIndex, policy GelatinConflictPolicy) (*GelatinPlan, *GelatinWatchHistorySummary, error) {
+	summary := &GelatinWatchHistorySummary{
+		Username:     fromUser.Name,
+		IntoUsername: intoUser.Name,
+	}
+
+	// Get all movies and series for the user in the from service
+	fromLibraryItems, err := c.from.Library().GetItemsByUser(ctx, fromUser.Id, map[string]string{
+		c.from.Library().GetItemFilterString(GelatinItemFilterIncludeItemTypes): "Movie,Series",
+	})
+	if err != nil {
+		return nil, summary, err
+	}
+
+	// Collect the items to match in the from service.
+	//
+	// Each item is handled by a worker with its own list. The lists are merged in library
+	// order afterwards, so that the result does not depend on scheduling.
+	itemLists := make([][]*GelatinLibraryItem, len(fromLibraryItems))
+	errs := runPool(ctx, c.opts.Concurrency, len(fromLibraryItems), func(i int) error {
+		item := &fromLibraryItems[i]
+
+		switch item.Type {
+		case "Movie":
+			itemLists[i] = []*GelatinLibraryItem{item}
+		case "Series":
+			// Recursively handle this series
+			return handleSeries(ctx, c.from.Library(), item, fromUser.Id, &itemLists[i])
+		}
+
+		return nil
+	})
+	if err := firstError(errs); err != nil {
+		return nil, summary, err
+	}
+
+	fromItems := newMatchIndex(c.opts.Matchers, fromIndex)
+	for _, items := range itemLists {
+		for _, item := range items {
+			fromItems.add(item)
+		}
+	}
+
+	p := newWatchPlanner(fromUser, intoUser, policy, summary)
+	matched := make(map[*GelatinLibraryItem]bool)
+
+	// Run through all library items tracked by the into service, a page at a time, and plan
+	// an update of the user watch state if it differs
+	err = c.into.Library().WalkItemsByUser(ctx, intoUser.Id, nil, func(items []GelatinLibraryItem) error {
+		for i := range items {
+			item := &items[i]
+			c.opts.Report.addInto(item)
+
+			match := fromItems.match(item, intoIndex)
+			if match == nil {
+				// This item is not present in the from service, so we can skip it
+				continue
+			}
+			c.opts.Report.addMatch(fromUser.Name, "into", item, match)
+
+			matched[match.Item] = true
+			if match.Ambiguous() {
+				// The candidates are accounted for by the ambiguous match
+				for _, candidate := range match.Alternatives {
+					matched[candidate] = true
+				}
+				summary.Ambiguous++
+				continue
+			}
+			p.compare(match.Item, item, match)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, summary, err
+	}
+
+	// Count items with activity in the from service that could not be matched
+	for _, item := range fromItems.items {
+		if !matched[item] && HasUserActivity(item) {
+			summary.Unmatched++
+			c.opts.Report.addUnmatched(fromUser.Name, item)
+		}
+	}
+
+	return p.plan, summary, nil
+}
+
+// watchPlanner plans the updates of matched items for a single user
+type watchPlanner struct {
+	fromUser, intoUser *GelatinUser
+	policy             GelatinConflictPolicy
+
+	plan    *GelatinPlan
+	summary *GelatinWatchHistorySummary
+
+	// A from item can match several into items, but must only be updated once
+	updatedFrom map[*GelatinLibraryItem]bool
+}
+
+func newWatchPlanner(fromUser, intoUser *GelatinUser, policy GelatinConflictPolicy, summary *GelatinWatchHistorySummary) *watchPlanner {
+	return &watchPlanner{
+		fromUser:    fromUser,
+		intoUser:    intoUser,
+		policy:      policy,
+		plan:        &GelatinPlan{},
+		summary:     summary,
+		updatedFrom: make(map[*GelatinLibraryItem]bool),
+	}
+}
+
+// compare plans an update of the into item (or, when syncing, of the from item) if the user
+// activity of a matched pair of items differs
+func (p *watchPlanner) compare(fromItem, item *GelatinLibraryItem, match *GelatinMatch) {
+	if item.UserData.IsMatch(fromItem.UserData) {
+		p.summary.Skipped++
+		return
+	}
+
+	// Items matched at different levels (e.g., an episode with a fully played
+	// season) are only ever migrated one way
+	if p.policy != "" && fromItem.Type == item.Type && !p.policy.fromWins(fromItem, item) {
+		if p.updatedFrom[fromItem] {
+			p.summary.Skipped++
+			return
+		}
+		p.updatedFrom[fromItem] = true
+
+		p.summary.UpdatedFrom++
+		p.plan.add(GelatinOperation{
+			Type:       GelatinOperationUpdateUserActivity,
+			Target:     GelatinOperationTargetFrom,
+			Username:   p.fromUser.Name,
+			UserId:     p.fromUser.Id,
+			ItemId:     fromItem.Id,
+			ItemName:   fromItem.Name,
+			ItemType:   fromItem.Type,
+			SeriesName: fromItem.SeriesName,
+			Old:        fromItem.UserData,
+			New:        item.UserData,
+
+			MatchStrategy:   match.Strategy,
+			MatchConfidence: match.Confidence,
+		})
+		return
+	}
+
+	p.summary.Updated++
+	p.plan.add(GelatinOperation{
+		Type:       GelatinOperationUpdateUserActivity,
+		Username:   p.intoUser.Name,
+		UserId:     p.intoUser.Id,
+		ItemId:     item.Id,
+		ItemName:   item.Name,
+		ItemType:   item.Type,
+		SeriesName: item.SeriesName,
+		Old:        item.UserData,
+		New:        fromItem.UserData,
+
+		MatchStrategy:   match.Strategy,
+		MatchConfidence: match.Confidence,
+	})
+}
@@ -0,0 +1,148 @@
+package gelatin_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	gelatin "github.com/aksiksi/gelatin/lib"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestWatchHistoryAmbiguousMatch(t *testing.T) {
+	// Two cuts of the same movie share a provider ID, so neither can be picked over the other
+	from, fromClient := newFakeServer(t, []gelatin.GelatinLibraryItem{
+		{Id: "heat", Name: "Heat", Type: "Movie", ProviderIds: map[string]string{"Imdb": "tt0113277"}},
+		{Id: "heat-extended", Name: "Heat (Extended)", Type: "Movie", ProviderIds: map[string]string{"Imdb": "tt0113277"}},
+		{Id: "ronin", Name: "Ronin", Type: "Movie", ProviderIds: map[string]string{"Imdb": "tt0122690"}},
+	})
+	from.setUserData("alice-id", "heat", gelatin.GelatinLibraryItemUserActivity{Played: true})
+	from.setUserData("alice-id", "heat-extended", gelatin.GelatinLibraryItemUserActivity{PlaybackPositionTicks: 100})
+	from.setUserData("alice-id", "ronin", gelatin.GelatinLibraryItemUserActivity{IsFavorite: true})
+
+	into, intoClient := newFakeServer(t, []gelatin.GelatinLibraryItem{
+		{Id: "into-heat", Name: "Heat", Type: "Movie", ProviderIds: map[string]string{"Imdb": "tt0113277"}},
+		{Id: "into-ronin", Name: "Ronin", Type: "Movie", ProviderIds: map[string]string{"Imdb": "tt0122690"}},
+	})
+
+	ctx := context.Background()
+	report := gelatin.NewMatchReport()
+	client := gelatin.NewGelatinClient(fromClient, intoClient, &gelatin.GelatinClientOpts{Report: report})
+
+	plan, summaries, err := client.PlanAllWatchHistory(ctx, nil)
+	if err != nil {
+		t.Fatalf("failed to plan watch history migration: %s", err)
+	}
+
+	if len(plan.Operations) != 1 || plan.Operations[0].ItemId != "into-ronin" {
+		t.Fatalf("expected a single update of into-ronin, got: %v", plan)
+	}
+	if len(summaries) != 1 || summaries[0].Ambiguous != 1 || summaries[0].Unmatched != 0 {
+		t.Errorf("unexpected summaries: %+v", summaries)
+	}
+	if len(report.Ambiguous) != 1 || len(report.Ambiguous[0].Candidates) != 2 {
+		t.Errorf("expected the ambiguous match to be reported, got: %+v", report.Ambiguous)
+	}
+
+	if err := client.Apply(ctx, plan); err != nil {
+		t.Fatalf("failed to apply plan: %s", err)
+	}
+	if data := into.getUserData("alice-id", "into-heat"); data.Played || data.PlaybackPositionTicks != 0 {
+		t.Errorf("expected into-heat to be left alone, got: %+v", data)
+	}
+	if data := into.getUserData("alice-id", "into-ronin"); !data.IsFavorite {
+		t.Errorf("expected into-ronin to be a favorite, got: %+v", data)
+	}
+
+	// Incremental syncs also match the items changed in the into service against the from
+	// service, where into-heat is ambiguous
+	into.setUserData("alice-id", "into-heat", gelatin.GelatinLibraryItemUserActivity{PlayCount: 2})
+	opts := &gelatin.GelatinWatchHistoryOpts{Since: map[string]time.Time{"alice": time.Now()}}
+	plan, summaries, err = client.PlanSyncAllWatchHistory(ctx, opts, gelatin.GelatinConflictNewest)
+	if err != nil {
+		t.Fatalf("failed to plan watch history sync: %s", err)
+	}
+	for _, op := range plan.Operations {
+		if op.ItemId == "into-heat" {
+			t.Errorf("expected into-heat to be left alone, got: %v", &op)
+		}
+	}
+	if len(summaries) != 1 || summaries[0].Ambiguous != 1 {
+		t.Errorf("unexpected summaries: %+v", summaries)
+	}
+}
+
+func TestMigrateAllWatchHistory(t *testing.T) {
+	from, fromClient := newFakeServer(t, []gelatin.GelatinLibraryItem{
+		{Id: "heat", Name: "Heat", Type: "Movie", ProviderIds: map[string]string{"Imdb": "tt0113277"}},
+		{Id: "ronin", Name: "Ronin", Type: "Movie", ProviderIds: map[string]string{"Imdb": "tt0122690"}},
+		{Id: "solaris", Name: "Solaris", Type: "Movie", ProviderIds: map[string]string{"Imdb": "tt0069293"}},
+	})
+	from.users = append(from.users,
+		gelatin.GelatinUser{Name: "bob", Id: "bob-id"},
+		gelatin.GelatinUser{Name: "carol", Id: "carol-id"},
+		gelatin.GelatinUser{Name: "dave", Id: "dave-id"},
+		gelatin.GelatinUser{Name: "erin", Id: "erin-id"},
+		gelatin.GelatinUser{Name: "frank", Id: "frank-id"},
+	)
+	from.setUserData("alice-id", "heat", gelatin.GelatinLibraryItemUserActivity{Played: true})
+	from.setUserData("alice-id", "ronin", gelatin.GelatinLibraryItemUserActivity{IsFavorite: true})
+	from.setUserData("bob-id", "ronin", gelatin.GelatinLibraryItemUserActivity{IsFavorite: true})
+	from.setUserData("bob-id", "solaris", gelatin.GelatinLibraryItemUserActivity{Played: true})
+	from.setUserData("carol-id", "heat", gelatin.GelatinLibraryItemUserActivity{Played: true})
+	from.setUserData("dave-id", "heat", gelatin.GelatinLibraryItemUserActivity{Played: true})
+	from.setUserData("frank-id", "heat", gelatin.GelatinLibraryItemUserActivity{Played: true})
+
+	// bob was renamed to robert, and erin has no account in the into service
+	into, intoClient := newFakeServer(t, []gelatin.GelatinLibraryItem{
+		{Id: "into-heat", Name: "Heat", Type: "Movie", ProviderIds: map[string]string{"Imdb": "tt0113277"}},
+		{Id: "into-ronin", Name: "Ronin", Type: "Movie", ProviderIds: map[string]string{"Imdb": "tt0122690"}},
+	})
+	into.users = append(into.users,
+		gelatin.GelatinUser{Name: "robert", Id: "robert-id"},
+		gelatin.GelatinUser{Name: "carol", Id: "carol-id"},
+		gelatin.GelatinUser{Name: "dave", Id: "dave-id"},
+		gelatin.GelatinUser{Name: "frank", Id: "frank-id"},
+	)
+	into.setUserData("alice-id", "into-ronin", gelatin.GelatinLibraryItemUserActivity{IsFavorite: true})
+
+	// The migration of carol fails, but the other users are still migrated
+	into.fail["POST /Users/carol-id/PlayedItems/into-heat"] = http.StatusInternalServerError
+
+	ctx := context.Background()
+	client := gelatin.NewGelatinClient(fromClient, intoClient, nil)
+	summaries, err := client.MigrateAllWatchHistory(ctx, &gelatin.GelatinWatchHistoryOpts{
+		Include:     []string{"alice", "bob", "carol", "dave", "erin"},
+		Exclude:     []string{"dave"},
+		UsernameMap: map[string]string{"bob": "robert"},
+	})
+	if err == nil {
+		t.Errorf("expected the migration of carol to fail")
+	}
+
+	want := []gelatin.GelatinWatchHistorySummary{
+		{Username: "alice", IntoUsername: "alice", Updated: 1, Skipped: 1},
+		{Username: "bob", IntoUsername: "robert", Updated: 1, Skipped: 1, Unmatched: 1},
+		{Username: "carol", IntoUsername: "carol", Skipped: 2},
+	}
+	if diff := cmp.Diff(want, summaries, cmpopts.IgnoreFields(gelatin.GelatinWatchHistorySummary{}, "Err")); diff != "" {
+		t.Errorf("-want,+got: %s", diff)
+	}
+	if len(summaries) == 3 && summaries[2].Err == nil {
+		t.Errorf("expected the summary of carol to hold the error")
+	}
+
+	if data := into.getUserData("alice-id", "into-heat"); !data.Played {
+		t.Errorf("expected into-heat to be played by alice, got: %+v", data)
+	}
+	if data := into.getUserData("robert-id", "into-ronin"); !data.IsFavorite {
+		t.Errorf("expected into-ronin to be a favorite of robert, got: %+v", data)
+	}
+	for _, userId := range []string{"dave-id", "frank-id"} {
+		if data := into.getUserData(userId, "into-heat"); data.Played {
+			t.Errorf("expected %s not to be migrated, got: %+v", userId, data)
+		}
+	}
+}
@@ -1,242 +1,116 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"io"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
-	"github.com/aksiksi/gelatin/emby"
-	"github.com/aksiksi/gelatin/jellyfin"
-	gelatin "github.com/aksiksi/gelatin/lib"
+	"github.com/aksiksi/gelatin/config"
 )
 
-var (
-	jellyfinAdminUser string
-	jellyfinAdminPass string
-	embyAdminUser     string
-	embyAdminPass     string
-)
-
-func verifyJellyfin() {
-	client := jellyfin.NewJellyfinApiClient("http://192.168.0.99:8097", nil)
-
-	if err := client.System().Ping(); err != nil {
-		log.Fatalf("failed to ping: %s", err)
-	}
-
-	adminKey, err := client.User().Authenticate(jellyfinAdminUser, jellyfinAdminPass)
-	if err != nil {
-		log.Fatal("failed to authenticate")
-	}
-
-	client.SetApiKey(adminKey)
-
-	systemInfo, err := client.System().Info(true)
-	if err != nil {
-		log.Fatalf("failed to get system info: %s", err)
-	}
-
-	log.Printf("Jellyfin info: %+v", systemInfo)
-
-	logsInfo, err := client.System().GetLogs()
-	if err != nil {
-		log.Fatalf("failed to get system logs: %s", err)
-	}
-
-	log.Printf("Jellyfin logs: %+v", logsInfo)
-
-	logName, logSize := logsInfo[0].Name, logsInfo[0].Size
-	data, err := client.System().GetLogFile(logName)
-	if err != nil {
-		log.Fatalf("failed to get system log %s: %s", logName, err)
-	}
-
-	logData, _ := io.ReadAll(data)
-
-	log.Printf("got log %s, size = %d, expected = %d", logName, len(logData), logSize)
-
-	// Query public users
-	_, err = client.User().GetUsers(true)
-	if err != nil {
-		log.Fatalf("failed to query users: %s", err)
-	}
-
-	// Query available users
-	users, err := client.User().GetUsers(false)
-	if err != nil {
-		log.Fatalf("failed to query users: %s", err)
-	}
-
-	log.Printf("Users count: %d", len(users))
-
-	// Create a new user
-	user, err := client.User().CreateUser("test123")
-	if err != nil {
-		log.Fatalf("failed to create new user: %s", err)
-	}
-
-	log.Printf("User: %v", user)
-
-	// Set user password
-	err = client.User().UpdatePassword(user.Id, "", "abcd1234", false)
-	log.Printf("%v", err)
-
-	// Make the user an admin
-	user.Policy.IsAdministrator = true
-	err = client.User().UpdatePolicy(user.Id, &user.Policy)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	items, err := client.Library().GetItemsByUser(user.Id, map[string]string{
-		"IncludeItemTypes": "Movie,Series",
-	})
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	log.Printf("Num items: %d", len(items))
-	log.Printf("Random item: %+v", items[len(items)/2])
-
-	// Delete the user
-	err = client.User().DeleteUser(user.Id)
-	if err != nil {
-		log.Fatal(err)
-	}
+// serverFlags overrides the connection info for a single server in the config
+//
+// Passwords and API keys are deliberately not exposed as flags: they would leak
+// into shell history and "ps". Use the config file or the environment instead.
+type serverFlags struct {
+	url      string
+	kind     string
+	username string
+	path     string
 }
 
-func verifyEmby() {
-	client := emby.NewEmbyApiClient("http://192.168.0.99:8096", nil)
-
-	if err := client.System().Ping(); err != nil {
-		log.Fatalf("failed to ping: %s", err)
-	}
-
-	adminKey, err := client.User().Authenticate(embyAdminUser, embyAdminPass)
-	if err != nil {
-		log.Fatalf("failed to authenticate")
-	}
-
-	client.SetApiKey(adminKey)
-
-	resp, err := client.System().Info(true)
-	if err != nil {
-		log.Fatalf("failed to get system info: %s", err)
-	}
-
-	log.Printf("Emby info: %+v", resp)
-
-	logsInfo, err := client.System().GetLogs()
-	if err != nil {
-		log.Fatalf("failed to get system logs: %s", err)
-	}
-
-	log.Printf("Emby logs: %+v", logsInfo)
-
-	logName, logSize := logsInfo[0].Name, logsInfo[0].Size
-	data, err := client.System().GetLogFile(logName)
-	if err != nil {
-		log.Fatalf("failed to get system log %s: %s", logName, err)
-	}
-
-	logData, _ := io.ReadAll(data)
-
-	log.Printf("got log %s, size = %d, expected = %d", logName, len(logData), logSize)
+func (s *serverFlags) register(fs *flag.FlagSet, name string) {
+	fs.StringVar(&s.url, name, "", fmt.Sprintf("URL of the %s server (e.g., http://localhost:8096)", name))
+	fs.StringVar(&s.kind, name+"-type", "", fmt.Sprintf("type of the %s server (emby, jellyfin or archive)", name))
+	fs.StringVar(&s.path, name+"-path", "", fmt.Sprintf("archive to read (with --%s-type archive)", name))
+	fs.StringVar(&s.username, name+"-user", "", fmt.Sprintf("admin username for the %s server", name))
+}
 
-	// Query public users
-	_, err = client.User().GetUsers(true)
-	if err != nil {
-		log.Fatalf("failed to query users: %s", err)
+// apply writes any flags that were set into the server config
+func (s *serverFlags) apply(c *config.ServerConfig) {
+	if s.url != "" {
+		c.Url = s.url
 	}
-
-	// Query available users
-	users, err := client.User().GetUsers(false)
-	if err != nil {
-		log.Fatalf("failed to query users: %s", err)
+	if s.kind != "" {
+		c.Type = s.kind
 	}
-
-	log.Printf("Users count: %d", len(users))
-
-	// Create a new user
-	user, err := client.User().CreateUser("test123")
-	if err != nil {
-		log.Fatalf("failed to create new user: %s", err)
+	if s.username != "" {
+		c.Username = s.username
 	}
-
-	log.Printf("User: %v", user)
-
-	// Set user password
-	err = client.User().UpdatePassword(user.Id, "", "abcd1234", false)
-	log.Printf("%v", err)
-
-	// Make the user an admin
-	user.Policy.IsAdministrator = true
-	err = client.User().UpdatePolicy(user.Id, &user.Policy)
-	if err != nil {
-		log.Fatal(err)
+	if s.path != "" {
+		c.Path = s.path
 	}
+}
 
-	items, err := client.Library().GetItemsByUser(user.Id, map[string]string{
-		"IncludeItemTypes": "Movie,Series",
-	})
-	if err != nil {
-		log.Fatal(err)
-	}
+// command is a single CLI subcommand
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
 
-	log.Printf("Num items: %d", len(items))
-	log.Printf("Random item: %+v", items[len(items)/2])
+var commands = []command{
+	{"users diff", "Diff the users on the from and into servers", runUsersDiff},
+	{"users migrate", "Reconcile the users on the into server with the from server", runUsersMigrate},
+	{"watch migrate", "Migrate watch history for one user (--user) or all users (--all)", runWatchMigrate},
+	{"watch sync", "Sync watch history in both directions for one user (--user) or all users (--all)", runWatchSync},
+	{"playlist migrate", "Migrate the playlists of a single user (--user)", runPlaylistMigrate},
+	{"collection migrate", "Recreate the collections of the from server on the into server", runCollectionMigrate},
+	{"sync", "Sync watch history in both directions for all users, periodically with --watch", runSync},
+	{"plan apply", "Apply a plan written with --dry-run --json", runPlanApply},
+	{"system info", "Print system info for the configured servers", runSystemInfo},
+	{"logs fetch", "Download a log file from a single server", runLogsFetch},
+	{"export", "Export the users and watch data of a single server to an archive", runExport},
+	{"undo", "Revert the changes made by a migration run: gelatin undo <run-id>", runUndo},
+}
 
-	// Delete the user
-	err = client.User().DeleteUser(user.Id)
-	if err != nil {
-		log.Fatal(err)
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "gelatin: import user & watch data into Jellyfin\n\n")
+	fmt.Fprintf(os.Stderr, "Usage:\n  gelatin <command> [<subcommand>] [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", cmd.name, cmd.usage)
 	}
+	fmt.Fprintf(os.Stderr, "\nRun \"gelatin <command> <subcommand> -h\" for the flags of a subcommand.\n")
 }
 
-func verifyGelatinClient() {
-	embyClient := emby.NewEmbyApiClient("http://192.168.0.99:8096", nil)
-	embyKey, _ := embyClient.User().Authenticate(embyAdminUser, embyAdminPass)
-	embyClient.SetApiKey(embyKey)
-
-	jellyfinClient := jellyfin.NewJellyfinApiClient("http://192.168.0.99:8097", nil)
-	jellyfinKey, _ := jellyfinClient.User().Authenticate(jellyfinAdminUser, jellyfinAdminPass)
-	jellyfinClient.SetApiKey(jellyfinKey)
-
-	opts := &gelatin.GelatinClientOpts{Interactive: true}
-	client := gelatin.NewGelatinClient(embyClient, jellyfinClient, opts)
+func main() {
+	log.SetFlags(0)
 
-	userDiff, err := client.DiffUsers(false)
-	if err != nil {
-		log.Fatal(err)
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
 	}
 
-	log.Printf("User diff: %s", userDiff)
-
-	if err := client.MigrateUsers(nil); err != nil {
-		log.Fatal(err)
+	name := strings.Join(os.Args[1:], " ")
+	if len(os.Args) > 2 {
+		name = fmt.Sprintf("%s %s", os.Args[1], os.Args[2])
 	}
 
-	if err := client.MigrateUserWatchHistory("tksiksi"); err != nil {
-		log.Print(err)
-	}
-}
+	for _, cmd := range commands {
+		// Commands are either a single word or a command and subcommand
+		words := strings.Count(cmd.name, " ") + 1
+		if len(os.Args) <= words || cmd.name != strings.Join(os.Args[1:1+words], " ") {
+			continue
+		}
 
-func main() {
-	flag.StringVar(&jellyfinAdminUser, "jellyfin-admin-user", "", "Jellyfin admin username")
-	flag.StringVar(&jellyfinAdminPass, "jellyfin-admin-pass", "", "Jellyfin admin password")
-	flag.StringVar(&embyAdminUser, "emby-admin-user", "", "Emby admin username")
-	flag.StringVar(&embyAdminPass, "emby-admin-pass", "", "Emby admin password")
-	flag.Parse()
-
-	if jellyfinAdminUser == "" || jellyfinAdminPass == "" {
-		log.Fatal("Jellyfin admin info must be specified")
-	}
+		// Cancel any in-flight requests on Ctrl-C
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err := cmd.run(ctx, os.Args[1+words:])
+		stop()
+
+		if err != nil {
+			log.Fatalf("%s: %s", cmd.name, err)
+		}
 
-	if embyAdminUser == "" || embyAdminPass == "" {
-		log.Fatal("Emby admin info must be specified")
+		return
 	}
 
-	verifyEmby()
-	verifyJellyfin()
-	verifyGelatinClient()
+	fmt.Fprintf(os.Stderr, "unknown command: %q\n\n", name)
+	printUsage()
+	os.Exit(2)
 }
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aksiksi/gelatin/config"
+	gelatin "github.com/aksiksi/gelatin/lib"
+)
+
+// runSync syncs the watch history of all selected users in both directions. With --watch, it
+// keeps running and syncs again periodically, recording the result of each pass in the sync
+// state file. Passes are only run on a timer: server events (e.g., webhooks or websocket
+// notifications) are not listened to.
+//
+// Users that were synced before are synced incrementally from their last successful sync,
+// except for a periodic full pass (or with --full).
+func runSync(ctx context.Context, args []string) error {
+	fs, f := newMigrationFlagSet("sync")
+	watch := fs.Bool("watch", false, "keep running and sync again every --interval (server events do not trigger a sync)")
+	interval := fs.Duration("interval", 0, "time between syncs with --watch (default from config: 15m)")
+	full := fs.Bool("full", false, "compare every item instead of only those changed since the last sync")
+	var w watchFlags
+	w.register(fs, "", true)
+	fs.Parse(args)
+
+	if f.resume != "" {
+		return fmt.Errorf("--resume cannot be used with sync: each pass starts a new run")
+	}
+	if *watch && f.dryRun {
+		return fmt.Errorf("--watch cannot be used with --dry-run")
+	}
+	if *watch && f.interactive {
+		return fmt.Errorf("--watch cannot be used with --interactive")
+	}
+
+	c, err := f.config()
+	if err != nil {
+		return err
+	}
+
+	opts, err := w.opts(c)
+	if err != nil {
+		return err
+	}
+
+	policy, err := w.policy(c)
+	if err != nil {
+		return err
+	}
+
+	period := *interval
+	if period <= 0 {
+		if period, err = c.Migration.Sync.IntervalDuration(); err != nil {
+			return err
+		}
+	}
+
+	fullPeriod, err := c.Migration.Sync.FullIntervalDuration()
+	if err != nil {
+		return err
+	}
+
+	statePath, err := c.Migration.SyncStatePath()
+	if err != nil {
+		return err
+	}
+
+	state, err := gelatin.LoadSyncState(statePath)
+	if err != nil {
+		return err
+	}
+
+	for {
+		// Incremental passes miss items added to a library since the last pass, so a full
+		// pass is run periodically
+		if *full || time.Since(state.LastFullRun) >= fullPeriod {
+			opts.Since = nil
+		} else {
+			opts.Since = state.Since()
+		}
+
+		err := syncPass(ctx, f, &w, c, opts, policy, state, statePath)
+		if !*watch {
+			return err
+		}
+
+		// A failed pass is retried on the next tick; only a shutdown stops the loop
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Sync failed: %s\n", err)
+		}
+
+		fmt.Fprintf(os.Stderr, "Next sync at %s\n", time.Now().Add(period).Format(time.RFC3339))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(period):
+		}
+	}
+}
+
+// syncPass runs a single sync of all selected users and records it in the sync state
+func syncPass(ctx context.Context, f *migrationFlags, w *watchFlags, c *config.Config, opts *gelatin.GelatinWatchHistoryOpts, policy gelatin.GelatinConflictPolicy, state *gelatin.GelatinSyncState, statePath string) error {
+	start := time.Now().UTC()
+
+	kind := "Full"
+	if opts.Since != nil {
+		kind = "Incremental"
+	}
+	fmt.Fprintf(os.Stderr, "%s sync started at %s\n", kind, start.Format(time.RFC3339))
+
+	// Connect on every pass, so that a server restart between passes is not fatal
+	client, err := c.Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	// With --watch, the report is rewritten on every pass
+	report := w.report.start(client)
+
+	if f.dryRun {
+		plan, summaries, err := client.PlanSyncAllWatchHistory(ctx, opts, policy)
+		if err == nil {
+			err = f.run(ctx, client, plan)
+		}
+		printWatchSummaries(summaries)
+		if reportErr := w.report.write(report); err == nil {
+			err = reportErr
+		}
+		return err
+	}
+
+	journal, err := f.startJournal(c, client)
+	if err != nil {
+		return err
+	}
+
+	summaries, err := client.SyncAllWatchHistory(ctx, opts, policy)
+	printWatchSummaries(summaries)
+
+	if reportErr := w.report.write(report); err == nil {
+		err = reportErr
+	}
+
+	if closeErr := closeSyncJournal(journal); err == nil {
+		err = closeErr
+	}
+
+	state.Update(start, summaries)
+	if err == nil && opts.Since == nil {
+		state.LastFullRun = start
+	}
+	if saveErr := state.Save(statePath); err == nil {
+		err = saveErr
+	}
+
+	return err
+}
+
+// closeSyncJournal closes the journal of a sync pass. Most passes of a continuous sync have
+// nothing to do, so journals without any applied operation are removed.
+func closeSyncJournal(journal *gelatin.GelatinJournal) error {
+	if err := journal.Close(); err != nil {
+		return err
+	}
+
+	for _, entry := range journal.Entries() {
+		if entry.Operation != nil {
+			return nil
+		}
+	}
+
+	return os.Remove(journal.Path())
+}